@@ -0,0 +1,78 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "io"
+
+// alignedReader reads from the underlying reader only in blocks of a
+// fixed size starting at offsets that are multiples of the size. The
+// data is buffered, so the consumer sees a continuous stream. Short
+// reads of the underlying reader are completed before a new block is
+// requested, which keeps the offsets aligned.
+type alignedReader struct {
+	r   io.Reader
+	buf []byte
+	// buf[i:n] contains the unread data
+	i, n int
+	err  error
+}
+
+// newAlignedReader creates a reader issuing reads of the given size to
+// r.
+func newAlignedReader(r io.Reader, size int) *alignedReader {
+	return &alignedReader{r: r, buf: make([]byte, size)}
+}
+
+// fill reads the next block into the buffer. It must only be called if
+// the buffer is empty.
+func (ar *alignedReader) fill() error {
+	if ar.err != nil {
+		return ar.err
+	}
+	if ar.n == len(ar.buf) {
+		ar.i, ar.n = 0, 0
+	}
+	for ar.i == ar.n {
+		k, err := ar.r.Read(ar.buf[ar.n:])
+		ar.n += k
+		if err != nil {
+			if err == io.EOF && ar.i < ar.n {
+				ar.err = io.EOF
+				return nil
+			}
+			ar.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads data from the buffer and refills it if required.
+func (ar *alignedReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if ar.i == ar.n {
+		if err = ar.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, ar.buf[ar.i:ar.n])
+	ar.i += n
+	return n, nil
+}
+
+// ReadByte reads a single byte. It allows the LZMA2 decoder to use the
+// buffer directly.
+func (ar *alignedReader) ReadByte() (c byte, err error) {
+	if ar.i == ar.n {
+		if err = ar.fill(); err != nil {
+			return 0, err
+		}
+	}
+	c = ar.buf[ar.i]
+	ar.i++
+	return c, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz/lzip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Format identifies a compression format supported by this module.
+type Format int
+
+// Formats recognized by DetectFormat. FormatGzip is not detected; it
+// is only supported by DecompressChain.
+const (
+	FormatUnknown Format = iota
+	FormatXZ
+	FormatLZMA
+	FormatLzip
+	FormatGzip
+)
+
+// formatStrings are used by the String method.
+var formatStrings = map[Format]string{
+	FormatUnknown: "unknown",
+	FormatXZ:      "xz",
+	FormatLZMA:    "lzma",
+	FormatLzip:    "lzip",
+	FormatGzip:    "gzip",
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	if s, ok := formatStrings[f]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// DetectHeaderLen is the number of bytes DetectFormat requires to
+// recognize all formats. It is the length of the classic LZMA header.
+const DetectHeaderLen = lzma.HeaderLen
+
+// lzipMagic stores the magic bytes of an lzip member.
+var lzipMagic = []byte{'L', 'Z', 'I', 'P'}
+
+// DetectFormat returns the format of the file starting with data. The
+// xz and lzip formats are identified by their magic bytes. The classic
+// LZMA format has no magic bytes; data is assumed to be in this format
+// if it starts with a plausible LZMA header, which requires
+// DetectHeaderLen bytes.
+func DetectFormat(data []byte) Format {
+	switch {
+	case len(data) >= len(headerMagic) &&
+		bytes.Equal(data[:len(headerMagic)], headerMagic):
+		return FormatXZ
+	case len(data) >= len(lzipMagic) &&
+		bytes.Equal(data[:len(lzipMagic)], lzipMagic):
+		return FormatLzip
+	case len(data) >= lzma.HeaderLen &&
+		lzma.ValidHeader(data[:lzma.HeaderLen]):
+		return FormatLZMA
+	}
+	return FormatUnknown
+}
+
+// AutoReader decompresses xz, lzip or classic LZMA files. The format
+// is detected from the first bytes of the file.
+type AutoReader struct {
+	r      io.Reader
+	format Format
+	close  func() error
+}
+
+// errUnknownFormat indicates that the format of the file could not be
+// detected.
+var errUnknownFormat = errors.New("xz: unknown compression format")
+
+// NewAutoReader detects the format of the file provided by r and
+// returns a reader decompressing it. The bytes read for the detection
+// are passed on to the decoder. The function may read more data from r
+// than required by the compressed file.
+func NewAutoReader(r io.Reader) (ar *AutoReader, err error) {
+	br := bufio.NewReader(r)
+	data, err := br.Peek(DetectHeaderLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	ar = &AutoReader{format: DetectFormat(data)}
+	switch ar.format {
+	case FormatXZ:
+		ar.r, err = NewReader(br)
+	case FormatLzip:
+		ar.r, err = lzip.NewReader(br)
+	case FormatLZMA:
+		var lr *lzma.Reader
+		if lr, err = lzma.NewReader(br); err == nil {
+			ar.r, ar.close = lr, lr.Close
+		}
+	default:
+		if len(data) == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, errUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+// Format returns the detected format.
+func (r *AutoReader) Format() Format {
+	return r.format
+}
+
+// Read reads decompressed data.
+func (r *AutoReader) Read(p []byte) (n int, err error) {
+	return r.r.Read(p)
+}
+
+// Close releases the resources of the decoder. It doesn't close the
+// underlying reader.
+func (r *AutoReader) Close() error {
+	if r.close != nil {
+		return r.close()
+	}
+	return nil
+}
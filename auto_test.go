@@ -0,0 +1,74 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestNewAutoReader(t *testing.T) {
+	const fox = "The quick brown fox jumps over the lazy dog.\n"
+	tests := []struct {
+		file   string
+		format Format
+	}{
+		{"fox.xz", FormatXZ},
+		{"lzma/fox.lzma", FormatLZMA},
+		{"lzip/testdata/fox.lz", FormatLzip},
+	}
+	for _, tc := range tests {
+		data, err := ioutil.ReadFile(tc.file)
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		if f := DetectFormat(data); f != tc.format {
+			t.Fatalf("%s: DetectFormat returned %s; want %s",
+				tc.file, f, tc.format)
+		}
+		f, err := os.Open(tc.file)
+		if err != nil {
+			t.Fatalf("Open error %s", err)
+		}
+		r, err := NewAutoReader(f)
+		if err != nil {
+			t.Fatalf("%s: NewAutoReader error %s", tc.file, err)
+		}
+		if r.Format() != tc.format {
+			t.Fatalf("%s: Format returned %s; want %s", tc.file,
+				r.Format(), tc.format)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.file, err)
+		}
+		if string(out) != fox {
+			t.Fatalf("%s: got %q; want %q", tc.file, out, fox)
+		}
+		if err = r.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", tc.file, err)
+		}
+		f.Close()
+	}
+}
+
+func TestNewAutoReaderErrors(t *testing.T) {
+	_, err := NewAutoReader(bytes.NewReader(nil))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("empty input: got error %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	_, err = NewAutoReader(bytes.NewReader([]byte("plain text file")))
+	if err != errUnknownFormat {
+		t.Fatalf("plain text: got error %v; want %v", err,
+			errUnknownFormat)
+	}
+	if f := DetectFormat(headerMagic[:3]); f != FormatUnknown {
+		t.Fatalf("DetectFormat of short data returned %s", f)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/lzip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// chain implements the WriteCloser returned by Chain.
+type chain struct {
+	stages []io.WriteCloser
+}
+
+// Chain combines the stages of a writer pipeline into a single
+// WriteCloser. The stages are given in the order of the data flow: the
+// first stage receives the writes and every stage must write into the
+// next one. So they are listed in the reverse order of their
+// construction.
+//
+// Close closes the stages in the given order, so each stage can flush
+// its data into the next stage before that one is closed. All stages
+// are closed even if a Close fails; the first error is returned.
+//
+//	xw, _ := xz.NewWriter(f)
+//	lw, _ := lzma.NewWriter(xw)
+//	w := xz.Chain(lw, xw, f)
+func Chain(stages ...io.WriteCloser) io.WriteCloser {
+	return &chain{stages: stages}
+}
+
+// Write writes p into the first stage.
+func (c *chain) Write(p []byte) (n int, err error) {
+	if len(c.stages) == 0 {
+		return 0, errors.New("xz: chain has no stages")
+	}
+	return c.stages[0].Write(p)
+}
+
+// Close closes all stages in the order of the data flow and returns the
+// first error.
+func (c *chain) Close() error {
+	var err error
+	for _, s := range c.stages {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// chainDecoder creates a decoder for a layer of DecompressChain. The
+// closer function may be nil.
+type chainDecoder func(r io.Reader) (dr io.Reader, closer func() error,
+	err error)
+
+// chainDecoders provides the decoders supported by DecompressChain.
+var chainDecoders = map[Format]chainDecoder{
+	FormatXZ: func(r io.Reader) (io.Reader, func() error, error) {
+		xr, err := NewReader(r)
+		return xr, nil, err
+	},
+	FormatLZMA: func(r io.Reader) (io.Reader, func() error, error) {
+		lr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lr, lr.Close, nil
+	},
+	FormatLzip: func(r io.Reader) (io.Reader, func() error, error) {
+		lr, err := lzip.NewReader(r)
+		return lr, nil, err
+	},
+	FormatGzip: func(r io.Reader) (io.Reader, func() error, error) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gr, gr.Close, nil
+	},
+}
+
+// readChain implements the ReadCloser returned by DecompressChain.
+type readChain struct {
+	r io.Reader
+	// close functions of the layers in the order of their creation
+	closers []func() error
+}
+
+// DecompressChain decompresses data that has been compressed several
+// times. The formats are given in the order of the decoding: the first
+// format is the outermost layer, which has been applied last. So data
+// compressed with gzip and then with lzma is decoded by
+//
+//	rc, err := xz.DecompressChain(f, xz.FormatLZMA, xz.FormatGzip)
+//
+// Close closes all decoders but not the underlying reader r.
+func DecompressChain(r io.Reader, formats ...Format) (io.ReadCloser,
+	error) {
+	if len(formats) == 0 {
+		return nil, errors.New("xz: chain has no formats")
+	}
+	c := &readChain{r: r}
+	for _, f := range formats {
+		dec, ok := chainDecoders[f]
+		if !ok {
+			c.Close()
+			return nil, fmt.Errorf(
+				"xz: format %s not supported by chain", f)
+		}
+		dr, closer, err := dec(c.r)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.r = dr
+		if closer != nil {
+			c.closers = append(c.closers, closer)
+		}
+	}
+	return c, nil
+}
+
+// Read reads the data decoded by the innermost layer.
+func (c *readChain) Read(p []byte) (n int, err error) {
+	return c.r.Read(p)
+}
+
+// Close closes the decoders from the innermost to the outermost layer
+// and returns the first error.
+func (c *readChain) Close() error {
+	var err error
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		if cerr := c.closers[i](); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	c.closers = nil
+	return err
+}
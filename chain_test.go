@@ -0,0 +1,166 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// All writers of the module can be used as chain stages.
+var (
+	_ io.WriteCloser = (*Writer)(nil)
+	_ io.WriteCloser = (*lzma.Writer)(nil)
+	_ io.WriteCloser = (*lzma.Writer2)(nil)
+	_ io.WriteCloser = (*lzip.Writer)(nil)
+)
+
+// stage records the order of Close calls in log.
+type stage struct {
+	bytes.Buffer
+	name string
+	log  *[]string
+	err  error
+}
+
+func (s *stage) Close() error {
+	*s.log = append(*s.log, s.name)
+	return s.err
+}
+
+func TestChainCloseOrder(t *testing.T) {
+	var log []string
+	errB := errors.New("b failed")
+	errC := errors.New("c failed")
+	a := &stage{name: "a", log: &log}
+	b := &stage{name: "b", log: &log, err: errB}
+	c := &stage{name: "c", log: &log, err: errC}
+	w := Chain(a, b, c)
+	if _, err := io.WriteString(w, "abc"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if a.String() != "abc" || b.Len() != 0 {
+		t.Fatalf("write didn't go into the first stage")
+	}
+	if err := w.Close(); err != errB {
+		t.Fatalf("Close returned %v; want %v", err, errB)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("close order %v; want %v", log, want)
+	}
+}
+
+func TestChainPipeline(t *testing.T) {
+	var log []string
+	sink := &stage{name: "sink", log: &log}
+	xw, err := NewWriter(sink)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	lw, err := lzma.NewWriter(xw)
+	if err != nil {
+		t.Fatalf("lzma.NewWriter error %s", err)
+	}
+	w := Chain(lw, xw, sink)
+	data := []byte("The quick brown fox jumps over the lazy dog.\n")
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if len(log) != 1 || log[0] != "sink" {
+		t.Fatalf("sink closes %v; want one", log)
+	}
+
+	xr, err := NewReader(&sink.Buffer)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	lr, err := lzma.NewReader(xr)
+	if err != nil {
+		t.Fatalf("lzma.NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(lr)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("got %q; want %q", out, data)
+	}
+}
+
+func TestDecompressChain(t *testing.T) {
+	const fox = "The quick brown fox jumps over the lazy dog.\n"
+	f, err := os.Open("testdata/fox.gz.lzma")
+	if err != nil {
+		t.Fatalf("Open error %s", err)
+	}
+	defer f.Close()
+	rc, err := DecompressChain(f, FormatLZMA, FormatGzip)
+	if err != nil {
+		t.Fatalf("DecompressChain error %s", err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(got) != fox {
+		t.Fatalf("got %q; want %q", got, fox)
+	}
+	if err = rc.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+
+	// three layers written by this module
+	var buf bytes.Buffer
+	xw, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	lw, err := lzip.NewWriter(xw)
+	if err != nil {
+		t.Fatalf("lzip.NewWriter error %s", err)
+	}
+	w := Chain(lw, xw)
+	if _, err = io.WriteString(w, fox); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	rc, err = DecompressChain(&buf, FormatXZ, FormatLzip)
+	if err != nil {
+		t.Fatalf("DecompressChain error %s", err)
+	}
+	if got, err = ioutil.ReadAll(rc); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(got) != fox {
+		t.Fatalf("got %q; want %q", got, fox)
+	}
+
+	if _, err = DecompressChain(bytes.NewReader(nil)); err == nil {
+		t.Fatalf("DecompressChain without formats returned no error")
+	}
+	if _, err = DecompressChain(bytes.NewReader(nil),
+		FormatUnknown); err == nil {
+		t.Fatalf("DecompressChain with FormatUnknown returned" +
+			" no error")
+	}
+	if _, err = DecompressChain(strings.NewReader(fox),
+		FormatGzip); err == nil {
+		t.Fatalf("DecompressChain of plain text returned no error")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrChecksum indicates that the trailer read by a ChecksumReader
+// doesn't match the checksum of the data.
+var ErrChecksum = errors.New("xz: checksum trailer mismatch")
+
+// trailerSize checks the trailer size for the hash h. The value zero
+// selects the full size of the hash sum.
+func trailerSize(h hash.Hash, size int) (int, error) {
+	if h == nil {
+		return 0, errors.New("xz: hash is nil")
+	}
+	if size == 0 {
+		size = h.Size()
+	}
+	if !(0 < size && size <= h.Size()) {
+		return 0, errors.New("xz: checksum trailer size out of range")
+	}
+	return size, nil
+}
+
+// ChecksumWriter passes the data written to the underlying writer and
+// appends a checksum of the data as trailer on Close. The wrapper is
+// independent of the xz format and can be used with any hash, e.g.
+// CRC-32, CRC-64 or xxHash.
+type ChecksumWriter struct {
+	w      io.Writer
+	h      hash.Hash
+	size   int
+	closed bool
+}
+
+// NewChecksumWriter creates a writer that computes the hash h of the
+// data and writes the first size bytes of the hash sum as trailer. The
+// size zero selects the full hash sum. The hash is reset before use.
+func NewChecksumWriter(w io.Writer, h hash.Hash, size int) (
+	*ChecksumWriter, error) {
+	size, err := trailerSize(h, size)
+	if err != nil {
+		return nil, err
+	}
+	h.Reset()
+	return &ChecksumWriter{w: w, h: h, size: size}, nil
+}
+
+// Write writes p to the underlying writer and adds the bytes written
+// to the checksum.
+func (cw *ChecksumWriter) Write(p []byte) (n int, err error) {
+	if cw.closed {
+		return 0, errClosed
+	}
+	n, err = cw.w.Write(p)
+	cw.h.Write(p[:n])
+	return n, err
+}
+
+// Close writes the checksum trailer. It doesn't close the underlying
+// writer.
+func (cw *ChecksumWriter) Close() error {
+	if cw.closed {
+		return errClosed
+	}
+	cw.closed = true
+	_, err := cw.w.Write(cw.h.Sum(nil)[:cw.size])
+	return err
+}
+
+// ChecksumReader reads data followed by a checksum trailer as written
+// by ChecksumWriter. Read returns only the data; the trailer is
+// verified at the end of the underlying reader. Data returned before
+// the end has not been verified yet.
+type ChecksumReader struct {
+	r    io.Reader
+	h    hash.Hash
+	size int
+	// data read but not returned; it includes the last size bytes
+	// read, which might be the trailer
+	buf []byte
+	eof bool
+	err error
+}
+
+// NewChecksumReader creates a reader for data followed by a trailer
+// consisting of the first size bytes of the hash sum of h. The size
+// zero selects the full hash sum. The hash is reset before use.
+func NewChecksumReader(r io.Reader, h hash.Hash, size int) (
+	*ChecksumReader, error) {
+	size, err := trailerSize(h, size)
+	if err != nil {
+		return nil, err
+	}
+	h.Reset()
+	return &ChecksumReader{r: r, h: h, size: size}, nil
+}
+
+// Read reads data from the underlying reader. It holds back the last
+// bytes read until it knows that they are not part of the trailer. At
+// the end of the data it returns io.EOF if the checksum is correct,
+// ErrChecksum if it is wrong and io.ErrUnexpectedEOF if the trailer is
+// incomplete.
+func (cr *ChecksumReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, cr.err
+	}
+	need := len(p) + cr.size
+	if cap(cr.buf) < need {
+		buf := make([]byte, len(cr.buf), need)
+		copy(buf, cr.buf)
+		cr.buf = buf
+	}
+	for n == 0 && cr.err == nil {
+		if !cr.eof && len(cr.buf) < need {
+			k, err := cr.r.Read(cr.buf[len(cr.buf):need])
+			cr.buf = cr.buf[:len(cr.buf)+k]
+			if err == io.EOF {
+				cr.eof = true
+			} else if err != nil {
+				cr.err = err
+			}
+		}
+		if m := len(cr.buf) - cr.size; m > 0 {
+			n = copy(p, cr.buf[:m])
+			cr.h.Write(p[:n])
+			cr.buf = cr.buf[:copy(cr.buf, cr.buf[n:])]
+		}
+		if cr.eof && len(cr.buf) <= cr.size {
+			cr.err = cr.verify()
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, cr.err
+}
+
+// verify checks the trailer at the end of the data.
+func (cr *ChecksumReader) verify() error {
+	if len(cr.buf) < cr.size {
+		return io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(cr.buf, cr.h.Sum(nil)[:cr.size]) {
+		return ErrChecksum
+	}
+	return io.EOF
+}
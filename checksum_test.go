@@ -0,0 +1,137 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// sumHash is a simple custom hash computing the byte sum and the byte
+// count.
+type sumHash struct {
+	sum, n uint32
+}
+
+func (h *sumHash) Write(p []byte) (n int, err error) {
+	for _, c := range p {
+		h.sum += uint32(c)
+	}
+	h.n += uint32(len(p))
+	return len(p), nil
+}
+
+func (h *sumHash) Sum(b []byte) []byte {
+	p := make([]byte, 8)
+	putUint32LE(p, h.sum)
+	putUint32LE(p[4:], h.n)
+	return append(b, p...)
+}
+
+func (h *sumHash) Reset()         { *h = sumHash{} }
+func (h *sumHash) Size() int      { return 8 }
+func (h *sumHash) BlockSize() int { return 1 }
+
+func TestChecksumWriterReader(t *testing.T) {
+	data := []byte(
+		"The quick brown fox jumps over the lazy dog.\n")
+	tests := []struct {
+		name string
+		h    func() hash.Hash
+		size int
+	}{
+		{"crc32", func() hash.Hash { return crc32.NewIEEE() }, 0},
+		{"crc64", func() hash.Hash {
+			return crc64.New(crc64.MakeTable(crc64.ECMA))
+		}, 0},
+		{"crc64/4", func() hash.Hash {
+			return crc64.New(crc64.MakeTable(crc64.ECMA))
+		}, 4},
+		{"custom", func() hash.Hash { return new(sumHash) }, 0},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		w, err := NewChecksumWriter(&buf, tc.h(), tc.size)
+		if err != nil {
+			t.Fatalf("%s: NewChecksumWriter error %s", tc.name, err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("%s: Write error %s", tc.name, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", tc.name, err)
+		}
+		h := tc.h()
+		h.Write(data)
+		size := tc.size
+		if size == 0 {
+			size = h.Size()
+		}
+		want := append(append([]byte{}, data...), h.Sum(nil)[:size]...)
+		z := buf.Bytes()
+		if !bytes.Equal(z, want) {
+			t.Fatalf("%s: got % x; want % x", tc.name, z, want)
+		}
+
+		for _, small := range []bool{false, true} {
+			var ur io.Reader = bytes.NewReader(z)
+			if small {
+				ur = iotest.OneByteReader(ur)
+			}
+			r, err := NewChecksumReader(ur, tc.h(), tc.size)
+			if err != nil {
+				t.Fatalf("%s: NewChecksumReader error %s",
+					tc.name, err)
+			}
+			var rr io.Reader = r
+			if small {
+				rr = iotest.OneByteReader(r)
+			}
+			got, err := ioutil.ReadAll(rr)
+			if err != nil {
+				t.Fatalf("%s: ReadAll error %s", tc.name, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s: got %q; want %q", tc.name, got,
+					data)
+			}
+		}
+
+		bad := append([]byte{}, z...)
+		bad[3] ^= 1
+		r, err := NewChecksumReader(bytes.NewReader(bad), tc.h(),
+			tc.size)
+		if err != nil {
+			t.Fatalf("%s: NewChecksumReader error %s", tc.name, err)
+		}
+		if _, err = ioutil.ReadAll(r); err != ErrChecksum {
+			t.Fatalf("%s: ReadAll returned %v; want %v", tc.name,
+				err, ErrChecksum)
+		}
+	}
+}
+
+func TestChecksumReaderShort(t *testing.T) {
+	r, err := NewChecksumReader(bytes.NewReader([]byte{1, 2}),
+		crc32.NewIEEE(), 0)
+	if err != nil {
+		t.Fatalf("NewChecksumReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAll returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	if _, err = NewChecksumWriter(ioutil.Discard, crc32.NewIEEE(),
+		5); err == nil {
+		t.Fatalf("NewChecksumWriter accepted trailer size 5 for" +
+			" CRC-32")
+	}
+}
@@ -50,9 +50,11 @@ type format struct {
 	validHeader func(br *bufio.Reader) bool
 }
 
-// dictCapExps maps preset values to exponent for dictionary capacity
-// sizes.
-var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+// presetDictCap returns the dictionary capacity for the preset
+// selected by the options.
+func presetDictCap(opts *options) int {
+	return lzma.Presets()[opts.preset].DictCap
+}
 
 // formats contains the formats supported by gxz.
 var formats = map[string]*format{
@@ -62,14 +64,14 @@ var formats = map[string]*format{
 			lc := lzma.WriterConfig{
 				Properties: &lzma.Properties{LC: 3, LP: 0,
 					PB: 2},
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap: presetDictCap(opts),
 			}
 			return lc.NewWriter(w)
 		},
 		newDecompressor: func(r io.Reader, opts *options,
 		) (d io.Reader, err error) {
 			lc := lzma.ReaderConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap: presetDictCap(opts),
 			}
 			return lc.NewReader(r)
 		},
@@ -85,14 +87,14 @@ var formats = map[string]*format{
 		newCompressor: func(w io.Writer, opts *options,
 		) (c io.WriteCloser, err error) {
 			cfg := xz.WriterConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap: presetDictCap(opts),
 			}
 			return cfg.NewWriter(w)
 		},
 		newDecompressor: func(r io.Reader, opts *options,
 		) (d io.Reader, err error) {
 			cfg := xz.ReaderConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap: presetDictCap(opts),
 			}
 			return cfg.NewReader(r)
 		},
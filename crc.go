@@ -6,8 +6,9 @@ package xz
 
 import (
 	"hash"
-	"hash/crc32"
 	"hash/crc64"
+
+	"github.com/ulikunitz/xz/internal/crc"
 )
 
 // crc32Hash implements the hash.Hash32 interface with Sum returning the
@@ -27,7 +28,7 @@ func (h crc32Hash) Sum(b []byte) []byte {
 // newCRC32 returns a CRC-32 hash that returns the 64-bit value in
 // little-endian encoding using the IEEE polynomial.
 func newCRC32() hash.Hash {
-	return crc32Hash{Hash32: crc32.NewIEEE()}
+	return crc32Hash{Hash32: crc.NewIEEE()}
 }
 
 // crc64Hash implements the Hash64 interface with Sum returning the
@@ -5,14 +5,16 @@
 package xz
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"hash"
-	"hash/crc32"
 	"io"
+	"strings"
 
+	"github.com/ulikunitz/xz/internal/crc"
 	"github.com/ulikunitz/xz/lzma"
 )
 
@@ -106,8 +108,13 @@ type header struct {
 	flags byte
 }
 
-// Errors returned by readHeader.
-var errHeaderMagic = errors.New("xz: invalid header magic bytes")
+// Errors returned for an invalid stream header. They allow to
+// distinguish data that isn't an xz stream at all from a corrupted
+// header.
+var (
+	ErrHeaderMagic    = errors.New("xz: invalid header magic bytes")
+	ErrHeaderChecksum = errors.New("xz: invalid checksum for file header")
+)
 
 // ValidHeader checks whether data is a correct xz file header. The
 // length of data must be HeaderLen.
@@ -117,6 +124,20 @@ func ValidHeader(data []byte) bool {
 	return err == nil
 }
 
+// ParseStreamHeader parses the xz stream header in b, which must have
+// HeaderLen bytes, and returns the check type stored in the low nibble
+// of the stream flags: None, CRC32, CRC64 or SHA256. It returns
+// ErrHeaderMagic if the magic bytes are wrong and ErrHeaderChecksum if
+// the CRC32 of the flags doesn't match. Reserved flag bits and
+// unsupported check types are reported as invalid flags.
+func ParseStreamHeader(b []byte) (flags byte, err error) {
+	var h header
+	if err = h.UnmarshalBinary(b); err != nil {
+		return 0, err
+	}
+	return h.flags, nil
+}
+
 // String returns a string representation of the flags.
 func (h header) String() string {
 	return flagString(h.flags)
@@ -131,14 +152,12 @@ func (h *header) UnmarshalBinary(data []byte) error {
 
 	// magic header
 	if !bytes.Equal(headerMagic, data[:6]) {
-		return errHeaderMagic
+		return ErrHeaderMagic
 	}
 
 	// checksum
-	crc := crc32.NewIEEE()
-	crc.Write(data[6:8])
-	if uint32LE(data[8:]) != crc.Sum32() {
-		return errors.New("xz: invalid checksum for file header")
+	if uint32LE(data[8:]) != crc.ChecksumIEEE(data[6:8]) {
+		return ErrHeaderChecksum
 	}
 
 	// stream flags
@@ -164,9 +183,7 @@ func (h *header) MarshalBinary() (data []byte, err error) {
 	copy(data, headerMagic)
 	data[7] = h.flags
 
-	crc := crc32.NewIEEE()
-	crc.Write(data[6:8])
-	putUint32LE(data[8:], crc.Sum32())
+	putUint32LE(data[8:], crc.ChecksumIEEE(data[6:8]))
 
 	return data, nil
 }
@@ -221,9 +238,7 @@ func (f *footer) MarshalBinary() (data []byte, err error) {
 	copy(data[10:], footerMagic)
 
 	// CRC-32
-	crc := crc32.NewIEEE()
-	crc.Write(data[4:10])
-	putUint32LE(data, crc.Sum32())
+	putUint32LE(data, crc.ChecksumIEEE(data[4:10]))
 
 	return data, nil
 }
@@ -241,9 +256,7 @@ func (f *footer) UnmarshalBinary(data []byte) error {
 	}
 
 	// CRC-32
-	crc := crc32.NewIEEE()
-	crc.Write(data[4:10])
-	if uint32LE(data) != crc.Sum32() {
+	if uint32LE(data) != crc.ChecksumIEEE(data[4:10]) {
 		return errors.New("xz: footer checksum error")
 	}
 
@@ -338,12 +351,55 @@ func readBlockHeader(r io.Reader) (h *blockHeader, n int, err error) {
 	// unmarshal block header
 	h = new(blockHeader)
 	if err = h.UnmarshalBinary(buf.Bytes()); err != nil {
+		if err == errHeaderPadding {
+			// the header is valid otherwise
+			return h, n, err
+		}
 		return nil, n, err
 	}
 
 	return h, n, nil
 }
 
+// PeekDictSize reads the stream header and the header of the first
+// block of an xz stream from r and returns the dictionary size of the
+// LZMA2 filter. Zero is returned for a stream without blocks. The
+// returned reader provides the complete stream including the bytes
+// consumed by the function, so it can be used to create the Reader for
+// the stream. The returned reader is valid even if an error is
+// returned.
+func PeekDictSize(r io.Reader) (dictSize int64, pr io.Reader, err error) {
+	var buf bytes.Buffer
+	pr = io.MultiReader(&buf, r)
+	tr := io.TeeReader(r, &buf)
+	data := make([]byte, HeaderLen)
+	if _, err = io.ReadFull(tr, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, pr, err
+	}
+	var h header
+	if err = h.UnmarshalBinary(data); err != nil {
+		return 0, pr, err
+	}
+	bh, _, err := readBlockHeader(tr)
+	if err != nil {
+		if err == errIndexIndicator {
+			return 0, pr, nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, pr, err
+	}
+	f, ok := bh.filters[len(bh.filters)-1].(*lzmaFilter)
+	if !ok {
+		return 0, pr, errors.New("xz: last filter isn't LZMA2")
+	}
+	return f.dictCap, pr, nil
+}
+
 // readSizeInBlockHeader reads the uncompressed or compressed size
 // fields in the block header. The present value informs the function
 // whether the respective field is actually present in the header.
@@ -376,9 +432,7 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	n := headerLen - 4
 
 	// Check CRC-32
-	crc := crc32.NewIEEE()
-	crc.Write(data[:n])
-	if crc.Sum32() != uint32LE(data[n:]) {
+	if crc.ChecksumIEEE(data[:n]) != uint32LE(data[n:]) {
 		return errors.New("xz: checksum error for block header")
 	}
 
@@ -422,11 +476,18 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	// The only reasonable approach seems to be to ignore the
 	// padding size. We still check that all padding bytes are zero.
 	if !allZeros(data[n-k : n]) {
-		return errPadding
+		return errHeaderPadding
 	}
 	return nil
 }
 
+// Errors for non-zero padding bytes. The header or index containing the
+// padding has been parsed completely if they are returned.
+var (
+	errHeaderPadding = errors.New("xz: non-zero block header padding")
+	errIndexPadding  = errors.New("xz: non-zero byte in index padding")
+)
+
 // MarshalBinary marshals the binary header.
 func (h *blockHeader) MarshalBinary() (data []byte, err error) {
 	if !(minFilters <= len(h.filters) && len(h.filters) <= maxFilters) {
@@ -497,9 +558,7 @@ func (h *blockHeader) MarshalBinary() (data []byte, err error) {
 	}
 	data[0] = byte(s)
 
-	crc := crc32.NewIEEE()
-	crc.Write(data[:len(data)-4])
-	putUint32LE(data[len(data)-4:], crc.Sum32())
+	putUint32LE(data[len(data)-4:], crc.ChecksumIEEE(data[:len(data)-4]))
 
 	return data, nil
 }
@@ -548,7 +607,7 @@ func readFilter(r io.Reader) (f filter, err error) {
 			return nil, errors.New(
 				"xz: reserved filter id in block stream header")
 		}
-		return nil, errors.New("xz: invalid filter id")
+		return nil, &UnsupportedFilterError{ID: id}
 	}
 	if err = f.UnmarshalBinary(data); err != nil {
 		return nil, err
@@ -556,17 +615,62 @@ func readFilter(r io.Reader) (f filter, err error) {
 	return f, err
 }
 
-// readFilters reads count filters. At this point in time only the count
-// 1 is supported.
+// readFilters reads count filters. Since only the LZMA2 filter is
+// supported, an UnsupportedFilterError is returned for the first
+// filter of a filter chain with more than one filter.
 func readFilters(r io.Reader, count int) (filters []filter, err error) {
-	if count != 1 {
+	if !(minFilters <= count && count <= maxFilters) {
 		return nil, errors.New("xz: unsupported filter count")
 	}
-	f, err := readFilter(r)
-	if err != nil {
-		return nil, err
+	filters = make([]filter, 0, count)
+	for i := 0; i < count; i++ {
+		f, err := readFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
 	}
-	return []filter{f}, err
+	return filters, nil
+}
+
+// ErrUnsupportedFilter is the target for errors.Is to detect an
+// UnsupportedFilterError.
+var ErrUnsupportedFilter = errors.New("xz: unsupported filter")
+
+// UnsupportedFilterError is returned if a block header declares a
+// filter that is not supported by the package. The ID allows callers
+// to report the missing filter and decide whether to fall back to
+// another decoder.
+type UnsupportedFilterError struct {
+	ID uint64
+}
+
+// filterNames provides the names of the filters defined by the xz
+// file format specification.
+var filterNames = map[uint64]string{
+	0x03: "Delta",
+	0x04: "x86 BCJ",
+	0x05: "PowerPC BCJ",
+	0x06: "IA-64 BCJ",
+	0x07: "ARM BCJ",
+	0x08: "ARM-Thumb BCJ",
+	0x09: "SPARC BCJ",
+	0x0a: "ARM64 BCJ",
+	0x0b: "RISC-V BCJ",
+}
+
+// Error returns the error message including the filter ID.
+func (e *UnsupportedFilterError) Error() string {
+	if name, ok := filterNames[e.ID]; ok {
+		return fmt.Sprintf("xz: unsupported filter %s (ID %#02x)",
+			name, e.ID)
+	}
+	return fmt.Sprintf("xz: unsupported filter ID %#02x", e.ID)
+}
+
+// Is supports errors.Is(err, ErrUnsupportedFilter).
+func (e *UnsupportedFilterError) Is(target error) bool {
+	return target == ErrUnsupportedFilter
 }
 
 /*** Index ***/
@@ -613,8 +717,8 @@ func (rec *record) MarshalBinary() (data []byte, err error) {
 
 // writeIndex writes the index, a sequence of records.
 func writeIndex(w io.Writer, index []record) (n int64, err error) {
-	crc := crc32.NewIEEE()
-	mw := io.MultiWriter(w, crc)
+	h := crc.NewIEEE()
+	mw := io.MultiWriter(w, h)
 
 	// index indicator
 	k, err := mw.Write([]byte{0})
@@ -653,7 +757,7 @@ func writeIndex(w io.Writer, index []record) (n int64, err error) {
 	}
 
 	// crc32 checksum
-	putUint32LE(p, crc.Sum32())
+	putUint32LE(p, h.Sum32())
 	k, err = w.Write(p[:4])
 	n += int64(k)
 
@@ -661,13 +765,15 @@ func writeIndex(w io.Writer, index []record) (n int64, err error) {
 }
 
 // readIndexBody reads the index from the reader. It assumes that the
-// index indicator has already been read.
+// index indicator has already been read. A negative expectedRecordLen
+// accepts any number of records. If the index padding contains
+// non-zero bytes, the records are returned with errIndexPadding.
 func readIndexBody(r io.Reader, expectedRecordLen int) (records []record, n int64, err error) {
-	crc := crc32.NewIEEE()
+	h := crc.NewIEEE()
 	// index indicator
-	crc.Write([]byte{0})
+	h.Write([]byte{0})
 
-	br := lzma.ByteReader(io.TeeReader(r, crc))
+	br := lzma.ByteReader(io.TeeReader(r, h))
 
 	// number of records
 	u, k, err := readUvarint(br)
@@ -679,7 +785,7 @@ func readIndexBody(r io.Reader, expectedRecordLen int) (records []record, n int6
 	if recLen < 0 || uint64(recLen) != u {
 		return nil, n, errors.New("xz: record number overflow")
 	}
-	if recLen != expectedRecordLen {
+	if expectedRecordLen >= 0 && recLen != expectedRecordLen {
 		return nil, n, fmt.Errorf(
 			"xz: index length is %d; want %d",
 			recLen, expectedRecordLen)
@@ -701,12 +807,10 @@ func readIndexBody(r io.Reader, expectedRecordLen int) (records []record, n int6
 	if err != nil {
 		return nil, n, err
 	}
-	if !allZeros(p) {
-		return nil, n, errors.New("xz: non-zero byte in index padding")
-	}
+	dirty := !allZeros(p)
 
 	// crc32
-	s := crc.Sum32()
+	s := h.Sum32()
 	p = p[:4]
 	k, err = io.ReadFull(br.(io.Reader), p)
 	n += int64(k)
@@ -716,6 +820,124 @@ func readIndexBody(r io.Reader, expectedRecordLen int) (records []record, n int6
 	if uint32LE(p) != s {
 		return nil, n, errors.New("xz: wrong checksum for index")
 	}
-
+	if dirty {
+		return records, n, errIndexPadding
+	}
 	return records, n, nil
 }
+
+/*** Footer Verification ***/
+
+// ErrFooter is the target for errors.Is to detect a FooterError.
+var ErrFooter = errors.New("xz: invalid stream footer")
+
+// FooterError is returned by VerifyXZFooter if the footer of the last
+// stream is corrupt or doesn't match the index or the stream header.
+type FooterError struct {
+	Err error
+}
+
+// Error returns the error message including the reason for the
+// mismatch.
+func (e *FooterError) Error() string {
+	return "xz: invalid stream footer: " +
+		strings.TrimPrefix(e.Err.Error(), "xz: ")
+}
+
+// Unwrap returns the reason for the mismatch.
+func (e *FooterError) Unwrap() error { return e.Err }
+
+// Is supports errors.Is(err, ErrFooter).
+func (e *FooterError) Is(target error) bool {
+	return target == ErrFooter
+}
+
+// VerifyXZFooter checks the footer of the last stream in the xz file
+// provided by r without decoding any block. The CRC-32 of the footer
+// and the index are verified, the backward size must match the size of
+// the index and the flags must match the stream header, whose position
+// is computed from the index records. Stream padding at the end of the
+// file is skipped.
+//
+// Corruption is reported by a *FooterError; other errors are returned
+// by the reader. The offset of r is undefined after the call.
+func VerifyXZFooter(r io.ReadSeeker) error {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	readAt := func(p []byte, off int64) error {
+		if off < 0 {
+			return &FooterError{errors.New("xz: file too short")}
+		}
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(r, p)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = &FooterError{errors.New("xz: file too short")}
+		}
+		return err
+	}
+
+	// skip stream padding
+	p := make([]byte, footerLen)
+	for {
+		if err = readAt(p[:4], end-4); err != nil {
+			return err
+		}
+		if !allZeros(p[:4]) {
+			break
+		}
+		end -= 4
+	}
+
+	if err = readAt(p, end-footerLen); err != nil {
+		return err
+	}
+	var f footer
+	if err = f.UnmarshalBinary(p); err != nil {
+		return &FooterError{err}
+	}
+
+	indexStart := end - footerLen - f.indexSize
+	if err = readAt(p[:1], indexStart); err != nil {
+		return err
+	}
+	if p[0] != 0 {
+		return &FooterError{errors.New(
+			"xz: backward size doesn't point to the index")}
+	}
+	ir := bufio.NewReader(io.LimitReader(r, f.indexSize-1))
+	records, n, err := readIndexBody(ir, -1)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errors.New("xz: index exceeds backward size")
+		}
+		return &FooterError{err}
+	}
+	if n+1 != f.indexSize {
+		return &FooterError{fmt.Errorf(
+			"xz: index size is %d; backward size is %d",
+			n+1, f.indexSize)}
+	}
+
+	start := indexStart - HeaderLen
+	for _, rec := range records {
+		start -= rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+	}
+	if err = readAt(p, start); err != nil {
+		return err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p); err != nil {
+		return &FooterError{fmt.Errorf(
+			"xz: no stream header at the offset given by the index: %w",
+			err)}
+	}
+	if h.flags != f.flags {
+		return &FooterError{errors.New(
+			"xz: footer flags don't match the stream header")}
+	}
+	return nil
+}
@@ -6,7 +6,13 @@ package xz
 
 import (
 	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/ulikunitz/xz/internal/crc"
 )
 
 func TestHeader(t *testing.T) {
@@ -140,3 +146,226 @@ func TestBlockHeader(t *testing.T) {
 		t.Errorf("got dictCap %d; want %d", glf.dictCap, hlf.dictCap)
 	}
 }
+
+func TestLZMAFilterDictCap(t *testing.T) {
+	if _, err := (lzmaFilter{1 << 32}).MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary accepted dictCap 1<<32")
+	}
+	var f lzmaFilter
+	if err := f.UnmarshalBinary([]byte{lzmaFilterID, 1, 41}); err == nil {
+		t.Errorf("UnmarshalBinary accepted dictionary size byte 41")
+	}
+	if err := f.UnmarshalBinary([]byte{lzmaFilterID, 1, 40}); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if f.dictCap != 1<<32-1 {
+		t.Errorf("got dictCap %#x; want %#x", f.dictCap,
+			int64(1<<32-1))
+	}
+}
+
+func TestUnsupportedFilter(t *testing.T) {
+	// filter ID 0x30 with a single property byte followed by the
+	// LZMA2 filter
+	data := []byte{0x30, 0x01, 0x00, lzmaFilterID, 0x01, 0x16}
+	_, err := readFilters(bytes.NewReader(data), 2)
+	var ferr *UnsupportedFilterError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("readFilters returned %v; want UnsupportedFilterError",
+			err)
+	}
+	if ferr.ID != 0x30 {
+		t.Fatalf("got filter ID %#02x; want %#02x", ferr.ID, 0x30)
+	}
+	if !errors.Is(err, ErrUnsupportedFilter) {
+		t.Fatalf("errors.Is(%v, ErrUnsupportedFilter) is false", err)
+	}
+
+	// file created with xz --x86 --lzma2
+	const file = "testdata/fox-x86.xz"
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("os.Open(%q) error %s", file, err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+	}
+	if !errors.As(err, &ferr) {
+		t.Fatalf("decoding %s returned %v; want UnsupportedFilterError",
+			file, err)
+	}
+	if ferr.ID != 0x04 || !strings.Contains(err.Error(), "x86") {
+		t.Fatalf("got error %q for filter ID %#02x; want x86 filter",
+			err, ferr.ID)
+	}
+}
+
+func TestPeekDictSize(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	for _, dictCap := range []int{1 << 16, 3 << 20, 8 << 20} {
+		var buf bytes.Buffer
+		w, err := WriterConfig{DictCap: dictCap}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write([]byte(text)); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		n, r, err := PeekDictSize(&buf)
+		if err != nil {
+			t.Fatalf("PeekDictSize error %s", err)
+		}
+		if n != int64(dictCap) {
+			t.Fatalf("PeekDictSize returned %d; want %d", n, dictCap)
+		}
+		xr, err := NewReader(r)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(xr)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(got) != text {
+			t.Fatalf("got %q; want %q", got, text)
+		}
+	}
+
+	// stream without blocks created by xz
+	data, err := ioutil.ReadFile("testdata/golden/empty.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	n, r, err := PeekDictSize(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("PeekDictSize error %s", err)
+	}
+	if n != 0 {
+		t.Fatalf("PeekDictSize returned %d for empty stream", n)
+	}
+	if err = VerifyStream(r); err != nil {
+		t.Fatalf("VerifyStream error %s", err)
+	}
+}
+
+func TestVerifyXZFooter(t *testing.T) {
+	fox, err := os.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := WriterConfig{BlockSize: 1000}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	txt := bytes.Repeat([]byte("The quick brown fox.\n"), 500)
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	blocks := buf.Bytes()
+
+	valid := map[string][]byte{
+		"fox":     fox,
+		"blocks":  blocks,
+		"padding": append(append([]byte{}, fox...), 0, 0, 0, 0),
+		"streams": append(append([]byte{}, fox...), blocks...),
+	}
+	for name, data := range valid {
+		if err := VerifyXZFooter(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: VerifyXZFooter error %s", name, err)
+		}
+	}
+
+	// setFooter replaces the footer of the stream and updates its
+	// CRC-32.
+	setFooter := func(data []byte, f func(footer []byte)) []byte {
+		data = append([]byte{}, data...)
+		p := data[len(data)-footerLen:]
+		f(p)
+		putUint32LE(p, crc.ChecksumIEEE(p[4:10]))
+		return data
+	}
+	tampered := map[string][]byte{
+		"footerCRC": func() []byte {
+			data := append([]byte{}, blocks...)
+			data[len(data)-footerLen]++
+			return data
+		}(),
+		"backwardSize": setFooter(blocks, func(p []byte) {
+			p[4]++
+		}),
+		"flags": setFooter(blocks, func(p []byte) {
+			p[9] = SHA256
+		}),
+		"indexCRC": func() []byte {
+			data := append([]byte{}, blocks...)
+			data[len(data)-footerLen-1]++
+			return data
+		}(),
+		"truncated": blocks[footerLen/2:],
+		"short":     fox[len(fox)-footerLen+1:],
+	}
+	for name, data := range tampered {
+		err := VerifyXZFooter(bytes.NewReader(data))
+		if !errors.Is(err, ErrFooter) {
+			t.Errorf("%s: VerifyXZFooter returned %v; want FooterError",
+				name, err)
+			continue
+		}
+		t.Logf("%s: %s", name, err)
+	}
+}
+
+func TestParseStreamHeader(t *testing.T) {
+	for c := 0; c < 16; c++ {
+		data := make([]byte, HeaderLen)
+		copy(data, headerMagic)
+		data[7] = byte(c)
+		putUint32LE(data[8:], crc.ChecksumIEEE(data[6:8]))
+		flags, err := ParseStreamHeader(data)
+		switch byte(c) {
+		case None, CRC32, CRC64, SHA256:
+			if err != nil {
+				t.Fatalf("check %#x: ParseStreamHeader error %s",
+					c, err)
+			}
+			if flags != byte(c) {
+				t.Fatalf("check %#x: got flags %#x", c, flags)
+			}
+		default:
+			if err != errInvalidFlags {
+				t.Fatalf("check %#x: got error %v; want %v",
+					c, err, errInvalidFlags)
+			}
+		}
+	}
+
+	h := header{flags: CRC64}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	corrupted := append([]byte(nil), data...)
+	corrupted[7] = CRC32
+	if _, err = ParseStreamHeader(corrupted); err != ErrHeaderChecksum {
+		t.Fatalf("corrupted flags: got error %v; want %v", err,
+			ErrHeaderChecksum)
+	}
+	corrupted = append([]byte(nil), data...)
+	corrupted[1] = '8'
+	if _, err = ParseStreamHeader(corrupted); err != ErrHeaderMagic {
+		t.Fatalf("corrupted magic: got error %v; want %v", err,
+			ErrHeaderMagic)
+	}
+	if _, err = ParseStreamHeader(data[:HeaderLen-1]); err == nil {
+		t.Fatalf("short header: no error")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlockInfo describes the position of a block in an xz file as given
+// by the index of its stream.
+type BlockInfo struct {
+	// offset of the block header in the file
+	Offset int64
+	// size of block header, compressed data and check
+	UnpaddedSize int64
+	// offset and size of the uncompressed data
+	UncompressedOffset int64
+	UncompressedSize   int64
+	// flags of the stream providing the check type
+	flags byte
+}
+
+// paddedSize returns the size of the block including the block padding.
+func (b BlockInfo) paddedSize() int64 {
+	return b.UnpaddedSize + int64(padLen(b.UnpaddedSize))
+}
+
+// ReadIndex reads the indexes of all streams of the xz file provided by
+// r, which has the given size, and returns the information of all
+// blocks in the order of the file. Only the stream headers, the indexes
+// and the footers are read; the blocks are not checked. Stream padding
+// is supported.
+func ReadIndex(r io.ReaderAt, size int64) (blocks []BlockInfo, err error) {
+	readAt := func(p []byte, off int64) error {
+		if off < 0 {
+			return errors.New("xz: file too short for index")
+		}
+		n, err := r.ReadAt(p, off)
+		if n == len(p) {
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	var streams [][]BlockInfo
+	p := make([]byte, footerLen)
+	end := size
+	for {
+		// skip stream padding
+		for end >= 4 {
+			if err = readAt(p[:4], end-4); err != nil {
+				return nil, err
+			}
+			if !allZeros(p[:4]) {
+				break
+			}
+			end -= 4
+		}
+		if end == 0 && len(streams) > 0 {
+			break
+		}
+
+		if err = readAt(p, end-footerLen); err != nil {
+			return nil, err
+		}
+		var f footer
+		if err = f.UnmarshalBinary(p); err != nil {
+			return nil, err
+		}
+		indexStart := end - footerLen - f.indexSize
+		if err = readAt(p[:1], indexStart); err != nil {
+			return nil, err
+		}
+		if p[0] != 0 {
+			return nil, errors.New(
+				"xz: backward size doesn't point to the index")
+		}
+		ir := bufio.NewReader(io.NewSectionReader(r, indexStart+1,
+			f.indexSize-1))
+		records, n, err := readIndexBody(ir, -1)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.New("xz: index exceeds backward size")
+			}
+			return nil, err
+		}
+		if n+1 != f.indexSize {
+			return nil, fmt.Errorf(
+				"xz: index size %d doesn't match backward size %d",
+				n+1, f.indexSize)
+		}
+
+		stream := make([]BlockInfo, len(records))
+		var blocksSize int64
+		for i, rec := range records {
+			stream[i] = BlockInfo{
+				UnpaddedSize:     rec.unpaddedSize,
+				UncompressedSize: rec.uncompressedSize,
+				flags:            f.flags,
+			}
+			blocksSize += stream[i].paddedSize()
+			if !(0 <= blocksSize && blocksSize <= indexStart) {
+				return nil, errors.New(
+					"xz: index exceeds stream size")
+			}
+		}
+		start := indexStart - blocksSize - HeaderLen
+		if err = readAt(p, start); err != nil {
+			return nil, err
+		}
+		var h header
+		if err = h.UnmarshalBinary(p); err != nil {
+			return nil, err
+		}
+		if h.flags != f.flags {
+			return nil, errors.New(
+				"xz: footer flags don't match stream header")
+		}
+		off := start + HeaderLen
+		for i := range stream {
+			stream[i].Offset = off
+			off += stream[i].paddedSize()
+		}
+		streams = append(streams, stream)
+		end = start
+		if end == 0 {
+			break
+		}
+	}
+
+	var uoff int64
+	for i := len(streams) - 1; i >= 0; i-- {
+		for _, b := range streams[i] {
+			b.UncompressedOffset = uoff
+			uoff += b.UncompressedSize
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// multiBlockFile returns text and an xz file of two streams separated
+// by stream padding, which store the text in blocks of blockSize
+// bytes.
+func multiBlockFile(t *testing.T, size int64, blockSize int64) (
+	txt, xzData []byte) {
+
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(161)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	half := len(txt) / 2
+	for i, p := range [][]byte{txt[:half], txt[half:]} {
+		if i > 0 {
+			buf.Write(make([]byte, 8))
+		}
+		w, err := WriterConfig{BlockSize: blockSize,
+			CheckSum: CRC32}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+	}
+	return txt, buf.Bytes()
+}
+
+func TestReadIndex(t *testing.T) {
+	txt, data := multiBlockFile(t, 100000, 16384)
+	blocks, err := ReadIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("ReadIndex error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	sizes := r.Blocks()
+	if len(blocks) != len(sizes) {
+		t.Fatalf("ReadIndex returned %d blocks; want %d",
+			len(blocks), len(sizes))
+	}
+	var uoff int64
+	for i, b := range blocks {
+		if b.UncompressedOffset != uoff {
+			t.Fatalf("block %d: uncompressed offset %d; want %d",
+				i, b.UncompressedOffset, uoff)
+		}
+		if b.UncompressedSize != sizes[i].UncompressedSize {
+			t.Fatalf("block %d: uncompressed size %d; want %d",
+				i, b.UncompressedSize,
+				sizes[i].UncompressedSize)
+		}
+		// The block header size is stored in its first byte.
+		hlen := (int64(data[b.Offset]) + 1) * 4
+		u := hlen + sizes[i].CompressedSize + 4
+		if b.UnpaddedSize != u {
+			t.Fatalf("block %d: unpadded size %d; want %d",
+				i, b.UnpaddedSize, u)
+		}
+		uoff += b.UncompressedSize
+	}
+	if uoff != int64(len(txt)) {
+		t.Fatalf("blocks cover %d bytes; want %d", uoff, len(txt))
+	}
+
+	for _, n := range []int{0, len(data) - 3, len(data) / 2} {
+		if _, err = ReadIndex(bytes.NewReader(data[:n]),
+			int64(n)); err == nil {
+			t.Fatalf("ReadIndex accepted file of %d bytes", n)
+		}
+	}
+}
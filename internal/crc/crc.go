@@ -0,0 +1,36 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package crc provides the CRC-32 checksum using the IEEE polynomial
+// as required by the xz, lzip and preset dictionary formats. All
+// consumers share the single table of the package, so no additional
+// tables need to be computed.
+package crc
+
+import (
+	"hash"
+	"hash/crc32"
+)
+
+// ieeeTable is the table shared by all users of the package. The
+// standard library uses architecture-specific implementations for it.
+var ieeeTable = crc32.IEEETable
+
+// NewIEEE creates a new hash computing the CRC-32 checksum using the
+// IEEE polynomial.
+func NewIEEE() hash.Hash32 {
+	return crc32.New(ieeeTable)
+}
+
+// ChecksumIEEE returns the CRC-32 checksum of data using the IEEE
+// polynomial.
+func ChecksumIEEE(data []byte) uint32 {
+	return crc32.Checksum(data, ieeeTable)
+}
+
+// UpdateIEEE returns the result of adding the bytes in p to the
+// checksum c.
+func UpdateIEEE(c uint32, p []byte) uint32 {
+	return crc32.Update(c, ieeeTable, p)
+}
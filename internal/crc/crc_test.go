@@ -0,0 +1,54 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crc
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"testing"
+)
+
+func TestChecksumIEEE(t *testing.T) {
+	tests := []struct {
+		data string
+		crc  uint32
+	}{
+		{"", 0},
+		{"a", 0xe8b7be43},
+		{"123456789", 0xcbf43926},
+		{"The quick brown fox jumps over the lazy dog", 0x414fa339},
+	}
+	for _, tc := range tests {
+		if c := ChecksumIEEE([]byte(tc.data)); c != tc.crc {
+			t.Errorf("ChecksumIEEE(%q) = %#08x; want %#08x",
+				tc.data, c, tc.crc)
+		}
+		h := NewIEEE()
+		h.Write([]byte(tc.data))
+		if c := h.Sum32(); c != tc.crc {
+			t.Errorf("NewIEEE for %q returns %#08x; want %#08x",
+				tc.data, c, tc.crc)
+		}
+	}
+}
+
+func TestUpdateIEEE(t *testing.T) {
+	rng := rand.New(rand.NewSource(135))
+	p := make([]byte, 10000)
+	rng.Read(p)
+	for _, n := range []int{0, 1, 7, 64, 1000, len(p)} {
+		want := crc32.ChecksumIEEE(p[:n])
+		if c := ChecksumIEEE(p[:n]); c != want {
+			t.Errorf("ChecksumIEEE(p[:%d]) = %#08x; want %#08x",
+				n, c, want)
+		}
+		k := n / 3
+		c := UpdateIEEE(UpdateIEEE(0, p[:k]), p[k:n])
+		if c != want {
+			t.Errorf("UpdateIEEE for p[:%d] = %#08x; want %#08x",
+				n, c, want)
+		}
+	}
+}
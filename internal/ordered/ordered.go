@@ -0,0 +1,99 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ordered runs independent jobs concurrently and writes their
+// results in the order of the jobs. It supports the parallel
+// decompression of blocks in the lzma and xz packages.
+package ordered
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// result holds the output of a single job.
+type result struct {
+	i    int
+	data []byte
+	err  error
+}
+
+// Write calls job for the indexes 0 to n-1 using the given number of
+// go routines and writes the returned data to w strictly in the order
+// of the indexes. Data of jobs that have been completed out of order is
+// buffered until all preceding data has been written; at most twice the
+// number of workers results are held in memory. The first error of a
+// job or of w stops the processing. The function returns the number of
+// bytes written to w.
+func Write(w io.Writer, n, workers int,
+	job func(i int) ([]byte, error)) (written int64, err error) {
+
+	if workers <= 0 {
+		return 0, errors.New("number of workers must be positive")
+	}
+	// tokens limits the number of jobs in flight
+	tokens := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	results := make(chan result)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for k := 0; k < workers; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p, err := job(i)
+				select {
+				case results <- result{i: i, data: p, err: err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	pending := make(map[int][]byte)
+	for next := 0; next < n; {
+		res := <-results
+		if res.err != nil {
+			return written, res.err
+		}
+		pending[res.i] = res.data
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			k, err := w.Write(p)
+			written += int64(k)
+			if err != nil {
+				return written, err
+			}
+			next++
+			<-tokens
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ordered
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	const n = 50
+	var want bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&want, "job %d\n", i)
+	}
+	// Earlier jobs take longer, so they complete out of order.
+	job := func(i int) ([]byte, error) {
+		time.Sleep(time.Duration(n-i) * 50 * time.Microsecond)
+		return []byte(fmt.Sprintf("job %d\n", i)), nil
+	}
+	for _, workers := range []int{1, 4, 16} {
+		var buf bytes.Buffer
+		k, err := Write(&buf, n, workers, job)
+		if err != nil {
+			t.Fatalf("workers %d: Write error %s", workers, err)
+		}
+		if k != int64(want.Len()) {
+			t.Fatalf("workers %d: Write returned %d; want %d",
+				workers, k, want.Len())
+		}
+		if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+			t.Fatalf("workers %d: output out of order", workers)
+		}
+	}
+
+	errJob := errors.New("job failed")
+	_, err := Write(&bytes.Buffer{}, n, 4, func(i int) ([]byte, error) {
+		if i == n/2 {
+			return nil, errJob
+		}
+		return []byte{byte(i)}, nil
+	})
+	if err != errJob {
+		t.Fatalf("Write returned error %v; want %v", err, errJob)
+	}
+	if _, err = Write(&bytes.Buffer{}, n, 0, job); err == nil {
+		t.Fatalf("Write accepted zero workers")
+	}
+}
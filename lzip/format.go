@@ -0,0 +1,168 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lzip supports the compression and decompression of lzip
+// files. An lzip file consists of members, each containing a header,
+// LZMA compressed data terminated by an end-of-stream marker and a
+// trailer. See https://www.nongnu.org/lzip/manual/lzip_manual.html
+package lzip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// uint32LE reads an uint32 integer from a byte slice.
+func uint32LE(b []byte) uint32 {
+	x := uint32(b[3]) << 24
+	x |= uint32(b[2]) << 16
+	x |= uint32(b[1]) << 8
+	x |= uint32(b[0])
+	return x
+}
+
+// uint64LE converts the uint64 value stored as little endian to an
+// uint64 value.
+func uint64LE(b []byte) uint64 {
+	return uint64(uint32LE(b[4:]))<<32 | uint64(uint32LE(b))
+}
+
+// putUint32LE puts an uint32 integer into a byte slice that must have
+// at least a length of 4 bytes.
+func putUint32LE(b []byte, x uint32) {
+	b[0] = byte(x)
+	b[1] = byte(x >> 8)
+	b[2] = byte(x >> 16)
+	b[3] = byte(x >> 24)
+}
+
+// putUint64LE puts the uint64 value into the byte slice as little
+// endian value. The byte slice b must have at least place for 8 bytes.
+func putUint64LE(b []byte, x uint64) {
+	putUint32LE(b, uint32(x))
+	putUint32LE(b[4:], uint32(x>>32))
+}
+
+// headerMagic stores the magic bytes of a member header.
+var headerMagic = []byte{'L', 'Z', 'I', 'P'}
+
+// version is the only supported version of the lzip format.
+const version = 1
+
+// HeaderLen provides the length of a member header.
+const HeaderLen = 6
+
+// TrailerLen provides the length of a member trailer.
+const TrailerLen = 20
+
+// Limits for the dictionary size supported by the lzip format.
+const (
+	MinDictCap = 1 << 12
+	MaxDictCap = 1 << 29
+)
+
+// decodeDictCap decodes the dictionary size byte of the header. The
+// lower 5 bits contain the base-2 logarithm of the base size. The upper
+// 3 bits give the number of sixteenths of the base size that have to be
+// subtracted from the base size.
+func decodeDictCap(c byte) (n int, err error) {
+	e := uint(c & 0x1f)
+	if !(12 <= e && e <= 29) {
+		return 0, errors.New("lzip: invalid dictionary size")
+	}
+	n = 1 << e
+	n -= int(c>>5) * (n >> 4)
+	if n < MinDictCap {
+		return 0, errors.New("lzip: invalid dictionary size")
+	}
+	return n, nil
+}
+
+// encodeDictCap encodes the dictionary size n. The size will be
+// rounded up to the next value supported by the encoding. The value n
+// must be in the range [MinDictCap,MaxDictCap].
+func encodeDictCap(n int) byte {
+	e := uint(12)
+	for 1<<e < n {
+		e++
+	}
+	base := 1 << e
+	f := 7
+	for ; f > 0; f-- {
+		if base-f*(base>>4) >= n {
+			break
+		}
+	}
+	return byte(f<<5) | byte(e)
+}
+
+// header represents the header of an lzip member.
+type header struct {
+	dictCap int
+}
+
+// errHeaderMagic indicates that the header magic is not found.
+var errHeaderMagic = errors.New("lzip: invalid header magic bytes")
+
+// UnmarshalBinary decodes the header from the given data.
+func (h *header) UnmarshalBinary(data []byte) error {
+	if len(data) != HeaderLen {
+		return errors.New("lzip: wrong header length")
+	}
+	if !bytes.Equal(data[:4], headerMagic) {
+		return errHeaderMagic
+	}
+	if data[4] != version {
+		return fmt.Errorf("lzip: unsupported version %d", data[4])
+	}
+	var err error
+	h.dictCap, err = decodeDictCap(data[5])
+	return err
+}
+
+// MarshalBinary encodes the header.
+func (h *header) MarshalBinary() (data []byte, err error) {
+	if !(MinDictCap <= h.dictCap && h.dictCap <= MaxDictCap) {
+		return nil, errors.New("lzip: dictionary size out of range")
+	}
+	data = make([]byte, HeaderLen)
+	copy(data, headerMagic)
+	data[4] = version
+	data[5] = encodeDictCap(h.dictCap)
+	return data, nil
+}
+
+// trailer represents the trailer of an lzip member.
+type trailer struct {
+	// CRC-32 of the uncompressed data
+	crc uint32
+	// size of the uncompressed data
+	dataSize int64
+	// size of the member including header and trailer
+	memberSize int64
+}
+
+// UnmarshalBinary decodes the trailer from the given data.
+func (t *trailer) UnmarshalBinary(data []byte) error {
+	if len(data) != TrailerLen {
+		return errors.New("lzip: wrong trailer length")
+	}
+	t.crc = uint32LE(data)
+	t.dataSize = int64(uint64LE(data[4:]))
+	t.memberSize = int64(uint64LE(data[12:]))
+	if t.dataSize < 0 || t.memberSize < 0 {
+		return errors.New("lzip: trailer sizes out of range")
+	}
+	return nil
+}
+
+// MarshalBinary encodes the trailer.
+func (t *trailer) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, TrailerLen)
+	putUint32LE(data, t.crc)
+	putUint64LE(data[4:], uint64(t.dataSize))
+	putUint64LE(data[12:], uint64(t.memberSize))
+	return data, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import "testing"
+
+func TestDictCapCoding(t *testing.T) {
+	tests := []struct {
+		n    int
+		c    byte
+		want int
+	}{
+		{MinDictCap, 0x0c, 1 << 12},
+		{1 << 16, 0x10, 1 << 16},
+		{48 << 10, 0x90, 48 << 10},
+		{(48 << 10) + 1, 0x70, 52 << 10},
+		{(1 << 16) + 1, 0xf1, 72 << 10},
+		{MaxDictCap, 0x1d, 1 << 29},
+	}
+	for _, tc := range tests {
+		c := encodeDictCap(tc.n)
+		if c != tc.c {
+			t.Errorf("encodeDictCap(%d) returned %#02x; want %#02x",
+				tc.n, c, tc.c)
+		}
+		n, err := decodeDictCap(c)
+		if err != nil {
+			t.Fatalf("decodeDictCap(%#02x) error %s", c, err)
+		}
+		if n != tc.want {
+			t.Errorf("decodeDictCap(%#02x) returned %d; want %d",
+				c, n, tc.want)
+		}
+	}
+	for _, c := range []byte{0x0b, 0x1e, 0x2c} {
+		if _, err := decodeDictCap(c); err == nil {
+			t.Errorf("decodeDictCap(%#02x) returned no error", c)
+		}
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	tr := trailer{crc: 0x12345678, dataSize: 45, memberSize: 80}
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	var g trailer
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if g != tr {
+		t.Fatalf("got %+v; want %+v", g, tr)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bufio"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/crc"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// countingReader counts the bytes read from the underlying byte
+// reader.
+type countingReader struct {
+	br io.ByteReader
+	n  int64
+}
+
+// ReadByte reads a single byte.
+func (r *countingReader) ReadByte() (c byte, err error) {
+	c, err = r.br.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return c, err
+}
+
+// Read reads data into p.
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if p[n], err = r.ReadByte(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// lzmaReader provides an LZMA stream in the classic format for the
+// member data. The header is generated from the member header and
+// followed by the data of the underlying reader.
+type lzmaReader struct {
+	header []byte
+	cr     *countingReader
+}
+
+// ReadByte reads a single byte.
+func (r *lzmaReader) ReadByte() (c byte, err error) {
+	if len(r.header) > 0 {
+		c = r.header[0]
+		r.header = r.header[1:]
+		return c, nil
+	}
+	return r.cr.ReadByte()
+}
+
+// Read reads data into p.
+func (r *lzmaReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if p[n], err = r.ReadByte(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// lzmaHeader returns the header of the classic LZMA format for an lzip
+// member. The properties are fixed and the size is always unknown.
+func lzmaHeader(dictCap int) []byte {
+	p := make([]byte, lzma.HeaderLen)
+	p[0] = lzma.Properties{LC: 3, LP: 0, PB: 2}.Code()
+	putUint32LE(p[1:], uint32(dictCap))
+	putUint64LE(p[5:], 1<<64-1)
+	return p
+}
+
+// Errors returned by the reader.
+var (
+	errCRC        = errors.New("lzip: CRC-32 of the data doesn't match")
+	errDataSize   = errors.New("lzip: data size doesn't match trailer")
+	errMemberSize = errors.New("lzip: member size doesn't match trailer")
+)
+
+// Reader decompresses lzip files. Multiple members are decoded one
+// after the other and returned as one stream of data. Trailing data
+// after the last member is not supported and results in an error.
+type Reader struct {
+	cr *countingReader
+	lr *lzma.Reader
+	// start of the current member
+	start int64
+	// uncompressed size of the current member
+	n   int64
+	crc hash.Hash32
+	eof bool
+}
+
+// NewReader creates a reader for an lzip file. The header of the first
+// member is read and checked. The reader might read more data from r
+// than required, unless r implements io.ByteReader.
+func NewReader(r io.Reader) (lr *Reader, err error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	lr = &Reader{cr: &countingReader{br: br}, crc: crc.NewIEEE()}
+	if err = lr.readHeader(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return lr, nil
+}
+
+// readHeader reads the header of the next member and prepares the LZMA
+// reader. If r doesn't provide any data io.EOF is returned.
+func (r *Reader) readHeader() error {
+	r.start = r.cr.n
+	p := make([]byte, HeaderLen)
+	if _, err := io.ReadFull(r.cr, p); err != nil {
+		return err
+	}
+	var h header
+	if err := h.UnmarshalBinary(p); err != nil {
+		return err
+	}
+	var err error
+	r.lr, err = lzma.ReaderConfig{DictCap: lzma.MinDictCap}.NewReader(
+		&lzmaReader{header: lzmaHeader(h.dictCap), cr: r.cr})
+	if err != nil {
+		return err
+	}
+	r.n = 0
+	r.crc.Reset()
+	return nil
+}
+
+// readTrailer reads the trailer of the current member and checks it.
+func (r *Reader) readTrailer() error {
+	p := make([]byte, TrailerLen)
+	if _, err := io.ReadFull(r.cr, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var t trailer
+	if err := t.UnmarshalBinary(p); err != nil {
+		return err
+	}
+	if t.crc != r.crc.Sum32() {
+		return errCRC
+	}
+	if t.dataSize != r.n {
+		return errDataSize
+	}
+	if t.memberSize != r.cr.n-r.start {
+		return errMemberSize
+	}
+	return nil
+}
+
+// Read reads uncompressed data from the lzip file. The trailer of each
+// member is checked after its data has been decompressed.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if r.eof {
+			return n, io.EOF
+		}
+		k, err := r.lr.Read(p[n:])
+		r.crc.Write(p[n : n+k])
+		r.n += int64(k)
+		n += k
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		if err = r.readTrailer(); err != nil {
+			return n, err
+		}
+		if err = r.readHeader(); err != nil {
+			if err == io.EOF && r.cr.n == r.start {
+				r.eof = true
+				continue
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
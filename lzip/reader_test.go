@@ -0,0 +1,96 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// The files in testdata are to be created with the lzip tool in the
+// testdata directory:
+//
+//	fox='The quick brown fox jumps over the lazy dog.'
+//	echo "$fox" | lzip >fox.lz
+//	lzip -c ../../lzma/examples/a.txt >multi.lz
+//	lzip </dev/null >>multi.lz
+//	printf '%s\n%s\n%s\n' "$fox" "$fox" "$fox" | lzip >>multi.lz
+//
+// TODO: The current files have the same members, but have been created
+// with the LZMA encoder of liblzma in the classic format and the lzip
+// framing following the lzip specification, since lzip wasn't
+// available. Replace them with files created by the commands above.
+
+const fox = "The quick brown fox jumps over the lazy dog.\n"
+
+func readFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	return data
+}
+
+func TestReader(t *testing.T) {
+	txt := readFile(t, "../lzma/examples/a.txt")
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"testdata/fox.lz", fox},
+		{"testdata/multi.lz", string(txt) + strings.Repeat(fox, 3)},
+	}
+	for _, tc := range tests {
+		r, err := NewReader(bytes.NewReader(readFile(t, tc.file)))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.file, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.file, err)
+		}
+		if string(p) != tc.want {
+			t.Fatalf("%s: got %q; want %q", tc.file, p, tc.want)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	data := readFile(t, "testdata/fox.lz")
+	modify := func(i int) []byte {
+		p := append([]byte(nil), data...)
+		p[i] ^= 1
+		return p
+	}
+	n := len(data)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"crc", modify(n - TrailerLen), errCRC},
+		{"data size", modify(n - TrailerLen + 4), errDataSize},
+		{"member size", modify(n - 8), errMemberSize},
+		{"truncated", data[:n-1], io.ErrUnexpectedEOF},
+		{"trailing data", append(append([]byte(nil), data...),
+			"garbage"...), errHeaderMagic},
+	}
+	for _, tc := range tests {
+		r, err := NewReader(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		if _, err = ioutil.ReadAll(r); err != tc.err {
+			t.Errorf("%s: ReadAll returned error %v; want %v",
+				tc.name, err, tc.err)
+		}
+	}
+	if _, err := NewReader(bytes.NewReader(modify(0))); err != errHeaderMagic {
+		t.Errorf("NewReader returned %v; want %v", err, errHeaderMagic)
+	}
+}
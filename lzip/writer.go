@@ -0,0 +1,144 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/crc"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// WriterConfig describes the parameters for an lzip writer.
+type WriterConfig struct {
+	// DictCap is the dictionary capacity. It is rounded up to the
+	// next value supported by the lzip header. The zero value
+	// selects 8 MiB.
+	DictCap int
+}
+
+// fill replaces zero values with default values.
+func (c *WriterConfig) fill() {
+	if c.DictCap == 0 {
+		c.DictCap = 8 * 1024 * 1024
+	}
+}
+
+// Verify checks the configuration for errors. Zero values will be
+// replaced by default values.
+func (c *WriterConfig) Verify() error {
+	if c == nil {
+		return errors.New("lzip: writer configuration is nil")
+	}
+	c.fill()
+	if !(MinDictCap <= c.DictCap && c.DictCap <= MaxDictCap) {
+		return errors.New("lzip: dictionary capacity is out of range")
+	}
+	return nil
+}
+
+// skipWriter drops the first skip bytes written to it and counts the
+// bytes written to the underlying writer.
+type skipWriter struct {
+	w    io.Writer
+	skip int
+	n    int64
+}
+
+// Write writes the data after the skipped bytes to the underlying
+// writer.
+func (w *skipWriter) Write(p []byte) (n int, err error) {
+	k := len(p)
+	if w.skip > 0 {
+		if w.skip >= k {
+			w.skip -= k
+			return k, nil
+		}
+		p = p[w.skip:]
+		w.skip = 0
+	}
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return k - len(p) + n, err
+}
+
+// Writer compresses data into a single lzip member.
+type Writer struct {
+	w   io.Writer
+	sw  skipWriter
+	lw  *lzma.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+// NewWriter creates a new lzip writer with the default configuration.
+// The header of the member is written to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return WriterConfig{}.NewWriter(w)
+}
+
+// NewWriter creates a new lzip writer. The header of the member is
+// written to w.
+func (c WriterConfig) NewWriter(w io.Writer) (lw *Writer, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	h := header{dictCap: c.DictCap}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	// use the dictionary size actually stored in the header
+	if h.dictCap, err = decodeDictCap(data[5]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	lw = &Writer{
+		w:   w,
+		sw:  skipWriter{w: w, skip: lzma.HeaderLen},
+		crc: crc.NewIEEE(),
+	}
+	// The LZMA header is dropped by the skipWriter.
+	lc := lzma.WriterConfig{
+		Properties: &lzma.Properties{LC: 3, LP: 0, PB: 2},
+		DictCap:    h.dictCap,
+		EOSMarker:  true,
+	}
+	if lw.lw, err = lc.NewWriter(&lw.sw); err != nil {
+		return nil, err
+	}
+	return lw, nil
+}
+
+// Write compresses the data in p.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	n, err = w.lw.Write(p)
+	w.crc.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// Close finishes the member by writing the end-of-stream marker and
+// the trailer. Close doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.lw.Close(); err != nil {
+		return err
+	}
+	t := trailer{
+		crc:        w.crc.Sum32(),
+		dataSize:   w.n,
+		memberSize: HeaderLen + w.sw.n + TrailerLen,
+	}
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(data)
+	return err
+}
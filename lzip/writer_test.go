@@ -0,0 +1,56 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	txt := readFile(t, "../lzma/examples/a.txt")
+	var buf bytes.Buffer
+	for i, p := range [][]byte{txt, nil, []byte(fox)} {
+		w, err := WriterConfig{DictCap: 50000}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if i == 0 {
+			data := buf.Bytes()
+			if data[5] != 0x70 {
+				t.Errorf("dictionary size byte %#02x; want %#02x",
+					data[5], 0x70)
+			}
+			var tr trailer
+			err = tr.UnmarshalBinary(data[len(data)-TrailerLen:])
+			if err != nil {
+				t.Fatalf("UnmarshalBinary error %s", err)
+			}
+			if tr.memberSize != int64(len(data)) {
+				t.Errorf("member size %d; want %d",
+					tr.memberSize, len(data))
+			}
+		}
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	want := string(txt) + fox
+	if string(p) != want {
+		t.Fatalf("got %q; want %q", p, want)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// BufferAllocator provides the memory for the dictionary buffer of a
+// Writer or Reader. It allows callers to back the dictionary by pooled
+// or memory-mapped storage. Alloc must return a slice of length n; the
+// content of the slice doesn't need to be zeroed. Free is called with
+// the slice returned by Alloc, if the Writer or Reader is closed. The
+// slice is not used anymore after that.
+type BufferAllocator interface {
+	Alloc(n int) []byte
+	Free(p []byte)
+}
+
+// newBufferAlloc creates a buffer with the given size using the
+// allocator a. If a is nil, the buffer is allocated with make.
+func newBufferAlloc(size int, a BufferAllocator) (*buffer, error) {
+	if a == nil {
+		return newBuffer(size), nil
+	}
+	data := a.Alloc(size + 1)
+	if len(data) != size+1 {
+		return nil, errors.New(
+			"lzma: allocator returned buffer of wrong length")
+	}
+	return &buffer{data: data}, nil
+}
+
+// free returns the data of the buffer to the allocator a and empties
+// the buffer. If a is nil only the buffer is emptied.
+func (b *buffer) free(a BufferAllocator) {
+	if a != nil && b.data != nil {
+		a.Free(b.data)
+	}
+	*b = buffer{}
+}
+
+// sliceAllocator provides a single caller-supplied slice as buffer.
+type sliceAllocator []byte
+
+// Alloc returns the first n bytes of the slice.
+func (a sliceAllocator) Alloc(n int) []byte {
+	if n > len(a) {
+		return nil
+	}
+	return a[:n]
+}
+
+// Free does nothing, since the slice is owned by the caller.
+func (a sliceAllocator) Free(p []byte) {}
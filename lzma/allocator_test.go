@@ -0,0 +1,117 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// countingAllocator counts the calls of Alloc and Free and checks that
+// only allocated buffers are freed.
+type countingAllocator struct {
+	allocs, frees int
+	live          map[*byte]int
+}
+
+func (a *countingAllocator) Alloc(n int) []byte {
+	if a.live == nil {
+		a.live = make(map[*byte]int)
+	}
+	a.allocs++
+	p := make([]byte, n)
+	// simulate reused memory
+	for i := range p {
+		p[i] = 0xa5
+	}
+	a.live[&p[0]] = n
+	return p
+}
+
+func (a *countingAllocator) Free(p []byte) {
+	a.frees++
+	if n, ok := a.live[&p[0]]; !ok || n != len(p) {
+		panic("Free called for buffer not returned by Alloc")
+	}
+	delete(a.live, &p[0])
+}
+
+func TestBufferAllocator(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(154)), 200000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+
+	var wa countingAllocator
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		DictCap:   MinDictCap,
+		Allocator: &wa,
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if wa.allocs != 1 || wa.frees != 0 {
+		t.Fatalf("after NewWriter: allocs %d, frees %d; want 1, 0",
+			wa.allocs, wa.frees)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if wa.allocs != 1 || wa.frees != 1 {
+		t.Fatalf("after Close: allocs %d, frees %d; want 1, 1",
+			wa.allocs, wa.frees)
+	}
+	if _, err = w.Write(txt); err == nil {
+		t.Fatalf("Write after Close returned no error")
+	}
+	if err = w.Close(); err == nil {
+		t.Fatalf("second Close returned no error")
+	}
+	want := compressWithConfig(t, WriterConfig{DictCap: MinDictCap}, txt)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("output differs from writer without allocator")
+	}
+
+	var ra countingAllocator
+	r, err := ReaderConfig{Allocator: &ra}.NewReader(
+		bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, txt) {
+		t.Fatalf("decompressed data differs")
+	}
+	if ra.allocs != 1 || ra.frees != 0 {
+		t.Fatalf("after ReadAll: allocs %d, frees %d; want 1, 0",
+			ra.allocs, ra.frees)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatalf("Reader.Close error %s", err)
+	}
+	if ra.allocs != 1 || ra.frees != 1 {
+		t.Fatalf("after Close: allocs %d, frees %d; want 1, 1",
+			ra.allocs, ra.frees)
+	}
+	if _, err = r.Read(make([]byte, 10)); err == nil {
+		t.Fatalf("Read after Close returned no error")
+	}
+	if err = r.Close(); err == nil {
+		t.Fatalf("second Close returned no error")
+	}
+}
@@ -73,6 +73,15 @@ func newBinTree(capacity int) (t *binTree, err error) {
 
 func (t *binTree) SetDict(d *encoderDict) { t.dict = d }
 
+// Reset empties the tree. The nodes don't need to be cleared, because
+// they are only reachable from the root.
+func (t *binTree) Reset() {
+	t.hoff = -int64(wordLen)
+	t.front = 0
+	t.root = null
+	t.x = 0
+}
+
 // WriteByte writes a single byte into the binary tree.
 func (t *binTree) WriteByte(c byte) error {
 	t.x = (t.x << 8) | uint32(c)
@@ -377,12 +386,15 @@ func (t *binTree) dump(w io.Writer) error {
 }
 */
 
+// distance returns the distance of the word stored in node v from the
+// head of the dictionary. The node stores the word that starts wordLen-1
+// bytes before the position given by the node index.
 func (t *binTree) distance(v uint32) int {
 	dist := int(t.front) - int(v)
 	if dist <= 0 {
 		dist += len(t.node)
 	}
-	return dist
+	return dist + wordLen - 1
 }
 
 type matchParams struct {
@@ -398,6 +410,7 @@ type matchParams struct {
 func (t *binTree) match(m match, distIter func() (int, bool), p matchParams,
 ) (r match, checked int, accepted bool) {
 	buf := &t.dict.buf
+	dictLen := t.dict.DictLen()
 	for {
 		if checked >= p.check {
 			return m, checked, true
@@ -407,6 +420,9 @@ func (t *binTree) match(m match, distIter func() (int, bool), p matchParams,
 			return m, checked, false
 		}
 		checked++
+		if dist > dictLen {
+			continue
+		}
 		if m.n > 0 {
 			i := buf.rear - dist + m.n - 1
 			if i < 0 {
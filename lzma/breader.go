@@ -37,3 +37,19 @@ func (r *breader) ReadByte() (c byte, err error) {
 	}
 	return r.p[0], nil
 }
+
+// countingByteReader counts the bytes read from the underlying byte
+// reader.
+type countingByteReader struct {
+	br io.ByteReader
+	n  int64
+}
+
+// ReadByte reads a single byte and counts it.
+func (r *countingByteReader) ReadByte() (c byte, err error) {
+	c, err = r.br.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return c, err
+}
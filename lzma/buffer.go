@@ -34,6 +34,24 @@ func (b *buffer) Reset() {
 	b.rear = 0
 }
 
+// wipe zeroes all bytes of the buffer that are not buffered. The
+// buffered data itself is kept.
+func (b *buffer) wipe() {
+	if b.front >= b.rear {
+		zero(b.data[b.front:])
+		zero(b.data[:b.rear])
+		return
+	}
+	zero(b.data[b.front:b.rear])
+}
+
+// zero sets all bytes of p to zero.
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
+
 // Buffered returns the number of bytes buffered.
 func (b *buffer) Buffered() int {
 	delta := b.front - b.rear
@@ -130,6 +148,25 @@ func (b *buffer) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteString puts the bytes of s into the buffer without converting
+// the string into a byte slice. If less bytes are written than
+// requested ErrNoSpace is returned.
+func (b *buffer) WriteString(s string) (n int, err error) {
+	m := b.Available()
+	n = len(s)
+	if m < n {
+		n = m
+		s = s[:m]
+		err = ErrNoSpace
+	}
+	k := copy(b.data[b.front:], s)
+	if k < n {
+		copy(b.data, s[k:])
+	}
+	b.front = b.addIndex(b.front, n)
+	return n, err
+}
+
 // WriteByte writes a single byte into the buffer. The error ErrNoSpace
 // is returned if no single byte is available in the buffer for writing.
 func (b *buffer) WriteByte(c byte) error {
@@ -35,3 +35,61 @@ func (l *LimitedByteWriter) WriteByte(c byte) error {
 	l.N--
 	return nil
 }
+
+// countingWriter counts the bytes written to the underlying writer. It
+// supports WriteByte, which is forwarded to the underlying writer if it
+// is an io.ByteWriter itself.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying writer and adds the number of bytes
+// written to the counter.
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// WriteByte writes a single byte to the underlying writer.
+func (cw *countingWriter) WriteByte(c byte) error {
+	var err error
+	if bw, ok := cw.w.(io.ByteWriter); ok {
+		err = bw.WriteByte(c)
+	} else {
+		_, err = cw.w.Write([]byte{c})
+	}
+	if err != nil {
+		return err
+	}
+	cw.n++
+	return nil
+}
+
+// ErrOutputTooLarge indicates that the compressed output would exceed
+// the MaxCompressedSize value of the writer configuration.
+var ErrOutputTooLarge = errors.New("lzma: compressed output too large")
+
+// capWriter writes to the underlying writer until a write would exceed
+// the remaining capacity n. Such a write isn't passed on and all
+// further writes fail with ErrOutputTooLarge.
+type capWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// Write writes p to the underlying writer if the capacity suffices.
+func (cw *capWriter) Write(p []byte) (n int, err error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if int64(len(p)) > cw.n {
+		cw.err = ErrOutputTooLarge
+		return 0, cw.err
+	}
+	n, err = cw.w.Write(p)
+	cw.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,332 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// checkpointMagic starts every state saved by Reader.SaveState.
+var checkpointMagic = []byte{'L', 'Z', 'S', 'T'}
+
+// checkpointVersion is the version of the state encoding.
+const checkpointVersion = 1
+
+// Flags stored in the checkpoint.
+const (
+	cpEOS = 1 << iota
+	cpEOSMarker
+)
+
+// probSlices returns all probabilities of the state in a fixed order.
+func (s *state) probSlices() [][]prob {
+	ps := [][]prob{
+		s.isMatch[:], s.isRepG0Long[:], s.isRep[:],
+		s.isRepG0[:], s.isRepG1[:], s.isRepG2[:],
+		s.litCodec.probs,
+	}
+	for _, lc := range []*lengthCodec{&s.lenCodec, &s.repLenCodec} {
+		ps = append(ps, lc.choice[:])
+		for i := range lc.low {
+			ps = append(ps, lc.low[i].probs)
+		}
+		for i := range lc.mid {
+			ps = append(ps, lc.mid[i].probs)
+		}
+		ps = append(ps, lc.high.probs)
+	}
+	dc := &s.distCodec
+	for i := range dc.posSlotCodecs {
+		ps = append(ps, dc.posSlotCodecs[i].probs)
+	}
+	for i := range dc.posModel {
+		ps = append(ps, dc.posModel[i].probs)
+	}
+	return append(ps, dc.alignCodec.probs)
+}
+
+// checkpoint contains the decoder state saved by Reader.SaveState.
+type checkpoint struct {
+	h       header
+	in      int64
+	n       int64
+	start   int64
+	head    int64
+	flags   byte
+	end     EndReason
+	nrange  uint32
+	code    uint32
+	rep     [4]uint32
+	state   uint32
+	probs   []prob
+	window  []byte
+	pending int
+}
+
+// marshalBinary encodes the checkpoint.
+func (cp *checkpoint) marshalBinary() []byte {
+	var buf bytes.Buffer
+	var b [8]byte
+	put32 := func(x uint32) {
+		putUint32LE(b[:], x)
+		buf.Write(b[:4])
+	}
+	put64 := func(x int64) {
+		putUint64LE(b[:], uint64(x))
+		buf.Write(b[:])
+	}
+	buf.Write(checkpointMagic)
+	buf.WriteByte(checkpointVersion)
+	hdr, _ := cp.h.marshalBinary()
+	buf.Write(hdr)
+	put64(cp.in)
+	put64(cp.n)
+	put64(cp.start)
+	put64(cp.head)
+	buf.WriteByte(cp.flags)
+	buf.WriteByte(byte(cp.end))
+	put32(cp.nrange)
+	put32(cp.code)
+	for _, r := range cp.rep {
+		put32(r)
+	}
+	put32(cp.state)
+	put32(uint32(len(cp.probs)))
+	for _, p := range cp.probs {
+		buf.WriteByte(byte(p))
+		buf.WriteByte(byte(p >> 8))
+	}
+	put32(uint32(len(cp.window)))
+	put32(uint32(cp.pending))
+	buf.Write(cp.window)
+	return buf.Bytes()
+}
+
+// errCheckpoint indicates an invalid saved state.
+var errCheckpoint = errors.New("lzma: invalid saved decoder state")
+
+// unmarshalBinary decodes the checkpoint. The slices of the checkpoint
+// refer to data.
+func (cp *checkpoint) unmarshalBinary(data []byte) error {
+	if len(data) < len(checkpointMagic)+1+HeaderLen ||
+		!bytes.Equal(data[:len(checkpointMagic)], checkpointMagic) {
+		return errCheckpoint
+	}
+	data = data[len(checkpointMagic):]
+	if data[0] != checkpointVersion {
+		return fmt.Errorf("lzma: unsupported saved state version %d",
+			data[0])
+	}
+	data = data[1:]
+	if err := cp.h.unmarshalBinary(data[:HeaderLen]); err != nil {
+		return err
+	}
+	data = data[HeaderLen:]
+	const fixedLen = 4*8 + 2 + 2*4 + 4*4 + 4 + 4
+	if len(data) < fixedLen {
+		return errCheckpoint
+	}
+	get32 := func() uint32 {
+		x := uint32LE(data)
+		data = data[4:]
+		return x
+	}
+	get64 := func() int64 {
+		x := int64(uint64LE(data))
+		data = data[8:]
+		return x
+	}
+	cp.in = get64()
+	cp.n = get64()
+	cp.start = get64()
+	cp.head = get64()
+	cp.flags = data[0]
+	cp.end = EndReason(data[1])
+	data = data[2:]
+	cp.nrange = get32()
+	cp.code = get32()
+	for i := range cp.rep {
+		cp.rep[i] = get32()
+	}
+	cp.state = get32()
+	k := int64(get32())
+	if int64(len(data)) < 2*k+8 {
+		return errCheckpoint
+	}
+	cp.probs = make([]prob, k)
+	for i := range cp.probs {
+		cp.probs[i] = prob(data[0]) | prob(data[1])<<8
+		data = data[2:]
+	}
+	w := int64(get32())
+	cp.pending = int(get32())
+	if int64(len(data)) != w || int64(cp.pending) > w {
+		return errCheckpoint
+	}
+	cp.window = data
+	if cp.in < 0 || cp.n < 0 || cp.start < 0 || cp.head < cp.start ||
+		cp.state >= states || cp.flags&^(cpEOS|cpEOSMarker) != 0 ||
+		cp.end > EndByUnderlyingEOF {
+		return errCheckpoint
+	}
+	return nil
+}
+
+// SaveState returns the complete state of the reader, which allows to
+// resume decoding later, even in another process. The state contains
+// the dictionary window including decoded data not read yet, the
+// probabilities and registers of the decoder and the number of
+// compressed bytes consumed, which is reported by InputOffset. So the
+// state can be as large as the dictionary.
+//
+// SaveState can be called between read calls. It returns an error if
+// the reader has encountered a decoding error or has been closed.
+func (r *Reader) SaveState() ([]byte, error) {
+	d := r.d
+	if d.err != nil {
+		return nil, d.err
+	}
+	cp := checkpoint{
+		h:       r.h,
+		in:      r.in.n,
+		n:       r.n,
+		start:   d.start,
+		head:    d.Dict.head,
+		end:     d.end,
+		nrange:  d.rd.nrange,
+		code:    d.rd.code,
+		rep:     d.State.rep,
+		state:   d.State.state,
+		window:  d.Dict.window(&r.scratch),
+		pending: d.Dict.buf.Buffered(),
+	}
+	if d.eos {
+		cp.flags |= cpEOS
+	}
+	if d.eosMarker {
+		cp.flags |= cpEOSMarker
+	}
+	for _, p := range d.State.probSlices() {
+		cp.probs = append(cp.probs, p...)
+	}
+	return cp.marshalBinary(), nil
+}
+
+// InputOffset returns the number of compressed bytes the reader has
+// consumed including the header. To resume decoding from a saved state
+// the remaining input must start at this offset.
+func (r *Reader) InputOffset() int64 {
+	return r.in.n
+}
+
+// RestoreState restores the state saved by SaveState. The reader
+// continues to read the compressed data from its underlying reader,
+// which must provide the input of the stream starting at the offset
+// InputOffset had when the state has been saved. The dictionary of the
+// reader must be large enough for the stream of the saved state.
+func (r *Reader) RestoreState(b []byte) error {
+	if r.d.err == errReaderClosed {
+		return errReaderClosed
+	}
+	var cp checkpoint
+	if err := cp.unmarshalBinary(b); err != nil {
+		return err
+	}
+	return r.restore(&cp)
+}
+
+// restore sets the reader to the state of the checkpoint.
+func (r *Reader) restore(cp *checkpoint) error {
+	h := cp.h
+	if h.dictCap < MinDictCap {
+		h.dictCap = MinDictCap
+	}
+	dict := r.d.Dict
+	if c := dict.buf.Cap(); c < h.dictCap || c < len(cp.window) {
+		return errors.New(
+			"lzma: dictionary too small for the saved state")
+	}
+	if int64(len(cp.window)) > cp.head {
+		return errCheckpoint
+	}
+	state := newState(h.properties)
+	ps := state.probSlices()
+	var k int
+	for _, p := range ps {
+		k += len(p)
+	}
+	if k != len(cp.probs) {
+		return errCheckpoint
+	}
+	probs := cp.probs
+	for _, p := range ps {
+		probs = probs[copy(p, probs):]
+	}
+	state.rep = cp.rep
+	state.state = cp.state
+
+	dict.buf.front, dict.buf.rear = 0, 0
+	if r.wipe {
+		dict.buf.wipe()
+		zero(r.scratch)
+	}
+	dict.buf.Write(cp.window)
+	dict.buf.Discard(len(cp.window) - cp.pending)
+	dict.head = cp.head
+
+	r.h = h
+	r.n = cp.n
+	r.in.n = cp.in
+	r.d = &decoder{
+		Dict:       dict,
+		State:      state,
+		rd:         &rangeDecoder{br: r.in, nrange: cp.nrange, code: cp.code},
+		start:      cp.start,
+		size:       h.size,
+		eos:        cp.flags&cpEOS != 0,
+		eosMarker:  cp.flags&cpEOSMarker != 0,
+		allowNoEOS: r.d.allowNoEOS,
+		end:        cp.end,
+		work:       r.d.work,
+		maxWork:    r.d.maxWork,
+		trace:      r.d.trace,
+	}
+	return nil
+}
+
+// NewReaderState creates a reader that resumes decoding from the state
+// saved by Reader.SaveState. The reader lzma must provide the
+// compressed data starting at the offset reported by InputOffset when
+// the state was saved.
+func NewReaderState(lzma io.Reader, state []byte) (r *Reader, err error) {
+	return ReaderConfig{}.NewReaderState(lzma, state)
+}
+
+// NewReaderState creates a reader that resumes decoding from the state
+// saved by Reader.SaveState. The reader lzma must provide the
+// compressed data starting at the offset reported by InputOffset when
+// the state was saved. The PresetDict and InitialReps fields of the
+// configuration are ignored, because the saved state replaces them.
+func (c ReaderConfig) NewReaderState(lzma io.Reader, state []byte) (
+	r *Reader, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err = cp.unmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	c.PresetDict = nil
+	if r, err = c.initReader(lzma, cp.h); err != nil {
+		return nil, err
+	}
+	if err = r.restore(&cp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
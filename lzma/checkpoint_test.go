@@ -0,0 +1,125 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestReaderSaveState(t *testing.T) {
+	const size = 300000
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(7)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	configs := []WriterConfig{
+		{DictCap: 1 << 16},
+		{DictCap: 1 << 16, Size: size},
+		{Properties: &Properties{LC: 0, LP: 2, PB: 0},
+			DictCap: MinDictCap},
+	}
+	for i, c := range configs {
+		z := compressWithConfig(t, c, data)
+		for _, split := range []int{0, 1, 4097, 123457, size - 1,
+			size} {
+			r, err := NewReader(bytes.NewReader(z))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			head := make([]byte, split)
+			if _, err = io.ReadFull(r, head); err != nil {
+				t.Fatalf("ReadFull error %s", err)
+			}
+			state, err := r.SaveState()
+			if err != nil {
+				t.Fatalf("SaveState error %s", err)
+			}
+			off := r.InputOffset()
+
+			rr, err := NewReaderState(bytes.NewReader(z[off:]),
+				state)
+			if err != nil {
+				t.Fatalf("config %d, split %d: "+
+					"NewReaderState error %s", i, split, err)
+			}
+			tail, err := ioutil.ReadAll(rr)
+			if err != nil {
+				t.Fatalf("config %d, split %d: ReadAll error %s",
+					i, split, err)
+			}
+			out := append(head, tail...)
+			if !bytes.Equal(out, data) {
+				t.Fatalf("config %d, split %d: "+
+					"resumed output differs", i, split)
+			}
+			if rr.InputOffset() != int64(len(z)) {
+				t.Fatalf("config %d, split %d: "+
+					"InputOffset %d; want %d", i, split,
+					rr.InputOffset(), len(z))
+			}
+
+			// restore into an existing reader
+			r2, err := NewReader(bytes.NewReader(z))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			if err = r2.RestoreState(state); err != nil {
+				t.Fatalf("RestoreState error %s", err)
+			}
+			r2.in.br = bytes.NewReader(z[off:])
+			tail2, err := ioutil.ReadAll(r2)
+			if err != nil {
+				t.Fatalf("ReadAll after RestoreState error %s",
+					err)
+			}
+			if !bytes.Equal(tail2, tail) {
+				t.Fatalf("RestoreState output differs")
+			}
+		}
+	}
+}
+
+func TestReaderRestoreStateErrors(t *testing.T) {
+	data := []byte(testString)
+	z := compressWithConfig(t, WriterConfig{DictCap: 1 << 20}, data)
+	r, err := NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	state, err := r.SaveState()
+	if err != nil {
+		t.Fatalf("SaveState error %s", err)
+	}
+	for _, n := range []int{0, 4, 20, len(state) - 1} {
+		if err = r.RestoreState(state[:n]); err == nil {
+			t.Fatalf("RestoreState accepted %d bytes", n)
+		}
+	}
+	c := ReaderConfig{DictCap: MinDictCap}
+	small, err := c.NewReader(bytes.NewReader(compressWithConfig(t,
+		WriterConfig{DictCap: MinDictCap}, data)))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if err = small.RestoreState(state); err == nil {
+		t.Fatalf("RestoreState accepted a too small dictionary")
+	}
+	if err = r.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if _, err = r.SaveState(); err == nil {
+		t.Fatalf("SaveState after Close: no error")
+	}
+}
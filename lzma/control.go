@@ -0,0 +1,169 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// The control byte starts every LZMA2 chunk. Its bit layout is:
+//
+//	0x00       end of stream
+//	0x01       uncompressed chunk; dictionary reset
+//	0x02       uncompressed chunk; no reset
+//	0x03-0x7F  invalid
+//	1rrsssss   LZMA chunk
+//
+// The two reset bits rr of an LZMA chunk are
+//
+//	00  no reset
+//	01  state reset
+//	10  state reset; new properties
+//	11  state reset; new properties; dictionary reset
+//
+// and the five bits sssss are the bits 16 to 20 of the uncompressed
+// size minus one. Uncompressed chunks store their size in the two
+// bytes following the control byte only, because they can't be larger
+// than 64 KiB.
+
+// ChunkKind describes the kind of an LZMA2 chunk.
+type ChunkKind byte
+
+// Kinds of LZMA2 chunks.
+const (
+	// ChunkEOS terminates the LZMA2 stream.
+	ChunkEOS ChunkKind = iota
+	// ChunkUncompressed stores data uncompressed.
+	ChunkUncompressed
+	// ChunkLZMA stores LZMA compressed data.
+	ChunkLZMA
+)
+
+// String returns a string representation of the chunk kind.
+func (k ChunkKind) String() string {
+	switch k {
+	case ChunkEOS:
+		return "EOS"
+	case ChunkUncompressed:
+		return "uncompressed"
+	case ChunkLZMA:
+		return "LZMA"
+	}
+	return "unknown"
+}
+
+// ResetFlags describe the resets requested by an LZMA2 chunk before
+// its data is decoded.
+type ResetFlags byte
+
+// Reset flags. Only the combinations supported by the control byte can
+// be encoded: ResetDict alone for uncompressed chunks; ResetState,
+// ResetState|ResetProps and ResetState|ResetProps|ResetDict for LZMA
+// chunks. No flag at all is supported by both chunk kinds.
+const (
+	// ResetState resets the LZMA state.
+	ResetState ResetFlags = 1 << iota
+	// ResetProps sets new properties, which follow the chunk
+	// header.
+	ResetProps
+	// ResetDict resets the dictionary.
+	ResetDict
+)
+
+// controlTypes maps the chunk kind and the reset flags to the chunk
+// type.
+var controlTypes = []struct {
+	kind  ChunkKind
+	reset ResetFlags
+	ctype chunkType
+}{
+	{ChunkEOS, 0, cEOS},
+	{ChunkUncompressed, ResetDict, cUD},
+	{ChunkUncompressed, 0, cU},
+	{ChunkLZMA, 0, cL},
+	{ChunkLZMA, ResetState, cLR},
+	{ChunkLZMA, ResetState | ResetProps, cLRN},
+	{ChunkLZMA, ResetState | ResetProps | ResetDict, cLRND},
+}
+
+// DecodeControlByte decodes the control byte of an LZMA2 chunk. For an
+// LZMA chunk sizeBits returns the bits 16 to 20 of the uncompressed
+// size minus one, already shifted into position; otherwise it is zero.
+func DecodeControlByte(b byte) (kind ChunkKind, reset ResetFlags,
+	sizeBits uint32, err error) {
+	c, err := headerChunkType(b)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, t := range controlTypes {
+		if t.ctype == c {
+			kind, reset = t.kind, t.reset
+			break
+		}
+	}
+	if kind == ChunkLZMA {
+		sizeBits = uint32(b&^hLRND) << 16
+	}
+	return kind, reset, sizeBits, nil
+}
+
+// EncodeControlByte returns the control byte for an LZMA2 chunk. The
+// argument size is the uncompressed size of the chunk, which is
+// only encoded for LZMA chunks; it is ignored for the other kinds. An
+// error is returned for reset flags the chunk kind doesn't support and
+// for sizes outside the range 1 to 2 MiB of LZMA chunks.
+func EncodeControlByte(kind ChunkKind, reset ResetFlags, size int) (
+	b byte, err error) {
+	c := chunkType(0xff)
+	for _, t := range controlTypes {
+		if t.kind == kind && t.reset == reset {
+			c = t.ctype
+			break
+		}
+	}
+	switch c {
+	case cEOS:
+		return hEOS, nil
+	case cUD:
+		return hUD, nil
+	case cU:
+		return hU, nil
+	case cL:
+		b = hL
+	case cLR:
+		b = hLR
+	case cLRN:
+		b = hLRN
+	case cLRND:
+		b = hLRND
+	default:
+		return 0, errors.New(
+			"lzma: unsupported combination of chunk kind and resets")
+	}
+	if !(1 <= size && size <= maxUncompressed) {
+		return 0, errors.New(
+			"lzma: uncompressed chunk size out of range")
+	}
+	return b | byte((size-1)>>16), nil
+}
+
+// PlanLZMA2Chunks returns the uncompressed sizes of the chunks an LZMA2
+// stream needs at least for size bytes of data. LZMA chunks hold up to
+// 2 MiB of uncompressed data, so all chunks but the last have this
+// size. The plan is a lower bound for the number of chunks: an LZMA
+// chunk holds also at most 64 KiB of compressed data and an
+// uncompressed chunk at most 64 KiB of data, so Writer2 writes more
+// and smaller chunks for most payloads. The function returns nil if
+// size is not positive.
+func PlanLZMA2Chunks(size int64) []int64 {
+	if size <= 0 {
+		return nil
+	}
+	n := (size + maxUncompressed - 1) / maxUncompressed
+	chunks := make([]int64, n)
+	for i := range chunks {
+		chunks[i] = maxUncompressed
+	}
+	chunks[n-1] = size - (n-1)*maxUncompressed
+	return chunks
+}
@@ -0,0 +1,119 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var controlByteTests = []struct {
+	b     byte
+	kind  ChunkKind
+	reset ResetFlags
+	size  int
+}{
+	{0x00, ChunkEOS, 0, 0},
+	{0x01, ChunkUncompressed, ResetDict, 0},
+	{0x02, ChunkUncompressed, 0, 0},
+	{0x80, ChunkLZMA, 0, 1},
+	{0x81, ChunkLZMA, 0, 1<<16 + 1},
+	{0x9f, ChunkLZMA, 0, 1 << 21},
+	{0xa0, ChunkLZMA, ResetState, 1 << 16},
+	{0xa3, ChunkLZMA, ResetState, 4 << 16},
+	{0xc0, ChunkLZMA, ResetState | ResetProps, 100},
+	{0xd0, ChunkLZMA, ResetState | ResetProps, 1<<20 + 1},
+	{0xe0, ChunkLZMA, ResetState | ResetProps | ResetDict, 1},
+	{0xff, ChunkLZMA, ResetState | ResetProps | ResetDict, 1 << 21},
+}
+
+func TestDecodeControlByte(t *testing.T) {
+	for _, tc := range controlByteTests {
+		kind, reset, sizeBits, err := DecodeControlByte(tc.b)
+		if err != nil {
+			t.Fatalf("DecodeControlByte(%#02x) error %s", tc.b, err)
+		}
+		if kind != tc.kind || reset != tc.reset {
+			t.Fatalf("DecodeControlByte(%#02x) returned %s %#x;"+
+				" want %s %#x", tc.b, kind, reset, tc.kind,
+				tc.reset)
+		}
+		var want uint32
+		if tc.kind == ChunkLZMA {
+			want = uint32(tc.size-1) &^ 0xffff
+		}
+		if sizeBits != want {
+			t.Fatalf("DecodeControlByte(%#02x) returned sizeBits"+
+				" %#x; want %#x", tc.b, sizeBits, want)
+		}
+	}
+	for _, b := range []byte{0x03, 0x10, 0x7f} {
+		_, _, _, err := DecodeControlByte(b)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("DecodeControlByte(%#02x) returned error %v;"+
+				" want ErrCorrupt", b, err)
+		}
+	}
+}
+
+func TestEncodeControlByte(t *testing.T) {
+	for _, tc := range controlByteTests {
+		b, err := EncodeControlByte(tc.kind, tc.reset, tc.size)
+		if err != nil {
+			t.Fatalf("EncodeControlByte(%s, %#x, %d) error %s",
+				tc.kind, tc.reset, tc.size, err)
+		}
+		if b != tc.b {
+			t.Fatalf("EncodeControlByte(%s, %#x, %d) = %#02x;"+
+				" want %#02x", tc.kind, tc.reset, tc.size, b,
+				tc.b)
+		}
+	}
+	invalid := []struct {
+		kind  ChunkKind
+		reset ResetFlags
+		size  int
+	}{
+		{ChunkEOS, ResetDict, 0},
+		{ChunkUncompressed, ResetState, 1},
+		{ChunkLZMA, ResetDict, 1},
+		{ChunkLZMA, ResetProps, 1},
+		{ChunkLZMA, 0, 0},
+		{ChunkLZMA, 0, 1<<21 + 1},
+		{ChunkKind(3), 0, 1},
+	}
+	for _, tc := range invalid {
+		if _, err := EncodeControlByte(tc.kind, tc.reset,
+			tc.size); err == nil {
+			t.Fatalf("EncodeControlByte(%s, %#x, %d) returned"+
+				" no error", tc.kind, tc.reset, tc.size)
+		}
+	}
+}
+
+func TestPlanLZMA2Chunks(t *testing.T) {
+	const m = 2 << 20
+	tests := []struct {
+		size int64
+		want []int64
+	}{
+		{-1, nil},
+		{0, nil},
+		{1, []int64{1}},
+		{m - 1, []int64{m - 1}},
+		{m, []int64{m}},
+		{m + 1, []int64{m, 1}},
+		{3 * m, []int64{m, m, m}},
+		{3*m + 100, []int64{m, m, m, 100}},
+	}
+	for _, tc := range tests {
+		got := PlanLZMA2Chunks(tc.size)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("PlanLZMA2Chunks(%d) = %v; want %v",
+				tc.size, got, tc.want)
+		}
+	}
+}
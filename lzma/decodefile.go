@@ -0,0 +1,89 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// minPrealloc is the number of bytes allocated first for a file of
+// known size.
+const minPrealloc = 1 << 20
+
+// DecodeToFile decodes the LZMA stream in the classic format provided
+// by r and writes the decompressed data to the file at path, which is
+// created or truncated. If the header declares the uncompressed size,
+// the space for the file is allocated ahead of the data written, which
+// avoids fragmentation. On Linux fallocate is used; on other systems
+// and file systems not supporting it the file is only extended. Since
+// the header can't be trusted, the allocation grows with the data
+// decoded and never exceeds twice its size plus 1 MiB. Streams without
+// size are simply written sequentially.
+//
+// If decoding fails the file is truncated to the data written so far.
+// If the truncation fails too, both errors are reported.
+func DecodeToFile(path string, r io.Reader) (err error) {
+	lr, err := NewReader(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	pw := &preallocWriter{f: f, size: lr.h.size}
+	n, err := io.Copy(pw, lr)
+	if err != nil {
+		if terr := f.Truncate(n); terr != nil {
+			return fmt.Errorf("%v; %v", err, terr)
+		}
+		return err
+	}
+	return nil
+}
+
+// preallocWriter writes to a file of the given size and allocates the
+// space for the file in growing steps before the data is written. A
+// negative size disables the allocation.
+type preallocWriter struct {
+	f *os.File
+	// declared size of the file
+	size int64
+	// bytes written
+	n int64
+	// bytes allocated
+	alloc int64
+}
+
+// Write allocates space if required and writes p to the file.
+func (w *preallocWriter) Write(p []byte) (n int, err error) {
+	end := w.n + int64(len(p))
+	if end > w.alloc && w.alloc < w.size {
+		a := 2 * w.alloc
+		if a < minPrealloc {
+			a = minPrealloc
+		}
+		if a < end {
+			a = end
+		}
+		if a > w.size {
+			a = w.size
+		}
+		if err = preallocate(w.f, a); err != nil {
+			return 0, err
+		}
+		w.alloc = a
+	}
+	n, err = w.f.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,80 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestDecodeToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lzma")
+	if err != nil {
+		t.Fatalf("TempDir error %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(195)), 5<<19))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	size := int64(len(data))
+	sized := compressWithConfig(t, WriterConfig{Size: size}, data)
+	// header declaring 256 GiB
+	huge := append([]byte{}, sized...)
+	putUint64LE(huge[5:], 1<<38)
+	tests := []struct {
+		name string
+		z    []byte
+	}{
+		{"size", sized},
+		{"eos", compressWithConfig(t, WriterConfig{}, data)},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(dir, tc.name)
+		if err = DecodeToFile(path, bytes.NewReader(tc.z)); err != nil {
+			t.Fatalf("%s: DecodeToFile error %s", tc.name, err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: ReadFile error %s", tc.name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: file content differs", tc.name)
+		}
+	}
+
+	// Failed decoding leaves only the data written.
+	for _, tc := range []struct {
+		name string
+		z    []byte
+	}{
+		{"truncated", sized[:len(sized)/2]},
+		{"huge", huge},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err = DecodeToFile(path,
+			bytes.NewReader(tc.z)); err == nil {
+			t.Fatalf("%s: DecodeToFile returned no error",
+				tc.name)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: ReadFile error %s", tc.name, err)
+		}
+		if !bytes.HasPrefix(data, got) {
+			t.Fatalf("%s: file is not a prefix of the data;"+
+				" size %d", tc.name, len(got))
+		}
+	}
+}
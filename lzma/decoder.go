@@ -28,6 +28,20 @@ type decoder struct {
 	eos bool
 	// EOS marker found
 	eosMarker bool
+	// accept the end of the input at an operation boundary as the
+	// end of a stream without size
+	allowNoEOS bool
+	// decoding error; it is reported after the data decoded before
+	// the error has been read
+	err error
+	// reason for the end of the stream; EndNone unless the end of
+	// the stream has been reached without error
+	end EndReason
+	// work units spent and the budget; zero means no limit
+	work    int64
+	maxWork int64
+	// receives the trace of the operations if not nil
+	trace io.Writer
 }
 
 // newDecoder creates a new decoder instance. The parameter size provides
@@ -59,6 +73,8 @@ func (d *decoder) Reopen(br io.ByteReader, size int64) error {
 	d.start = d.Dict.pos()
 	d.size = size
 	d.eos = false
+	d.err = nil
+	d.end = EndNone
 	return nil
 }
 
@@ -96,6 +112,11 @@ func (d *decoder) readOp() (op operation, err error) {
 			return nil, err
 		}
 		d.State.updateStateLiteral()
+		if d.trace != nil {
+			if err = traceLit(d.trace, op.(lit).b); err != nil {
+				return nil, err
+			}
+		}
 		return op, nil
 	}
 	b, err = d.State.isRep[state].Decode(d.rd)
@@ -121,17 +142,29 @@ func (d *decoder) readOp() (op operation, err error) {
 		}
 		if d.State.rep[0] == eosDist {
 			d.eosMarker = true
+			if d.trace != nil {
+				if err = traceEOS(d.trace); err != nil {
+					return nil, err
+				}
+			}
 			return nil, errEOS
 		}
-		op = match{n: int(n) + minMatchLen,
+		m := match{n: int(n) + minMatchLen,
 			distance: int64(d.State.rep[0]) + minDistance}
-		return op, nil
+		if d.trace != nil {
+			if err = traceMatch(d.trace, m.n, m.distance); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
 	}
 	b, err = d.State.isRepG0[state].Decode(d.rd)
 	if err != nil {
 		return nil, err
 	}
 	dist := d.State.rep[0]
+	// index of the repeated distance
+	g := 0
 	if b == 0 {
 		// rep match 0
 		b, err = d.State.isRepG0Long[state2].Decode(d.rd)
@@ -140,6 +173,11 @@ func (d *decoder) readOp() (op operation, err error) {
 		}
 		if b == 0 {
 			d.State.updateStateShortRep()
+			if d.trace != nil {
+				if err = traceRep(d.trace, 0, 1); err != nil {
+					return nil, err
+				}
+			}
 			op = match{n: 1, distance: int64(dist) + minDistance}
 			return op, nil
 		}
@@ -149,6 +187,7 @@ func (d *decoder) readOp() (op operation, err error) {
 			return nil, err
 		}
 		if b == 0 {
+			g = 1
 			dist = d.State.rep[1]
 		} else {
 			b, err = d.State.isRepG2[state].Decode(d.rd)
@@ -156,8 +195,10 @@ func (d *decoder) readOp() (op operation, err error) {
 				return nil, err
 			}
 			if b == 0 {
+				g = 2
 				dist = d.State.rep[2]
 			} else {
+				g = 3
 				dist = d.State.rep[3]
 				d.State.rep[3] = d.State.rep[2]
 			}
@@ -172,6 +213,11 @@ func (d *decoder) readOp() (op operation, err error) {
 	}
 	d.State.updateStateRep()
 	op = match{n: int(n) + minMatchLen, distance: int64(dist) + minDistance}
+	if d.trace != nil {
+		if err = traceRep(d.trace, g, op.Len()); err != nil {
+			return nil, err
+		}
+	}
 	return op, nil
 }
 
@@ -193,10 +239,20 @@ func (d *decoder) apply(op operation) error {
 // available. If the end of the LZMA stream has been reached io.EOF will
 // be returned.
 func (d *decoder) decompress() error {
+	return d.decodeOps(nil)
+}
+
+// decodeOps works like decompress but stops also if the ready function
+// returns false. A nil ready function is always ready.
+func (d *decoder) decodeOps(ready func() bool) error {
 	if d.eos {
 		return io.EOF
 	}
-	for d.Dict.Available() >= maxMatchLen {
+	if err := d.checkSize(); err != nil {
+		return err
+	}
+	for d.Dict.Available() >= maxMatchLen && (ready == nil || ready()) {
+		atEnd := d.rd.possiblyAtEnd()
 		op, err := d.readOp()
 		switch err {
 		case nil:
@@ -207,49 +263,126 @@ func (d *decoder) decompress() error {
 				return errDataAfterEOS
 			}
 			if d.size >= 0 && d.size != d.Decompressed() {
-				return errSize
+				return d.sizeMismatch(d.Decompressed())
 			}
+			d.end = EndByEOS
 			return io.EOF
 		case io.EOF:
 			d.eos = true
+			if d.allowNoEOS && d.size < 0 && atEnd {
+				d.end = EndByUnderlyingEOF
+				return io.EOF
+			}
 			return io.ErrUnexpectedEOF
 		default:
 			return err
 		}
+		if d.maxWork > 0 {
+			if err = d.spend(op); err != nil {
+				return err
+			}
+		}
 		if err = d.apply(op); err != nil {
 			return err
 		}
-		if d.size >= 0 && d.Decompressed() >= d.size {
-			d.eos = true
-			if d.Decompressed() > d.size {
-				return errSize
-			}
-			if !d.rd.possiblyAtEnd() {
-				switch _, err = d.readOp(); err {
-				case nil:
-					return errSize
-				case io.EOF:
-					return io.ErrUnexpectedEOF
-				case errEOS:
-					break
-				default:
-					return err
-				}
-			}
-			return io.EOF
+		if err = d.checkSize(); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// spend adds the work units of the operation to the work counter. A
+// literal costs one unit, a match one unit for its decoding plus one
+// unit per copied byte. ErrBudgetExceeded is returned if the budget
+// would be exceeded; the operation is not applied then.
+func (d *decoder) spend(op operation) error {
+	w := int64(1)
+	if m, ok := op.(match); ok {
+		w += int64(m.n)
+	}
+	if d.work+w > d.maxWork {
+		return ErrBudgetExceeded
+	}
+	d.work += w
+	return nil
+}
+
+// checkSize checks whether the expected size of the uncompressed data
+// has been reached. In that case the end of the stream will be checked,
+// which might contain an EOS marker, and io.EOF will be returned.
+func (d *decoder) checkSize() error {
+	if !(d.size >= 0 && d.Decompressed() >= d.size) {
+		return nil
+	}
+	d.eos = true
+	if d.Decompressed() > d.size {
+		return d.sizeMismatch(d.Decompressed())
+	}
+	if !d.rd.possiblyAtEnd() {
+		switch op, err := d.readOp(); err {
+		case nil:
+			return d.sizeMismatch(d.Decompressed() + int64(op.Len()))
+		case io.EOF:
+			return io.ErrUnexpectedEOF
+		case errEOS:
+			break
+		default:
+			return err
+		}
+	}
+	// A stream with size may have an EOS marker in addition.
+	if d.eosMarker {
+		d.end = EndByEOS
+	} else {
+		d.end = EndBySize
+	}
+	return io.EOF
+}
+
+// SizeMismatchError is returned by the reader if the size of the
+// uncompressed data declared in the header doesn't match the actual
+// data of the stream. If the stream ends early, Actual is the size of
+// the data decoded. If the stream contains more data, Actual is the
+// number of bytes known when the mismatch has been detected, which is
+// only a lower bound of the actual size.
+type SizeMismatchError struct {
+	Declared int64
+	Actual   int64
+}
+
+// Error returns the error message.
+func (e *SizeMismatchError) Error() string {
+	if e.Actual > e.Declared {
+		return fmt.Sprintf(
+			"lzma: stream has more than the declared %d bytes",
+			e.Declared)
+	}
+	return fmt.Sprintf("lzma: stream has %d bytes; declared %d",
+		e.Actual, e.Declared)
+}
+
+// Is supports errors.Is(err, ErrCorrupt).
+func (e *SizeMismatchError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// sizeMismatch returns the SizeMismatchError for the actual size n.
+func (d *decoder) sizeMismatch(n int64) error {
+	return &SizeMismatchError{Declared: d.size, Actual: n}
+}
+
 // Errors that may be returned while decoding data.
 var (
-	errDataAfterEOS = errors.New("lzma: data after end of stream marker")
-	errSize         = errors.New("lzma: wrong uncompressed data size")
+	errDataAfterEOS error = corruptError(
+		"lzma: data after end of stream marker")
+	errSize = errors.New("lzma: wrong uncompressed data size")
 )
 
 // Read reads data from the buffer. If no more data is available io.EOF is
-// returned.
+// returned. If decoding fails, for instance because the stream is
+// truncated, the data decoded before the error is returned first and
+// the error only after all that data has been read.
 func (d *decoder) Read(p []byte) (n int, err error) {
 	var k int
 	for {
@@ -258,15 +391,60 @@ func (d *decoder) Read(p []byte) (n int, err error) {
 		if err != nil {
 			panic(fmt.Errorf("dictionary read error %s", err))
 		}
-		if k == 0 && d.eos {
-			return n, io.EOF
-		}
 		n += k
 		if n >= len(p) {
 			return n, nil
 		}
+		// The dictionary has no buffered data anymore.
+		if d.err != nil {
+			return n, d.err
+		}
+		if d.eos {
+			return n, io.EOF
+		}
 		if err = d.decompress(); err != nil && err != io.EOF {
-			return n, err
+			d.err = err
+		}
+	}
+}
+
+// tryRead returns the data that is available in the dictionary or can
+// be decoded while the ready function returns true. If no data can be
+// provided ErrNoProgress is returned.
+func (d *decoder) tryRead(p []byte, ready func() bool) (n int, err error) {
+	// Read of decoder dict never returns an error.
+	n, _ = d.Dict.Read(p)
+	if n < len(p) && d.err == nil {
+		if err = d.decodeOps(ready); err != nil && err != io.EOF {
+			d.err = err
+		}
+		k, _ := d.Dict.Read(p[n:])
+		n += k
+	}
+	if n == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.eos {
+			return 0, io.EOF
+		}
+		return 0, ErrNoProgress
+	}
+	return n, nil
+}
+
+// verify decodes the rest of the stream without copying the
+// uncompressed data out of the dictionary. It returns nil if the end
+// of the stream has been reached without error.
+func (d *decoder) verify() error {
+	for {
+		d.Dict.buf.Discard(d.Dict.buf.Buffered())
+		err := d.decompress()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
 }
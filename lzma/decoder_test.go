@@ -6,10 +6,15 @@ package lzma
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
 )
 
 func TestDecoder(t *testing.T) {
@@ -57,3 +62,190 @@ func TestDecoder(t *testing.T) {
 		}
 	}
 }
+
+// decodeCorpora provides the uncompressed data for BenchmarkDecode and
+// the tests and benchmarks of the encoder.
+func decodeCorpora(b testing.TB) map[string][]byte {
+	const size = 1 << 20
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(49)), size))
+	if err != nil {
+		b.Fatalf("ReadAll error %s", err)
+	}
+	random := make([]byte, size/4)
+	rand.New(rand.NewSource(49)).Read(random)
+	corpora := map[string][]byte{
+		"randtxt": txt,
+		"random":  random,
+		"zeros":   make([]byte, size),
+	}
+	f, err := os.Open("../testdata/enwik7")
+	if err != nil {
+		b.Logf("enwik7 not available: %s", err)
+		return corpora
+	}
+	defer f.Close()
+	enwik, err := ioutil.ReadAll(io.LimitReader(f, size))
+	if err != nil {
+		b.Fatalf("ReadAll error %s", err)
+	}
+	corpora["enwik"] = enwik
+	return corpora
+}
+
+func BenchmarkDecode(b *testing.B) {
+	corpora := decodeCorpora(b)
+	for _, name := range []string{"enwik", "randtxt", "random", "zeros"} {
+		txt, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			b.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+		data := buf.Bytes()
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(txt)))
+			for i := 0; i < b.N; i++ {
+				r, err := NewReader(bytes.NewReader(data))
+				if err != nil {
+					b.Fatalf("NewReader error %s", err)
+				}
+				if _, err = io.Copy(ioutil.Discard, r); err != nil {
+					b.Fatalf("io.Copy error %s", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLiteralHeavy measures the decoding of text compressed into
+// streams consisting mostly of literals. MinMatch replaces the short
+// matches by literals, many of them following a match, which requires
+// the decoding with the match byte.
+func BenchmarkLiteralHeavy(b *testing.B) {
+	corpora := decodeCorpora(b)
+	for _, name := range []string{"enwik", "randtxt"} {
+		txt, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		for _, minMatch := range []int{2, 8} {
+			c := WriterConfig{MinMatch: minMatch}
+			var buf bytes.Buffer
+			w, err := c.NewWriter(&buf)
+			if err != nil {
+				b.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				b.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				b.Fatalf("w.Close error %s", err)
+			}
+			data := buf.Bytes()
+			name := fmt.Sprintf("%s/minMatch%d", name, minMatch)
+			b.Run(name, func(b *testing.B) {
+				b.SetBytes(int64(len(txt)))
+				for i := 0; i < b.N; i++ {
+					r, err := NewReader(bytes.NewReader(data))
+					if err != nil {
+						b.Fatalf("NewReader error %s", err)
+					}
+					_, err = io.Copy(ioutil.Discard, r)
+					if err != nil {
+						b.Fatalf("io.Copy error %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// decodeOps decodes the LZMA stream in the classic format and returns
+// the operations of the stream as well as the decoded data. The
+// dictionary capacity of the decoder is the value given in the header.
+func decodeOps(t *testing.T, stream []byte) (ops []operation, data []byte) {
+	r, err := ReaderConfig{DictCap: MinDictCap}.NewReader(
+		bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	d := r.d
+	for d.Decompressed() != d.size {
+		op, err := d.readOp()
+		if err == errEOS {
+			break
+		}
+		if err != nil {
+			t.Fatalf("readOp error %s", err)
+		}
+		if err = d.apply(op); err != nil {
+			t.Fatalf("apply error %s", err)
+		}
+		ops = append(ops, op)
+		p := make([]byte, d.Dict.buf.Buffered())
+		d.Dict.Read(p)
+		data = append(data, p...)
+	}
+	return ops, data
+}
+
+// longMatchCorpora provides data whose compression consists mostly of
+// matches of the maximum length. The patterns differ in the distance
+// of the matches: short periods result in overlapping matches.
+func longMatchCorpora() map[string][]byte {
+	const size = 1 << 20
+	corpora := make(map[string][]byte)
+	for _, period := range []int{1, 7, 300, 64 << 10} {
+		pattern := make([]byte, period)
+		rand.New(rand.NewSource(int64(period))).Read(pattern)
+		p := make([]byte, 0, size)
+		for len(p) < size {
+			p = append(p, pattern...)
+		}
+		corpora[fmt.Sprintf("period%d", period)] = p[:size]
+	}
+	return corpora
+}
+
+func BenchmarkDecodeLongMatches(b *testing.B) {
+	corpora := longMatchCorpora()
+	for _, name := range []string{
+		"period1", "period7", "period300", "period65536",
+	} {
+		txt := corpora[name]
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			b.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+		data := buf.Bytes()
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(txt)))
+			for i := 0; i < b.N; i++ {
+				r, err := NewReader(bytes.NewReader(data))
+				if err != nil {
+					b.Fatalf("NewReader error %s", err)
+				}
+				if _, err = io.Copy(ioutil.Discard, r); err != nil {
+					b.Fatalf("io.Copy error %s", err)
+				}
+			}
+		})
+	}
+}
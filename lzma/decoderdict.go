@@ -6,7 +6,6 @@ package lzma
 
 import (
 	"errors"
-	"fmt"
 )
 
 // decoderDict provides the dictionary for the decoder. The whole
@@ -19,11 +18,23 @@ type decoderDict struct {
 // newDecoderDict creates a new decoder dictionary. The whole dictionary
 // will be used as reader buffer.
 func newDecoderDict(dictCap int) (d *decoderDict, err error) {
+	return newDecoderDictAlloc(dictCap, nil)
+}
+
+// newDecoderDictAlloc creates a new decoder dictionary with the buffer
+// provided by the allocator a. If a is nil the buffer is allocated
+// with make.
+func newDecoderDictAlloc(dictCap int, a BufferAllocator) (
+	d *decoderDict, err error) {
 	// lower limit supports easy test cases
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
 		return nil, errors.New("lzma: dictCap out of range")
 	}
-	d = &decoderDict{buf: *newBuffer(dictCap)}
+	buf, err := newBufferAlloc(dictCap, a)
+	if err != nil {
+		return nil, err
+	}
+	d = &decoderDict{buf: *buf}
 	return d, nil
 }
 
@@ -33,6 +44,17 @@ func (d *decoderDict) Reset() {
 	d.head = 0
 }
 
+// preset fills the dictionary with the preset dictionary data. Only
+// the last bytes of p fitting into the dictionary are used. The data
+// will not be returned by Read.
+func (d *decoderDict) preset(p []byte) {
+	if c := d.buf.Cap(); len(p) > c {
+		p = p[len(p)-c:]
+	}
+	n, _ := d.Write(p)
+	d.buf.Discard(n)
+}
+
 // WriteByte writes a single byte into the dictionary. It is used to
 // write literals into the dictionary.
 func (d *decoderDict) WriteByte(c byte) error {
@@ -69,6 +91,44 @@ func (d *decoderDict) byteAt(dist int) byte {
 	return d.buf.data[i]
 }
 
+// window returns the current contents of the dictionary. The slice
+// refers to the dictionary buffer directly unless the contents wrap
+// around the end of the circular buffer. In that case the contents are
+// copied into the slice pointed to by scratch, which is grown as
+// required.
+func (d *decoderDict) window(scratch *[]byte) []byte {
+	n := d.dictLen()
+	i := d.buf.front - n
+	if i >= 0 {
+		return d.buf.data[i:d.buf.front]
+	}
+	i += len(d.buf.data)
+	p := append((*scratch)[:0], d.buf.data[i:]...)
+	p = append(p, d.buf.data[:d.buf.front]...)
+	*scratch = p
+	return p
+}
+
+// readBuffers returns the buffered data as at most two slices of the
+// dictionary buffer and marks the data as read. The slices are stored
+// in bufs. The data will be overwritten by the next write into the
+// dictionary.
+func (d *decoderDict) readBuffers(bufs *[2][]byte) [][]byte {
+	b := &d.buf
+	k := 0
+	if b.rear > b.front {
+		bufs[k] = b.data[b.rear:]
+		k++
+		b.rear = 0
+	}
+	if b.rear < b.front {
+		bufs[k] = b.data[b.rear:b.front]
+		k++
+		b.rear = b.front
+	}
+	return bufs[:k]
+}
+
 // writeMatch writes the match at the top of the dictionary. The given
 // distance must point in the current dictionary and the length must not
 // exceed the maximum length 273 supported in LZMA.
@@ -78,36 +138,45 @@ func (d *decoderDict) byteAt(dist int) byte {
 // first.
 func (d *decoderDict) writeMatch(dist int64, length int) error {
 	if !(0 < dist && dist <= int64(d.dictLen())) {
-		return errors.New("writeMatch: distance out of range")
+		return corruptError("lzma: match distance out of range")
 	}
 	if !(0 < length && length <= maxMatchLen) {
-		return errors.New("writeMatch: length out of range")
+		return corruptError("lzma: match length out of range")
 	}
 	if length > d.buf.Available() {
 		return ErrNoSpace
 	}
 	d.head += int64(length)
 
-	i := d.buf.front - int(dist)
+	// The match is copied in contiguous chunks of the buffer. A
+	// chunk never overlaps its destination, so copy can be used. If
+	// the match overlaps itself, the source index i is kept and the
+	// chunks double in size, because the bytes between i and the
+	// front repeat with the period dist.
+	b := &d.buf
+	i := b.front - int(dist)
 	if i < 0 {
-		i += len(d.buf.data)
+		i += len(b.data)
 	}
 	for length > 0 {
-		var p []byte
-		if i >= d.buf.front {
-			p = d.buf.data[i:]
-			i = 0
+		var n int
+		if i < b.front {
+			n = b.front - i
 		} else {
-			p = d.buf.data[i:d.buf.front]
-			i = d.buf.front
+			n = len(b.data) - i
+		}
+		if m := len(b.data) - b.front; n > m {
+			n = m
 		}
-		if len(p) > length {
-			p = p[:length]
+		if n > length {
+			n = length
 		}
-		if _, err := d.buf.Write(p); err != nil {
-			panic(fmt.Errorf("d.buf.Write returned error %s", err))
+		copy(b.data[b.front:b.front+n], b.data[i:i+n])
+		if !(i < b.front && n == b.front-i) {
+			i = b.addIndex(i, n)
 		}
-		length -= len(p)
+		b.front = b.addIndex(b.front, n)
+		length -= n
 	}
 	return nil
 }
@@ -5,6 +5,8 @@
 package lzma
 
 import (
+	"bytes"
+	"math/rand"
 	"testing"
 )
 
@@ -16,3 +18,48 @@ func TestNewDecoderDict(t *testing.T) {
 		t.Fatalf("error %s", err)
 	}
 }
+
+func TestDecoderDictWriteMatch(t *testing.T) {
+	const dictCap = 1000
+	d, err := newDecoderDict(dictCap)
+	if err != nil {
+		t.Fatalf("newDecoderDict error %s", err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	var want, got []byte
+	p := make([]byte, dictCap)
+	for i := 0; i < 10000; i++ {
+		if d.dictLen() == 0 || rnd.Intn(4) == 0 {
+			c := byte(rnd.Int())
+			if err = d.WriteByte(c); err != nil {
+				t.Fatalf("WriteByte error %s", err)
+			}
+			want = append(want, c)
+		} else {
+			dist := 1 + rnd.Intn(d.dictLen())
+			if k := d.dictLen(); k > 8 && rnd.Intn(2) == 0 {
+				dist = 1 + rnd.Intn(8)
+			}
+			length := 1 + rnd.Intn(maxMatchLen)
+			if length > d.Available() {
+				length = d.Available()
+			}
+			if err = d.writeMatch(int64(dist), length); err != nil {
+				t.Fatalf("writeMatch(%d, %d) error %s",
+					dist, length, err)
+			}
+			for j := 0; j < length; j++ {
+				want = append(want, want[len(want)-dist])
+			}
+		}
+		if d.buf.Buffered() > dictCap/2 {
+			n, _ := d.buf.Read(p)
+			got = append(got, p[:n]...)
+		}
+	}
+	n, _ := d.buf.Read(p)
+	got = append(got, p[:n]...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("dictionary data differs from the expected data")
+	}
+}
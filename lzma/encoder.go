@@ -7,6 +7,7 @@ package lzma
 import (
 	"fmt"
 	"io"
+	"math/bits"
 )
 
 // opLenMargin provides the upper limit of the number of bytes required
@@ -30,6 +31,11 @@ type encoderFlags uint32
 const (
 	// eosMarker requests an EOS marker to be written.
 	eosMarker encoderFlags = 1 << iota
+	// optimalParse requests the look-ahead parsing.
+	optimalParse
+	// storeIncompressible requests that chunks starting with data
+	// classified as already compressed are stored without encoding.
+	storeIncompressible
 )
 
 // Encoder compresses data buffered in the encoder dictionary and writes
@@ -43,6 +49,29 @@ type encoder struct {
 	marker bool
 	limit  bool
 	margin int
+	// look ahead one byte before encoding a match
+	optimal bool
+	// number of bytes the dictionary head is ahead of the position
+	// of the operation to be encoded
+	back int
+	// matches shorter than minMatch are replaced by literals
+	minMatch int
+	// ascending dictionary positions of barriers that matches must
+	// not cross; only the last barrier not after the current
+	// position and the following ones are kept
+	barriers []int64
+	// largest distance of the matches written
+	maxDistance int64
+	// receives the trace of the operations if not nil
+	trace io.Writer
+	// check the data at the start of a chunk with IsLikelyCompressed
+	storeIncompressible bool
+	// the data of the current chunk is stored without encoding
+	stored bool
+	// number of bytes of the chunk classified as already compressed
+	checked int64
+	// buffer for the sample checked by IsLikelyCompressed
+	sample []byte
 }
 
 // newEncoder creates a new encoder. If the byte writer must be
@@ -57,12 +86,15 @@ func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
 		return nil, err
 	}
 	e = &encoder{
-		dict:   dict,
-		state:  state,
-		re:     re,
-		marker: flags&eosMarker != 0,
-		start:  dict.Pos(),
-		margin: opLenMargin,
+		dict:    dict,
+		state:   state,
+		re:      re,
+		marker:  flags&eosMarker != 0,
+		optimal: flags&optimalParse != 0,
+		start:   dict.Pos(),
+		margin:  opLenMargin,
+
+		storeIncompressible: flags&storeIncompressible != 0,
 	}
 	if e.marker {
 		e.margin += 5
@@ -88,6 +120,23 @@ func (e *encoder) Write(p []byte) (n int, err error) {
 	}
 }
 
+// WriteString writes the bytes of s into the dictionary. It works like
+// Write but doesn't require the conversion of the string into a byte
+// slice.
+func (e *encoder) WriteString(s string) (n int, err error) {
+	for {
+		k, err := e.dict.WriteString(s[n:])
+		n += k
+		if err == ErrNoSpace {
+			if err = e.compress(0); err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
 // Reopen reopens the encoder with a new byte writer.
 func (e *encoder) Reopen(bw io.ByteWriter) error {
 	var err error
@@ -96,23 +145,38 @@ func (e *encoder) Reopen(bw io.ByteWriter) error {
 	}
 	e.start = e.dict.Pos()
 	e.limit = false
+	e.stored = false
 	return nil
 }
 
+// pos returns the position of the operation to be encoded.
+func (e *encoder) pos() int64 {
+	return e.dict.Pos() - int64(e.back)
+}
+
+// byteAt returns the byte at the given distance from the position of
+// the operation to be encoded.
+func (e *encoder) byteAt(distance int) byte {
+	return e.dict.ByteAt(distance + e.back)
+}
+
 // writeLiteral writes a literal into the LZMA stream
 func (e *encoder) writeLiteral(l lit) error {
 	var err error
-	state, state2, _ := e.state.states(e.dict.Pos())
+	state, state2, _ := e.state.states(e.pos())
 	if err = e.state.isMatch[state2].Encode(e.re, 0); err != nil {
 		return err
 	}
-	litState := e.state.litState(e.dict.ByteAt(1), e.dict.Pos())
-	match := e.dict.ByteAt(int(e.state.rep[0]) + 1)
+	litState := e.state.litState(e.byteAt(1), e.pos())
+	match := e.byteAt(int(e.state.rep[0]) + 1)
 	err = e.state.litCodec.Encode(e.re, l.b, state, match, litState)
 	if err != nil {
 		return err
 	}
 	e.state.updateStateLiteral()
+	if e.trace != nil {
+		return traceLit(e.trace, l.b)
+	}
 	return nil
 }
 
@@ -138,7 +202,7 @@ func (e *encoder) writeMatch(m match) error {
 			"match length %d out of range; dist %d rep[0] %d",
 			m.n, dist, e.state.rep[0]))
 	}
-	state, state2, posState := e.state.states(e.dict.Pos())
+	state, state2, posState := e.state.states(e.pos())
 	if err = e.state.isMatch[state2].Encode(e.re, 1); err != nil {
 		return err
 	}
@@ -152,6 +216,11 @@ func (e *encoder) writeMatch(m match) error {
 	if err = e.state.isRep[state].Encode(e.re, b); err != nil {
 		return err
 	}
+	if e.trace != nil {
+		if err = e.traceMatch(m, g); err != nil {
+			return err
+		}
+	}
 	n := uint32(m.n - minMatchLen)
 	if b == 0 {
 		// simple match
@@ -202,6 +271,18 @@ func (e *encoder) writeMatch(m match) error {
 	return e.state.repLenCodec.Encode(e.re, n, posState)
 }
 
+// traceMatch writes the trace line for the match m. The value g is the
+// index of the repeated distance used or 4 for a new distance.
+func (e *encoder) traceMatch(m match, g int) error {
+	switch {
+	case m == eosMatch:
+		return traceEOS(e.trace)
+	case g < 4:
+		return traceRep(e.trace, g, m.n)
+	}
+	return traceMatch(e.trace, m.n, m.distance)
+}
+
 // writeOp writes a single operation to the range encoder. The function
 // checks whether there is enough space available to close the LZMA
 // stream.
@@ -213,6 +294,9 @@ func (e *encoder) writeOp(op operation) error {
 	case lit:
 		return e.writeLiteral(x)
 	case match:
+		if x.distance > e.maxDistance {
+			e.maxDistance = x.distance
+		}
 		return e.writeMatch(x)
 	default:
 		panic("unexpected operation")
@@ -229,17 +313,215 @@ func (e *encoder) compress(flags compressFlags) error {
 		n = maxMatchLen - 1
 	}
 	d := e.dict
+	if e.storeIncompressible && e.Compressed() == 0 && d.Buffered() > n {
+		e.stored = IsLikelyCompressed(e.peekSample())
+		e.checked = storeSampleLen
+	}
+	if e.stored {
+		return e.store(n)
+	}
 	m := d.m
 	for d.Buffered() > n {
-		op := m.NextOp(e.state.rep)
+		op := e.filter(m.NextOp(e.state.rep))
+		if e.optimal {
+			if err := e.writeOpLookAhead(op); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.writeOp(op); err != nil {
+			return err
+		}
+		d.Discard(op.Len())
+	}
+	return nil
+}
+
+// storeSampleLen is the size of the sample taken from the start of a
+// chunk to decide whether its data is stored without encoding.
+const storeSampleLen = 4096
+
+// peekSample returns up to storeSampleLen bytes from the head of the
+// dictionary buffer.
+func (e *encoder) peekSample() []byte {
+	if e.sample == nil {
+		e.sample = make([]byte, storeSampleLen)
+	}
+	k, _ := e.dict.buf.Peek(e.sample)
+	return e.sample[:k]
+}
+
+// maxStored returns the maximum number of bytes stored in a chunk. An
+// uncompressed LZMA2 chunk is limited to 64 KiB and its data is copied
+// from the dictionary.
+func (e *encoder) maxStored() int64 {
+	n := int64(e.dict.capacity)
+	if n > maxCompressed {
+		n = maxCompressed
+	}
+	return n
+}
+
+// store moves data from the dictionary buffer into the dictionary
+// without encoding it until only n bytes are buffered. Every sample is
+// checked with IsLikelyCompressed before it is stored. ErrLimit is
+// returned if the chunk can't store more data or the next sample
+// doesn't look compressed.
+func (e *encoder) store(n int) error {
+	d := e.dict
+	for d.Buffered() > n {
+		c := e.Compressed()
+		if c >= e.checked {
+			if !IsLikelyCompressed(e.peekSample()) {
+				return ErrLimit
+			}
+			e.checked = c + storeSampleLen
+		}
+		k := e.maxStored() - c
+		if k <= 0 {
+			return ErrLimit
+		}
+		if e.checked-c < k {
+			k = e.checked - c
+		}
+		if b := int64(d.Buffered() - n); b < k {
+			k = b
+		}
+		if k > maxMatchLen {
+			k = maxMatchLen
+		}
+		d.Discard(int(k))
+	}
+	return nil
+}
+
+// filter checks the operation found at the head of the dictionary. A
+// match crossing a barrier is shortened to end at the barrier. A match
+// that is too short afterwards, or shorter than minMatch, is replaced
+// by the literal at the head. Short repetitions of a single byte are
+// kept, because they are cheaper than a literal.
+func (e *encoder) filter(op operation) operation {
+	m, ok := op.(match)
+	if !ok {
+		return op
+	}
+	n := m.n
+	if len(e.barriers) > 0 {
+		n = e.barrierLen(m)
+	}
+	if n == m.n && (n >= e.minMatch || n < minMatchLen) {
+		return op
+	}
+	if n >= minMatchLen && n >= e.minMatch {
+		m.n = n
+		return m
+	}
+	var p [1]byte
+	e.dict.buf.Peek(p[:])
+	return lit{p[0]}
+}
+
+// barrierLen returns the length of the match m at the head of the
+// dictionary limited by the barriers. Zero is returned if the match
+// references data before the last barrier.
+func (e *encoder) barrierLen(m match) int {
+	pos := e.dict.Pos()
+	b := e.barriers
+	for len(b) > 1 && b[1] <= pos {
+		b = b[1:]
+	}
+	e.barriers = b
+	if b[0] <= pos {
+		if pos-m.distance < b[0] {
+			return 0
+		}
+		b = b[1:]
+	}
+	if len(b) > 0 && pos+int64(m.n) > b[0] {
+		return int(b[0] - pos)
+	}
+	return m.n
+}
+
+// addBarrier adds a barrier at the dictionary position pos.
+func (e *encoder) addBarrier(pos int64) {
+	if k := len(e.barriers); k > 0 && e.barriers[k-1] >= pos {
+		return
+	}
+	e.barriers = append(e.barriers, pos)
+}
+
+// lookAheadMaxLen is the length of matches that are encoded without
+// looking ahead.
+const lookAheadMaxLen = 32
+
+// writeOpLookAhead writes the operation op, which has been found at
+// the head of the dictionary, and discards its bytes. If op is a short
+// match, the operation at the next position is checked. If a literal
+// followed by that operation is estimated to require fewer bits per
+// byte than op, the literal is written instead of op.
+func (e *encoder) writeOpLookAhead(op operation) error {
+	d := e.dict
+	m, ok := op.(match)
+	if !ok || m.n >= lookAheadMaxLen || d.Buffered() <= m.n ||
+		e.re.Available() < int64(2*e.margin) {
 		if err := e.writeOp(op); err != nil {
 			return err
 		}
 		d.Discard(op.Len())
+		return nil
+	}
+	// Discard moves the byte at the head into d.data[0].
+	d.Discard(1)
+	b := d.data[0]
+	e.back = 1
+	next, ok := e.filter(d.m.NextOp(e.state.rep)).(match)
+	if !ok || 1+next.n <= m.n ||
+		e.price(lit{b})*m.n+e.price(next)*m.n >=
+			e.price(m)*(1+next.n) {
+		err := e.writeOp(m)
+		e.back = 0
+		if err != nil {
+			return err
+		}
+		d.Discard(m.n - 1)
+		return nil
+	}
+	err := e.writeOp(lit{b})
+	e.back = 0
+	if err != nil {
+		return err
+	}
+	if err = e.writeOp(next); err != nil {
+		return err
 	}
+	d.Discard(next.n)
 	return nil
 }
 
+// price estimates the number of bits required to encode the operation.
+// The estimate ignores the probabilities of the range encoder.
+func (e *encoder) price(op operation) int {
+	m, ok := op.(match)
+	if !ok {
+		return 9
+	}
+	p := 3
+	switch {
+	case m.n >= 18:
+		p = 10
+	case m.n >= 10:
+		p = 6
+	}
+	dist := uint32(m.distance - minDistance)
+	for _, r := range e.state.rep {
+		if r == dist {
+			return p + 6
+		}
+	}
+	return p + 10 + bits.Len32(dist)
+}
+
 // eosMatch is a pseudo operation that indicates the end of the stream.
 var eosMatch = match{distance: maxDistance, n: minMatchLen}
 
@@ -16,6 +16,9 @@ type matcher interface {
 	io.Writer
 	SetDict(d *encoderDict)
 	NextOp(rep [4]uint32) operation
+	// Reset removes all data from the matcher without releasing
+	// its memory.
+	Reset()
 }
 
 // encoderDict provides the dictionary of the encoder. It includes an
@@ -32,6 +35,14 @@ type encoderDict struct {
 // newEncoderDict creates the encoder dictionary. The argument bufSize
 // defines the size of the additional buffer.
 func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error) {
+	return newEncoderDictAlloc(dictCap, bufSize, m, nil)
+}
+
+// newEncoderDictAlloc creates the encoder dictionary with the buffer
+// provided by the allocator a. If a is nil the buffer is allocated
+// with make.
+func newEncoderDictAlloc(dictCap, bufSize int, m matcher,
+	a BufferAllocator) (d *encoderDict, err error) {
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
 		return nil, errors.New(
 			"lzma: dictionary capacity out of range")
@@ -40,8 +51,12 @@ func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error)
 		return nil, errors.New(
 			"lzma: buffer size must be larger than zero")
 	}
+	buf, err := newBufferAlloc(dictCap+bufSize, a)
+	if err != nil {
+		return nil, err
+	}
 	d = &encoderDict{
-		buf:      *newBuffer(dictCap + bufSize),
+		buf:      *buf,
 		capacity: dictCap,
 		m:        m,
 	}
@@ -49,6 +64,34 @@ func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error)
 	return d, nil
 }
 
+// reset clears the dictionary and its matcher for the use as a new
+// dictionary. The buffers are reused.
+func (d *encoderDict) reset() {
+	d.buf.Reset()
+	d.head = 0
+	d.m.Reset()
+}
+
+// preset fills the dictionary with the preset dictionary data. Only
+// the last capacity bytes of p are used. The function must be called
+// before any other data is written into the dictionary.
+func (d *encoderDict) preset(p []byte) {
+	if len(p) > d.capacity {
+		p = p[len(p)-d.capacity:]
+	}
+	for len(p) > 0 {
+		k, _ := d.Write(p)
+		p = p[k:]
+		for d.Buffered() > 0 {
+			n := d.Buffered()
+			if n > maxMatchLen {
+				n = maxMatchLen
+			}
+			d.Discard(n)
+		}
+	}
+}
+
 // Discard discards n bytes. Note that n must not be larger than
 // MaxMatchLen.
 func (d *encoderDict) Discard(n int) {
@@ -100,6 +143,21 @@ func (d *encoderDict) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteString writes the bytes of s into the dictionary buffer. It
+// works like Write.
+func (d *encoderDict) WriteString(s string) (n int, err error) {
+	m := d.Available()
+	if len(s) > m {
+		s = s[:m]
+		err = ErrNoSpace
+	}
+	var e error
+	if n, e = d.buf.WriteString(s); e != nil {
+		err = e
+	}
+	return n, err
+}
+
 // Pos returns the position of the head.
 func (d *encoderDict) Pos() int64 { return d.head }
 
@@ -0,0 +1,37 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// ErrFormat and ErrCorrupt classify decoding errors. ErrFormat
+// indicates that the data is not in the expected format at all, for
+// instance because the header is invalid; a caller may try another
+// format. ErrCorrupt indicates that the data is an LZMA stream, but its
+// compressed data is corrupt. The errors returned by the readers are
+// more specific; use errors.Is to test for these values. A truncated
+// stream is reported as io.ErrUnexpectedEOF.
+var (
+	ErrFormat  = errors.New("lzma: invalid format")
+	ErrCorrupt = errors.New("lzma: corrupt data")
+)
+
+// formatError is an error that matches ErrFormat.
+type formatError string
+
+// Error returns the error message.
+func (e formatError) Error() string { return string(e) }
+
+// Is supports errors.Is(err, ErrFormat).
+func (e formatError) Is(target error) bool { return target == ErrFormat }
+
+// corruptError is an error that matches ErrCorrupt.
+type corruptError string
+
+// Error returns the error message.
+func (e corruptError) Error() string { return string(e) }
+
+// Is supports errors.Is(err, ErrCorrupt).
+func (e corruptError) Is(target error) bool { return target == ErrCorrupt }
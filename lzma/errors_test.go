@@ -0,0 +1,86 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// decodeAll decodes the LZMA stream in data and returns the first
+// error.
+func decodeAll(data []byte) error {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_, err = ioutil.ReadAll(r)
+	return err
+}
+
+func TestErrorClasses(t *testing.T) {
+	valid := compressWithConfig(t, WriterConfig{}, []byte(testString))
+	modify := func(i int, b byte) []byte {
+		p := append([]byte(nil), valid...)
+		p[i] = b
+		return p
+	}
+	readFile := func(name string) []byte {
+		p, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		return p
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"properties", modify(0, 0xff), ErrFormat},
+		{"rangeCoderStart", modify(HeaderLen, 1), ErrCorrupt},
+		{"corrupted", readFile("examples/bad_corrupted.lzma"),
+			ErrCorrupt},
+		{"incorrectSize", readFile("examples/bad_incorrect_size.lzma"),
+			ErrCorrupt},
+		{"eosIncorrectSize",
+			readFile("examples/bad_eos_incorrect_size.lzma"),
+			ErrCorrupt},
+		{"valid", readFile("examples/a_eos.lzma"), nil},
+	}
+	for _, tc := range tests {
+		err := decodeAll(tc.data)
+		if tc.want == nil {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %s", tc.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s: got error %v; want %v", tc.name, err,
+				tc.want)
+		}
+		other := ErrCorrupt
+		if tc.want == ErrCorrupt {
+			other = ErrFormat
+		}
+		if errors.Is(err, other) {
+			t.Fatalf("%s: error %v matches %v", tc.name, err,
+				other)
+		}
+	}
+
+	if _, err := DecodeHeader(valid[:5]); !errors.Is(err, ErrFormat) {
+		t.Fatalf("short header: got error %v; want %v", err,
+			ErrFormat)
+	}
+	if err := decodeAll(valid[:len(valid)-3]); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated stream: got error %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+}
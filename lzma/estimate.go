@@ -0,0 +1,131 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Sampling parameters for EstimateRatio.
+const (
+	estimateSamples   = 8
+	estimateSampleLen = 64 * 1024
+)
+
+// EstimateRatio estimates the compression ratio for the size bytes
+// provided by r. The ratio is the compressed size divided by the
+// uncompressed size, so values near or above 1 indicate that
+// compression is not worthwhile, e.g. for data that is already
+// compressed.
+//
+// The result is only an estimate. Up to eight windows of 64 KiB,
+// evenly spread over the input, are compressed independently and the
+// ratio is extrapolated from them. Matches across the windows and
+// distances beyond a window are not taken into account, so the
+// actual ratio for large, repetitive inputs is usually better. Inputs
+// smaller than the sampled windows are compressed completely.
+//
+// The parameters p provide the properties LC, LP and PB; the
+// dictionary capacity is limited to the window size. If p is nil the
+// default properties are used.
+func EstimateRatio(r io.ReaderAt, size int64, p *Parameters) (
+	ratio float64, err error) {
+	if size <= 0 {
+		return 0, errors.New("lzma: size must be positive")
+	}
+	var c WriterConfig
+	if p != nil {
+		c.Properties = &Properties{LC: p.LC, LP: p.LP, PB: p.PB}
+	}
+	c.NoHeader = true
+
+	n := int64(estimateSamples)
+	sampleLen := int64(estimateSampleLen)
+	if size <= n*sampleLen {
+		n, sampleLen = 1, size
+	}
+	c.DictCap = int(sampleLen)
+	if c.DictCap < MinDictCap {
+		c.DictCap = MinDictCap
+	}
+
+	buf := make([]byte, sampleLen)
+	cw := countingWriter{w: ioutil.Discard}
+	var total int64
+	for i := int64(0); i < n; i++ {
+		// windows start evenly spread, the last one ends at size
+		var off int64
+		if n > 1 {
+			off = i * (size - sampleLen) / (n - 1)
+		}
+		k, err := r.ReadAt(buf, off)
+		if k < len(buf) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		w, err := c.NewWriter(&cw)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = w.Write(buf); err != nil {
+			return 0, err
+		}
+		if err = w.Close(); err != nil {
+			return 0, err
+		}
+		total += sampleLen
+	}
+	return float64(cw.n) / float64(total), nil
+}
+
+// Parameters for IsLikelyCompressed.
+const (
+	// smaller samples are not classified
+	minEntropySample = 512
+	// threshold for the corrected entropy in bits per byte
+	compressedEntropy = 7.5
+)
+
+// IsLikelyCompressed reports whether the sample looks like already
+// compressed or encrypted data, which LZMA can't compress further. Such
+// data can be stored in uncompressed LZMA2 chunks instead, saving the
+// time of a futile compression.
+//
+// The function is a fast approximation. It computes the entropy of
+// the byte distribution of the sample and reports true if the entropy
+// is near 8 bits per byte. Repetitions of byte sequences are not
+// detected, so data like repeated random blocks is misclassified. The
+// sample should have a size of at least a few KiB; for samples of less
+// than 512 bytes the function always returns false. EstimateRatio
+// provides a slower but more accurate estimate.
+func IsLikelyCompressed(sample []byte) bool {
+	n := len(sample)
+	if n < minEntropySample {
+		return false
+	}
+	var freq [256]int
+	for _, b := range sample {
+		freq[b]++
+	}
+	var h float64
+	k := 0
+	for _, f := range freq {
+		if f == 0 {
+			continue
+		}
+		k++
+		p := float64(f) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	// The entropy computed from a sample is too small on average.
+	// The Miller-Madow correction compensates most of the bias.
+	h += float64(k-1) / (2 * float64(n) * math.Ln2)
+	return h >= compressedEntropy
+}
@@ -0,0 +1,111 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestEstimateRatio(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(152)), 2<<20))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	random := make([]byte, 2<<20)
+	rand.New(rand.NewSource(152)).Read(random)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		min, max float64
+	}{
+		{"text", txt, 0.1, 0.6},
+		{"smallText", txt[:10000], 0.1, 0.8},
+		{"random", random, 0.99, 1.1},
+		{"smallRandom", random[:10000], 0.99, 1.1},
+	}
+	for _, tc := range tests {
+		ratio, err := EstimateRatio(bytes.NewReader(tc.data),
+			int64(len(tc.data)), nil)
+		if err != nil {
+			t.Fatalf("%s: EstimateRatio error %s", tc.name, err)
+		}
+		t.Logf("%s: estimated ratio %.3f", tc.name, ratio)
+		if !(tc.min <= ratio && ratio <= tc.max) {
+			t.Errorf("%s: ratio %.3f not in [%.2f,%.2f]",
+				tc.name, ratio, tc.min, tc.max)
+		}
+	}
+
+	// The estimate must be near the actual ratio.
+	actual := float64(len(compressWithConfig(t, WriterConfig{}, txt))) /
+		float64(len(txt))
+	ratio, err := EstimateRatio(bytes.NewReader(txt), int64(len(txt)),
+		&Parameters{LC: 3, LP: 0, PB: 2})
+	if err != nil {
+		t.Fatalf("EstimateRatio error %s", err)
+	}
+	t.Logf("text: actual ratio %.3f", actual)
+	if d := ratio - actual; d < -0.1 || d > 0.1 {
+		t.Errorf("estimate %.3f differs from actual ratio %.3f",
+			ratio, actual)
+	}
+
+	if _, err = EstimateRatio(bytes.NewReader(txt),
+		int64(len(txt))+1, nil); err == nil {
+		t.Errorf("EstimateRatio beyond the data returned no error")
+	}
+	if _, err = EstimateRatio(bytes.NewReader(nil), 0, nil); err == nil {
+		t.Errorf("EstimateRatio for size 0 returned no error")
+	}
+}
+
+func TestIsLikelyCompressed(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(189)), 1<<20))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err = zw.Write(txt); err != nil {
+		t.Fatalf("gzip Write error %s", err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("gzip Close error %s", err)
+	}
+	xzData, err := ioutil.ReadFile("../testdata/golden/random.bin.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"text", txt[:64<<10], false},
+		{"smallText", txt[:1024], false},
+		{"lzma", compressWithConfig(t, WriterConfig{}, txt)[:64<<10],
+			true},
+		{"gzip", gz.Bytes()[1024 : 1024+4096], true},
+		{"xz", xzData, true},
+		{"zeros", make([]byte, 4096), false},
+		{"tooSmall", gz.Bytes()[1024:1100], false},
+	}
+	for _, tc := range tests {
+		if got := IsLikelyCompressed(tc.data); got != tc.want {
+			t.Errorf("%s: IsLikelyCompressed returned %t; want %t",
+				tc.name, got, tc.want)
+		}
+	}
+}
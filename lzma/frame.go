@@ -0,0 +1,107 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// WriteFrame compresses data as a self-contained LZMA stream in the
+// classic format and writes it to w prefixed by its length encoded as
+// unsigned varint. The uncompressed size is stored in the header of
+// the stream. The parameters p provide the properties and the
+// dictionary size; the EOS field requests an end-of-stream marker. If
+// p is nil or its DictSize is zero, the dictionary size is derived from
+// the size of the data.
+func WriteFrame(w io.Writer, data []byte, p *Parameters) error {
+	c := WriterConfig{
+		Size:         int64(len(data)),
+		SizeInHeader: true,
+		AutoDictSize: true,
+	}
+	if p != nil {
+		c.Properties = &Properties{LC: p.LC, LP: p.LP, PB: p.PB}
+		c.EOSMarker = p.EOS
+		if p.DictSize != 0 {
+			c.DictCap = p.DictSize
+			c.AutoDictSize = false
+		}
+	}
+	var buf bytes.Buffer
+	lw, err := c.NewWriter(&buf)
+	if err != nil {
+		return err
+	}
+	if _, err = lw.Write(data); err != nil {
+		return err
+	}
+	if err = lw.Close(); err != nil {
+		return err
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	k := binary.PutUvarint(prefix[:], uint64(buf.Len()))
+	if _, err = w.Write(prefix[:k]); err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// ReadFrame reads a frame written by WriteFrame from r and returns the
+// uncompressed data. It reads exactly the bytes of the frame, so
+// frames can be read back-to-back from a stream connection. At the end
+// of r before a frame starts io.EOF is returned; a truncated frame
+// results in io.ErrUnexpectedEOF.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(ByteReader(r))
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<63-1 {
+		return nil, errors.New("lzma: frame length out of range")
+	}
+	fr := &frameReader{r: r, n: int64(n)}
+	lzr, err := NewReader(bufio.NewReader(fr))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(lzr)
+	if err != nil {
+		return nil, err
+	}
+	if lzr.InputOffset() != int64(n) {
+		return nil, errors.New(
+			"lzma: frame length doesn't match LZMA stream")
+	}
+	return data, nil
+}
+
+// frameReader reads the n bytes of a frame from r. If r ends before
+// the frame is complete, io.ErrUnexpectedEOF is returned.
+type frameReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads data of the frame into p.
+func (f *frameReader) Read(p []byte) (n int, err error) {
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.n {
+		p = p[:f.n]
+	}
+	n, err = f.r.Read(p)
+	f.n -= int64(n)
+	if err == io.EOF && f.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
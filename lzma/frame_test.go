@@ -0,0 +1,77 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFrames(t *testing.T) {
+	messages := [][]byte{
+		[]byte(testString),
+		{},
+		periodicData(100000, 3),
+		[]byte("a"),
+	}
+	params := []*Parameters{
+		nil,
+		{LC: 0, LP: 0, PB: 0, DictSize: MinDictCap, EOS: true},
+		{LC: 3, LP: 0, PB: 2, DictSize: 1 << 20},
+		nil,
+	}
+	var buf bytes.Buffer
+	for i, m := range messages {
+		if err := WriteFrame(&buf, m, params[i]); err != nil {
+			t.Fatalf("WriteFrame error %s", err)
+		}
+	}
+	stream := buf.Bytes()
+
+	// one byte at a time ensures that no frame is read too far
+	r := iotest.OneByteReader(bytes.NewReader(stream))
+	for i, m := range messages {
+		p, err := ReadFrame(r)
+		if err != nil {
+			t.Fatalf("frame %d: ReadFrame error %s", i, err)
+		}
+		if !bytes.Equal(p, m) {
+			t.Fatalf("frame %d: got %d bytes; want %d", i,
+				len(p), len(m))
+		}
+	}
+	if _, err := ReadFrame(r); err != io.EOF {
+		t.Fatalf("ReadFrame at end returned %v; want %v", err, io.EOF)
+	}
+
+	// truncate inside the second frame
+	for _, k := range []int{1, 2, 10, 30, len(stream) - 1} {
+		r := bytes.NewReader(stream[:k])
+		var err error
+		for err == nil {
+			_, err = ReadFrame(r)
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("truncated at %d: got error %v; want %v",
+				k, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestFrameLengthMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, []byte(testString), nil); err != nil {
+		t.Fatalf("WriteFrame error %s", err)
+	}
+	// an additional byte in the frame
+	data := buf.Bytes()
+	data[0]++
+	data = append(data, 0)
+	if _, err := ReadFrame(bytes.NewReader(data)); err == nil {
+		t.Fatalf("ReadFrame accepted a frame with trailing data")
+	}
+}
@@ -58,6 +58,11 @@ type hashTable struct {
 	wr hash.Roller
 	// hash roller for computing arbitrary hashes
 	hr hash.Roller
+	// number of positions requested from the hash table
+	candidates int
+	// matches of this length are accepted without checking further
+	// positions
+	niceLen int
 	// preallocated slices
 	p         [maxMatches]int64
 	distances [maxMatches + shortDists]int
@@ -99,12 +104,26 @@ func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
 		wordLen: wordLen,
 		wr:      newRoller(wordLen),
 		hr:      newRoller(wordLen),
+
+		candidates: maxMatches,
+		niceLen:    maxMatchLen,
 	}
 	return t, nil
 }
 
 func (t *hashTable) SetDict(d *encoderDict) { t.dict = d }
 
+// Reset clears the hash table. The options set by setGreedy are kept.
+func (t *hashTable) Reset() {
+	for i := range t.t {
+		t.t[i] = 0
+	}
+	t.front = 0
+	t.hoff = -int64(t.wordLen)
+	t.wr = newRoller(t.wordLen)
+	t.hr = newRoller(t.wordLen)
+}
+
 // buffered returns the number of bytes that are currently hashed.
 func (t *hashTable) buffered() int {
 	n := t.hoff + 1
@@ -233,9 +252,23 @@ func (t *hashTable) Matches(p []byte, positions []int64) int {
 	return t.getMatches(h, positions)
 }
 
+// repBonus defines the preference for repetition distances. A match
+// with a new distance must be more than repBonus bytes longer than a
+// match using a repetition distance to be preferred.
+const repBonus = 1
+
+// isRep checks whether dist is the distance of one of the
+// repetitions.
+func isRep(dist int, rep []uint32) bool {
+	for _, r := range rep {
+		if int(r)+minDistance == dist {
+			return true
+		}
+	}
+	return false
+}
+
 // NextOp identifies the next operation using the hash table.
-//
-// TODO: Use all repetitions to find matches.
 func (t *hashTable) NextOp(rep [4]uint32) operation {
 	// get positions
 	data := t.dict.data[:maxMatchLen]
@@ -245,11 +278,38 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	if n < t.wordLen {
 		p = t.p[:0]
 	} else {
-		p = t.p[:maxMatches]
+		p = t.p[:t.candidates]
 		n = t.Matches(data[:t.wordLen], p)
 		p = p[:n]
 	}
 
+	// Matches using the distance of a repetition are much cheaper
+	// to encode than matches with a new distance. So we check them
+	// first and accept other matches only if they are more than
+	// repBonus bytes longer.
+	var m match
+	dictLen := t.dict.DictLen()
+	for g, r := range rep {
+		dist := int(r) + minDistance
+		if dist > dictLen || isRep(dist, rep[:g]) {
+			continue
+		}
+		n := t.dict.buf.matchLen(dist, data)
+		if n == 1 && g != 0 {
+			continue
+		}
+		if n > m.n {
+			m = match{int64(dist), n}
+		}
+	}
+	minLen := m.n
+	if m.n > 0 {
+		minLen += repBonus
+	}
+	if minLen >= len(data) {
+		return m
+	}
+
 	// convert positions in potential distances
 	head := t.dict.head
 	dists := append(t.distances[:0], 1, 2, 3, 4, 5, 6, 7, 8)
@@ -261,10 +321,8 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	}
 
 	// check distances
-	var m match
-	dictLen := t.dict.DictLen()
 	for _, dist := range dists {
-		if dist > dictLen {
+		if dist > dictLen || isRep(dist, rep[:]) {
 			continue
 		}
 
@@ -274,29 +332,28 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 		// the given distance, we test the first byte that would
 		// make the match longer. If it doesn't match the byte
 		// to match, we don't to care any longer.
-		i := t.dict.buf.rear - dist + m.n
+		i := t.dict.buf.rear - dist + minLen
 		if i < 0 {
 			i += len(t.dict.buf.data)
+		} else if i >= len(t.dict.buf.data) {
+			i -= len(t.dict.buf.data)
 		}
-		if t.dict.buf.data[i] != data[m.n] {
+		if t.dict.buf.data[i] != data[minLen] {
 			// We can't get a longer match. Jump to the next
 			// distance.
 			continue
 		}
 
 		n := t.dict.buf.matchLen(dist, data)
-		switch n {
-		case 0:
+		if n <= 1 {
 			continue
-		case 1:
-			if uint32(dist-minDistance) != rep[0] {
-				continue
-			}
 		}
-		if n > m.n {
+		if n > minLen {
 			m = match{int64(dist), n}
-			if n == len(data) {
-				// No better match will be found.
+			minLen = n
+			if n == len(data) || n >= t.niceLen {
+				// No better match will be found or the match
+				// is good enough.
 				break
 			}
 		}
@@ -45,3 +45,33 @@ func TestHashTable(t *testing.T) {
 		}
 	}
 }
+
+func TestHashTableNextOpRep(t *testing.T) {
+	m, err := newHashTable(MinDictCap, 4)
+	if err != nil {
+		t.Fatalf("newHashTable error %s", err)
+	}
+	d, err := newEncoderDict(MinDictCap, 4096, m)
+	if err != nil {
+		t.Fatalf("newEncoderDict error %s", err)
+	}
+	const s = "abcdefXabcdefYabcdef"
+	if _, err = d.Write([]byte(s)); err != nil {
+		t.Fatalf("d.Write error %s", err)
+	}
+	d.Discard(14)
+	// The match at distance 7 has the same length as the match at
+	// distance 14, but 14 is the distance of a repetition.
+	rep := [4]uint32{13, 0, 0, 0}
+	op := m.NextOp(rep)
+	want := match{distance: 14, n: 6}
+	if op != want {
+		t.Fatalf("NextOp returned %v; want %v", op, want)
+	}
+	// Without the repetition the nearest distance is selected.
+	op = m.NextOp([4]uint32{})
+	want = match{distance: 7, n: 6}
+	if op != want {
+		t.Fatalf("NextOp returned %v; want %v", op, want)
+	}
+}
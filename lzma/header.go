@@ -5,8 +5,10 @@
 package lzma
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 )
 
 // uint32LE reads an uint32 integer from a byte slice
@@ -88,7 +90,7 @@ func (h *header) marshalBinary() (data []byte, err error) {
 
 	// uncompressed size
 	var s uint64
-	if h.size > 0 {
+	if h.size >= 0 {
 		s = uint64(h.size)
 	} else {
 		s = noHeaderSize
@@ -101,21 +103,21 @@ func (h *header) marshalBinary() (data []byte, err error) {
 // unmarshalBinary unmarshals the header.
 func (h *header) unmarshalBinary(data []byte) error {
 	if len(data) != HeaderLen {
-		return errors.New("lzma.unmarshalBinary: data has wrong length")
+		return formatError("lzma: header has wrong length")
 	}
 
 	// properties
 	var err error
 	if h.properties, err = PropertiesForCode(data[0]); err != nil {
-		return err
+		return formatError("lzma: invalid properties code in header")
 	}
 
 	// dictionary capacity
 	h.dictCap = int(uint32LE(data[1:]))
 	if h.dictCap < 0 {
-		return errors.New(
-			"LZMA header: dictionary capacity exceeds maximum " +
-				"integer")
+		return formatError(
+			"lzma: dictionary capacity in header exceeds " +
+				"maximum integer")
 	}
 
 	// uncompressed size
@@ -125,8 +127,8 @@ func (h *header) unmarshalBinary(data []byte) error {
 	} else {
 		h.size = int64(s)
 		if h.size < 0 {
-			return errors.New(
-				"LZMA header: uncompressed size " +
+			return formatError(
+				"lzma: uncompressed size in header " +
 					"out of int64 range")
 		}
 	}
@@ -134,6 +136,68 @@ func (h *header) unmarshalBinary(data []byte) error {
 	return nil
 }
 
+// paramsHeader converts the parameters into the header structure and
+// checks them.
+func paramsHeader(p *Parameters) (h header, err error) {
+	h = header{
+		properties: Properties{LC: p.LC, LP: p.LP, PB: p.PB},
+		dictCap:    p.DictSize,
+		size:       -1,
+	}
+	if err = h.properties.verify(); err != nil {
+		return h, err
+	}
+	if !(0 <= h.dictCap && int64(h.dictCap) <= MaxDictCap) {
+		return h, errors.New("lzma: dictionary size out of range")
+	}
+	if p.SizeInHeader {
+		if p.Size < 0 {
+			return h, errors.New("lzma: negative size")
+		}
+		h.size = p.Size
+	}
+	return h, nil
+}
+
+// params returns the parameters described by the header.
+func (h *header) params() *Parameters {
+	return &Parameters{
+		LC:           h.properties.LC,
+		LP:           h.properties.LP,
+		PB:           h.properties.PB,
+		DictSize:     h.dictCap,
+		Size:         h.size,
+		SizeInHeader: h.size >= 0,
+		EOS:          h.size < 0,
+	}
+}
+
+// EncodeHeader returns the header of the classic LZMA format for the
+// parameters p. The header has HeaderLen bytes. The field EOS is not
+// represented in the header. The size is only stored if SizeInHeader is
+// set.
+func EncodeHeader(p *Parameters) ([]byte, error) {
+	if p == nil {
+		return nil, errors.New("lzma: parameters are nil")
+	}
+	h, err := paramsHeader(p)
+	if err != nil {
+		return nil, err
+	}
+	return h.marshalBinary()
+}
+
+// DecodeHeader decodes the header of the classic LZMA format. The data
+// must have HeaderLen bytes. EOS is set if the header contains no
+// size.
+func DecodeHeader(data []byte) (*Parameters, error) {
+	var h header
+	if err := h.unmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h.params(), nil
+}
+
 // validDictCap checks whether the dictionary capacity is correct. This
 // is used to weed out wrong file headers.
 func validDictCap(dictcap int) bool {
@@ -165,3 +229,26 @@ func ValidHeader(data []byte) bool {
 	}
 	return h.size < 0 || h.size <= 1<<38
 }
+
+// PeekDictSize reads the header of an LZMA stream in the classic format
+// from r and returns the dictionary size stored in it. The returned
+// reader provides the complete stream including the header bytes
+// consumed by the function, so it can be used to create the Reader for
+// the stream. The returned reader is valid even if an error is
+// returned.
+func PeekDictSize(r io.Reader) (dictSize int64, pr io.Reader, err error) {
+	data := make([]byte, HeaderLen)
+	n, err := io.ReadFull(r, data)
+	pr = io.MultiReader(bytes.NewReader(data[:n]), r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, pr, err
+	}
+	var h header
+	if err = h.unmarshalBinary(data); err != nil {
+		return 0, pr, err
+	}
+	return int64(h.dictCap), pr, nil
+}
@@ -73,7 +73,8 @@ const (
 
 // errHeaderByte indicates an unsupported value for the chunk header
 // byte. These bytes starts the variable-length chunk header.
-var errHeaderByte = errors.New("lzma: unsupported chunk header byte")
+var errHeaderByte error = corruptError(
+	"lzma: unsupported chunk header byte")
 
 // headerChunkType converts the header byte into a chunk type. It
 // ignores the uncompressed size bits in the chunk header byte.
@@ -267,10 +268,21 @@ const (
 	stop  chunkState = 'T'
 )
 
+// startState returns the initial chunk state. With a preset dictionary
+// the first chunk must not reset the dictionary, but it still has to
+// reset the state and set the properties. That is the requirement of
+// state 'R'.
+func startState(presetDict bool) chunkState {
+	if presetDict {
+		return 'R'
+	}
+	return start
+}
+
 // errors for the chunk state handling
 var (
-	errChunkType = errors.New("lzma: unexpected chunk type")
-	errState     = errors.New("lzma: wrong chunk state")
+	errChunkType error = corruptError("lzma: unexpected chunk type")
+	errState     error = corruptError("lzma: wrong chunk state")
 )
 
 // next transitions state based on chunk type input
@@ -396,3 +408,70 @@ func EncodeDictCap(n int64) byte {
 	}
 	return a
 }
+
+// DecodeDictSizeByte decodes the dictionary size byte of the LZMA2
+// filter properties in an xz block header. The byte b encodes the size
+// (2 | b&1) << (b/2 + 11) for b < 40 and 2^32-1 for b == 40. The
+// function returns -1 for the invalid values above 40.
+func DecodeDictSizeByte(b byte) int64 {
+	n, err := DecodeDictCap(b)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// EncodeDictSizeByte encodes the dictionary size as byte of the LZMA2
+// filter properties. A size that cannot be represented exactly is
+// rounded up to the next value supported by the encoding. Negative
+// sizes and sizes exceeding 2^32-1 cannot be encoded and return an
+// error.
+func EncodeDictSizeByte(size int64) (byte, error) {
+	if !(0 <= size && size <= maxDictCap) {
+		return 0, errors.New("lzma: dictionary size out of range")
+	}
+	return EncodeDictCap(size), nil
+}
+
+// FilterProps returns the property bytes of the LZMA2 filter in an xz
+// block header for the parameters p. The properties consist of a
+// single byte encoding the dictionary size, which is rounded up to the
+// next value supported by the encoding. The literal and position bits
+// are stored in the LZMA2 chunks and not in the filter properties;
+// they are only checked.
+func FilterProps(p *Parameters) ([]byte, error) {
+	if p == nil {
+		return nil, errors.New("lzma: parameters are nil")
+	}
+	props := Properties{LC: p.LC, LP: p.LP, PB: p.PB}
+	if err := props.verify(); err != nil {
+		return nil, err
+	}
+	c, err := EncodeDictSizeByte(int64(p.DictSize))
+	if err != nil {
+		return nil, err
+	}
+	return []byte{c}, nil
+}
+
+// ParseFilterProps decodes the property bytes of the LZMA2 filter in an
+// xz block header. Only the dictionary size is provided by the
+// properties. LC, LP and PB are set to zero, since they are given by
+// the LZMA2 chunks. LZMA2 streams have no size and are terminated by an
+// end-of-stream chunk. An error is returned if the dictionary size
+// doesn't fit into an int on the platform.
+func ParseFilterProps(b []byte) (*Parameters, error) {
+	if len(b) != 1 {
+		return nil, errors.New(
+			"lzma: LZMA2 filter properties must have length 1")
+	}
+	n := DecodeDictSizeByte(b[0])
+	if n < 0 {
+		return nil, errors.New("lzma: invalid dictionary size code")
+	}
+	if n > maxInt {
+		return nil, errors.New(
+			"lzma: dictionary size too large for the platform")
+	}
+	return &Parameters{DictSize: int(n), Size: -1, EOS: true}, nil
+}
@@ -151,3 +151,159 @@ func TestReadEOS(t *testing.T) {
 		t.Errorf("props got %v; want %v", h.props, wantProps)
 	}
 }
+
+// specDictCaps lists dictionary size bytes and their values from the
+// table in section 5.3.1 of the xz file format specification.
+var specDictCaps = []struct {
+	code byte
+	n    int64
+}{
+	{0, 4 << 10},
+	{1, 6 << 10},
+	{2, 8 << 10},
+	{3, 12 << 10},
+	{4, 16 << 10},
+	{5, 24 << 10},
+	{18, 2 << 20},
+	{19, 3 << 20},
+	{22, 8 << 20},
+	{37, 1536 << 20},
+	{38, 2 << 30},
+	{39, 3 << 30},
+	{40, 4<<30 - 1},
+}
+
+func TestDecodeDictCap(t *testing.T) {
+	for _, tc := range specDictCaps {
+		n, err := DecodeDictCap(tc.code)
+		if err != nil {
+			t.Fatalf("DecodeDictCap(%d) error %s", tc.code, err)
+		}
+		if n != tc.n {
+			t.Errorf("DecodeDictCap(%d) returned %d; want %d",
+				tc.code, n, tc.n)
+		}
+		if c := EncodeDictCap(tc.n); c != tc.code {
+			t.Errorf("EncodeDictCap(%d) returned %d; want %d",
+				tc.n, c, tc.code)
+		}
+	}
+	if _, err := DecodeDictCap(41); err == nil {
+		t.Errorf("DecodeDictCap(41) returned no error")
+	}
+}
+
+func TestEncodeDictCapRoundUp(t *testing.T) {
+	tests := []struct {
+		n    int64
+		code byte
+	}{
+		{1, 0},
+		{4<<10 + 1, 1},
+		{7 << 10, 2},
+		{8<<20 - 1, 22},
+		{3<<30 + 1, 40},
+		{1 << 40, 40},
+	}
+	for _, tc := range tests {
+		if c := EncodeDictCap(tc.n); c != tc.code {
+			t.Errorf("EncodeDictCap(%d) returned %d; want %d",
+				tc.n, c, tc.code)
+		}
+	}
+}
+
+func TestDictSizeByte(t *testing.T) {
+	for _, tc := range specDictCaps {
+		if n := DecodeDictSizeByte(tc.code); n != tc.n {
+			t.Errorf("DecodeDictSizeByte(%d) returned %d; want %d",
+				tc.code, n, tc.n)
+		}
+		c, err := EncodeDictSizeByte(tc.n)
+		if err != nil {
+			t.Fatalf("EncodeDictSizeByte(%d) error %s", tc.n, err)
+		}
+		if c != tc.code {
+			t.Errorf("EncodeDictSizeByte(%d) returned %d; want %d",
+				tc.n, c, tc.code)
+		}
+	}
+	for _, b := range []byte{41, 64, 255} {
+		if n := DecodeDictSizeByte(b); n != -1 {
+			t.Errorf("DecodeDictSizeByte(%d) returned %d; want -1",
+				b, n)
+		}
+	}
+	if c, err := EncodeDictSizeByte(3<<30 + 1); err != nil || c != 40 {
+		t.Errorf("EncodeDictSizeByte(3<<30 + 1) returned %d, %v;"+
+			" want 40, nil", c, err)
+	}
+	for _, n := range []int64{-1, 4 << 30, 1 << 40} {
+		if _, err := EncodeDictSizeByte(n); err == nil {
+			t.Errorf("EncodeDictSizeByte(%d) returned no error", n)
+		}
+	}
+}
+
+func TestFilterProps(t *testing.T) {
+	// Values taken from the description of the LZMA2 filter flags
+	// in the xz file format specification.
+	tests := []struct {
+		dictSize int64
+		props    byte
+	}{
+		{4 << 10, 0x00},
+		{6 << 10, 0x01},
+		{8 << 10, 0x02},
+		{1 << 20, 0x10},
+		{3 << 19, 0x11},
+		{8 << 20, 0x16},
+		{64 << 20, 0x1c},
+		{3 << 30, 0x27},
+		{1<<32 - 1, 0x28},
+	}
+	for _, tc := range tests {
+		if tc.dictSize > maxInt {
+			// 32-bit platforms can't represent the size.
+			_, err := ParseFilterProps([]byte{tc.props})
+			if err == nil {
+				t.Fatalf("ParseFilterProps(%#02x) accepted"+
+					" size %d", tc.props, tc.dictSize)
+			}
+			continue
+		}
+		b, err := FilterProps(&Parameters{
+			LC: 3, PB: 2, DictSize: int(tc.dictSize)})
+		if err != nil {
+			t.Fatalf("FilterProps error %s", err)
+		}
+		if len(b) != 1 || b[0] != tc.props {
+			t.Fatalf("FilterProps for %d returned %#v; want %#02x",
+				tc.dictSize, b, tc.props)
+		}
+		p, err := ParseFilterProps(b)
+		if err != nil {
+			t.Fatalf("ParseFilterProps error %s", err)
+		}
+		if int64(p.DictSize) != tc.dictSize {
+			t.Fatalf("ParseFilterProps(%#v) returned size %d;"+
+				" want %d", b, p.DictSize, tc.dictSize)
+		}
+	}
+
+	b, err := FilterProps(&Parameters{DictSize: 5 << 10})
+	if err != nil {
+		t.Fatalf("FilterProps error %s", err)
+	}
+	if b[0] != 0x01 {
+		t.Fatalf("FilterProps(5 KiB) returned %#02x; want 0x01", b[0])
+	}
+	if _, err = FilterProps(&Parameters{LC: 9}); err == nil {
+		t.Fatalf("FilterProps accepted LC 9")
+	}
+	for _, b := range [][]byte{nil, {0x29}, {0x00, 0x00}} {
+		if _, err = ParseFilterProps(b); err == nil {
+			t.Fatalf("ParseFilterProps(%#v) returned no error", b)
+		}
+	}
+}
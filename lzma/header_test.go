@@ -4,7 +4,12 @@
 
 package lzma
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
 
 func TestHeaderMarshalling(t *testing.T) {
 	tests := []header{
@@ -12,6 +17,8 @@ func TestHeaderMarshalling(t *testing.T) {
 			size: -1},
 		{properties: Properties{4, 3, 3}, dictCap: 4096,
 			size: 10},
+		{properties: Properties{3, 0, 2}, dictCap: 4096,
+			size: 0},
 	}
 	for _, h := range tests {
 		data, err := h.marshalBinary()
@@ -50,3 +57,127 @@ func TestValidHeader(t *testing.T) {
 		t.Errorf("ValidHeader returns true for %s; want false", a)
 	}
 }
+
+func TestPeekDictSize(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	for _, dictCap := range []int{MinDictCap, 1 << 20, 3 << 23} {
+		stream := compressWithConfig(t, WriterConfig{DictCap: dictCap},
+			[]byte(text))
+		n, r, err := PeekDictSize(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("PeekDictSize error %s", err)
+		}
+		if n != int64(dictCap) {
+			t.Fatalf("PeekDictSize returned %d; want %d", n, dictCap)
+		}
+		lr, err := NewReader(r)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(lr)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(got) != text {
+			t.Fatalf("got %q; want %q", got, text)
+		}
+	}
+
+	// A truncated header returns the consumed bytes.
+	_, r, err := PeekDictSize(bytes.NewReader([]byte{0x5d, 0, 0}))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("PeekDictSize returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, []byte{0x5d, 0, 0}) {
+		t.Fatalf("pushback reader returned %#v", p)
+	}
+}
+
+func TestEncodeDecodeHeader(t *testing.T) {
+	check := func(p *Parameters) {
+		data, err := EncodeHeader(p)
+		if err != nil {
+			t.Fatalf("EncodeHeader(%+v) error %s", p, err)
+		}
+		if len(data) != HeaderLen {
+			t.Fatalf("header has %d bytes; want %d", len(data),
+				HeaderLen)
+		}
+		q, err := DecodeHeader(data)
+		if err != nil {
+			t.Fatalf("DecodeHeader error %s", err)
+		}
+		if *q != *p {
+			t.Fatalf("got %+v; want %+v", q, p)
+		}
+	}
+	dictSizes := []int{0, MinDictCap, 1<<20 + 1<<19}
+	if d := int64(MaxDictCap); d <= maxInt {
+		dictSizes = append(dictSizes, int(d))
+	}
+	sizes := []int64{-1, 0, 1 << 40}
+	n := 0
+	for lc := minLC; lc <= maxLC; lc++ {
+		for lp := minLP; lp <= maxLP; lp++ {
+			for pb := minPB; pb <= maxPB; pb++ {
+				for _, dictSize := range dictSizes {
+					for _, size := range sizes {
+						check(&Parameters{
+							LC:           lc,
+							LP:           lp,
+							PB:           pb,
+							DictSize:     dictSize,
+							Size:         size,
+							SizeInHeader: size >= 0,
+							EOS:          size < 0,
+						})
+						n++
+					}
+				}
+			}
+		}
+	}
+	t.Logf("%d parameter combinations tested", n)
+
+	// The header must match the header written by the Writer.
+	stream := compressWithConfig(t, WriterConfig{
+		Properties: &Properties{LC: 1, LP: 2, PB: 3},
+		DictCap:    1 << 16,
+		Size:       int64(len(testString)),
+	}, []byte(testString))
+	data, err := EncodeHeader(&Parameters{
+		LC: 1, LP: 2, PB: 3,
+		DictSize:     1 << 16,
+		Size:         int64(len(testString)),
+		SizeInHeader: true,
+	})
+	if err != nil {
+		t.Fatalf("EncodeHeader error %s", err)
+	}
+	if !bytes.Equal(data, stream[:HeaderLen]) {
+		t.Fatalf("EncodeHeader returned % x; writer header % x",
+			data, stream[:HeaderLen])
+	}
+
+	invalid := []Parameters{
+		{LC: 9, DictSize: MinDictCap},
+		{LP: 5, DictSize: MinDictCap},
+		{PB: 5, DictSize: MinDictCap},
+		{DictSize: -1},
+		{DictSize: MinDictCap, Size: -2, SizeInHeader: true},
+	}
+	for _, p := range invalid {
+		p := p
+		if _, err := EncodeHeader(&p); err == nil {
+			t.Errorf("EncodeHeader(%+v) returned no error", p)
+		}
+	}
+	if _, err := DecodeHeader(data[:HeaderLen-1]); err == nil {
+		t.Errorf("DecodeHeader of short data returned no error")
+	}
+}
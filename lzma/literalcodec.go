@@ -76,40 +76,56 @@ func (c *literalCodec) Encode(e *rangeEncoder, s byte,
 
 // Decode decodes a literal byte using the range decoder as well as the LZMA
 // state, a match byte, and the literal state.
+//
+// The function dominates the decoding time of literal-heavy streams,
+// so the bit decoding of the range decoder is inlined with range and
+// code kept in local variables. Unlike rangeDecoder.DecodeBit it
+// branches on the decoded bit, because the high bits of text literals
+// are well predictable. The variable offs is 0x100 as long as the
+// decoded bits equal the bits of the match byte and zero afterwards,
+// so literals with and without match byte share the same loop.
 func (c *literalCodec) Decode(d *rangeDecoder,
 	state uint32, match byte, litState uint32,
 ) (s byte, err error) {
 	k := litState * 0x300
 	probs := c.probs[k : k+0x300]
+	nrange, code := d.nrange, d.code
 	symbol := uint32(1)
+	var offs uint32
 	if state >= 7 {
-		m := uint32(match)
-		for {
-			matchBit := (m >> 7) & 1
-			m <<= 1
-			i := ((1 + matchBit) << 8) | symbol
-			bit, err := d.DecodeBit(&probs[i])
+		offs = 0x100
+	}
+	m := uint32(match)
+	for symbol < 0x100 {
+		m <<= 1
+		bit := offs
+		offs &= m
+		p := &probs[offs+bit+symbol]
+		q := uint32(*p)
+		bound := (nrange >> probbits) * q
+		if code < bound {
+			nrange = bound
+			*p = prob(q + ((1<<probbits)-q)>>movebits)
+			symbol <<= 1
+			offs ^= bit
+		} else {
+			code -= bound
+			nrange -= bound
+			*p = prob(q - q>>movebits)
+			symbol = symbol<<1 | 1
+		}
+		if nrange < 1<<24 {
+			nrange <<= 8
+			x, err := d.br.ReadByte()
 			if err != nil {
+				d.nrange, d.code = nrange, code
 				return 0, err
 			}
-			symbol = (symbol << 1) | bit
-			if matchBit != bit {
-				break
-			}
-			if symbol >= 0x100 {
-				break
-			}
+			code = code<<8 | uint32(x)
 		}
 	}
-	for symbol < 0x100 {
-		bit, err := d.DecodeBit(&probs[symbol])
-		if err != nil {
-			return 0, err
-		}
-		symbol = (symbol << 1) | bit
-	}
-	s = byte(symbol - 0x100)
-	return s, nil
+	d.nrange, d.code = nrange, code
+	return byte(symbol), nil
 }
 
 // minLC and maxLC define the range for LC values.
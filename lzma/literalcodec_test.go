@@ -0,0 +1,120 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// decodeLiteralBits decodes a literal using rangeDecoder.DecodeBit for
+// every bit. It is the straightforward implementation of
+// literalCodec.Decode.
+func decodeLiteralBits(c *literalCodec, d *rangeDecoder,
+	state uint32, match byte, litState uint32) (s byte, err error) {
+	k := litState * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	if state >= 7 {
+		m := uint32(match)
+		for symbol < 0x100 {
+			matchBit := (m >> 7) & 1
+			m <<= 1
+			i := ((1 + matchBit) << 8) | symbol
+			bit, err := d.DecodeBit(&probs[i])
+			if err != nil {
+				return 0, err
+			}
+			symbol = (symbol << 1) | bit
+			if matchBit != bit {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		bit, err := d.DecodeBit(&probs[symbol])
+		if err != nil {
+			return 0, err
+		}
+		symbol = (symbol << 1) | bit
+	}
+	return byte(symbol), nil
+}
+
+func TestLiteralCodecDecode(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(11)), 1<<16))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	rnd := rand.New(rand.NewSource(12))
+	opStates := make([]uint32, len(txt))
+	matches := make([]byte, len(txt))
+	for i := range txt {
+		opStates[i] = uint32(rnd.Intn(states))
+		// the match byte equals the literal at times, so the
+		// match mode continues over several bits
+		matches[i] = txt[rnd.Intn(len(txt))]
+		if rnd.Intn(3) == 0 {
+			matches[i] = txt[i] ^ byte(1<<uint(rnd.Intn(8)))
+		}
+	}
+	var buf bytes.Buffer
+	e, err := newRangeEncoder(&buf)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	var ec literalCodec
+	ec.init(3, 1)
+	for i, s := range txt {
+		err = ec.Encode(e, s, opStates[i], matches[i], uint32(i&0xf))
+		if err != nil {
+			t.Fatalf("Encode error %s", err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	z := buf.Bytes()
+
+	decode := func(f func(c *literalCodec, d *rangeDecoder, state uint32,
+		match byte, litState uint32) (byte, error)) (
+		[]byte, *literalCodec, *rangeDecoder) {
+		d, err := newRangeDecoder(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("newRangeDecoder error %s", err)
+		}
+		c := new(literalCodec)
+		c.init(3, 1)
+		p := make([]byte, len(txt))
+		for i := range p {
+			p[i], err = f(c, d, opStates[i], matches[i],
+				uint32(i&0xf))
+			if err != nil {
+				t.Fatalf("decode error %s", err)
+			}
+		}
+		return p, c, d
+	}
+	p, c, d := decode((*literalCodec).Decode)
+	q, cq, dq := decode(decodeLiteralBits)
+	if !bytes.Equal(p, txt) || !bytes.Equal(q, txt) {
+		t.Fatalf("decoded literals differ")
+	}
+	for i := range c.probs {
+		if c.probs[i] != cq.probs[i] {
+			t.Fatalf("probability %d is %d; want %d", i,
+				c.probs[i], cq.probs[i])
+		}
+	}
+	if d.nrange != dq.nrange || d.code != dq.code {
+		t.Fatalf("range decoder state differs")
+	}
+}
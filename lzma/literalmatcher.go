@@ -0,0 +1,29 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// literalMatcher implements the matcher interface without searching
+// for matches. Every byte is returned as a literal.
+type literalMatcher struct {
+	dict *encoderDict
+}
+
+// SetDict sets the dictionary of the matcher.
+func (m *literalMatcher) SetDict(d *encoderDict) { m.dict = d }
+
+// Reset does nothing, because the matcher has no state.
+func (m *literalMatcher) Reset() {}
+
+// Write ignores the data, because no index needs to be maintained.
+func (m *literalMatcher) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+// NextOp returns the next byte in the dictionary buffer as a literal.
+func (m *literalMatcher) NextOp(rep [4]uint32) operation {
+	var p [1]byte
+	m.dict.buf.Peek(p[:])
+	return lit{p[0]}
+}
@@ -0,0 +1,35 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLiteralsOnly(t *testing.T) {
+	data := []byte(strings.Repeat(testString, 4))
+	c := WriterConfig{Matcher: LiteralsOnly}
+	stream := compressWithConfig(t, c, data)
+	t.Logf("%d bytes compressed to %d bytes with literals only",
+		len(data), len(stream))
+
+	ops, out := decodeOps(t, stream)
+	for _, op := range ops {
+		if _, ok := op.(lit); !ok {
+			t.Fatalf("operation %v is not a literal", op)
+		}
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs")
+	}
+
+	normal := compressWithConfig(t, WriterConfig{}, data)
+	if len(normal) >= len(stream) {
+		t.Errorf("matches don't reduce the size: %d >= %d",
+			len(normal), len(stream))
+	}
+}
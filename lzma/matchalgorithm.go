@@ -10,16 +10,22 @@ import "errors"
 // dictionary.
 type MatchAlgorithm byte
 
-// Supported matcher algorithms.
+// Supported matcher algorithms. LiteralsOnly doesn't search for
+// matches at all and encodes all data as literals. The resulting
+// stream is valid but larger; it can be used to measure the overhead
+// of the range coder or if matching has already been done by an
+// earlier stage.
 const (
 	HashTable4 MatchAlgorithm = iota
 	BinaryTree
+	LiteralsOnly
 )
 
 // maStrings are used by the String method.
 var maStrings = map[MatchAlgorithm]string{
-	HashTable4: "HashTable4",
-	BinaryTree: "BinaryTree",
+	HashTable4:   "HashTable4",
+	BinaryTree:   "BinaryTree",
+	LiteralsOnly: "LiteralsOnly",
 }
 
 // String returns a string representation of the Matcher.
@@ -47,6 +53,24 @@ func (a MatchAlgorithm) new(dictCap int) (m matcher, err error) {
 		return newHashTable(dictCap, 4)
 	case BinaryTree:
 		return newBinTree(dictCap)
+	case LiteralsOnly:
+		return &literalMatcher{}, nil
 	}
 	return nil, errUnsupportedMatchAlgorithm
 }
+
+// Parameters of the greedy search of the hash table matcher.
+const (
+	greedyCandidates = 4
+	greedyNiceLen    = 32
+)
+
+// setGreedy switches the matcher m to the greedy search selected by the
+// GreedyMatch option. Only the hash table supports it; the other
+// matchers are not changed.
+func setGreedy(m matcher) {
+	if t, ok := m.(*hashTable); ok {
+		t.candidates = greedyCandidates
+		t.niceLen = greedyNiceLen
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// WriterOption sets the size and end-of-stream behavior of a Writer
+// created by NewWriter. The options keep the fields SizeInHeader, Size
+// and EOSMarker of the writer configuration consistent and report
+// contradicting options as error.
+type WriterOption func(o *writerOptions) error
+
+// writerOptions collects the configuration set by the writer options.
+type writerOptions struct {
+	c WriterConfig
+	// set by WithKnownSize or WithUnknownSize
+	sizeSet bool
+}
+
+// errSizeOptions reports contradicting size options.
+var errSizeOptions = errors.New("lzma: contradicting size options")
+
+// WithKnownSize declares that exactly n bytes will be written. The size
+// is stored in the header and Close fails if a different number of
+// bytes has been written. The EOS marker is only written if
+// WithEOSMarker is given as well. A size of zero describes empty data.
+func WithKnownSize(n int64) WriterOption {
+	return func(o *writerOptions) error {
+		if n < 0 {
+			return errors.New("lzma: negative size")
+		}
+		if o.sizeSet && !(o.c.SizeInHeader && o.c.Size == n) {
+			return errSizeOptions
+		}
+		o.sizeSet = true
+		o.c.SizeInHeader = true
+		o.c.Size = n
+		return nil
+	}
+}
+
+// WithUnknownSize declares that the size of the data is unknown. The
+// header stores the value for an unknown size and the EOS marker is
+// written, which is also the behavior without any size option.
+func WithUnknownSize() WriterOption {
+	return func(o *writerOptions) error {
+		if o.sizeSet && o.c.SizeInHeader {
+			return errSizeOptions
+		}
+		o.sizeSet = true
+		o.c.SizeInHeader = false
+		o.c.Size = 0
+		o.c.EOSMarker = true
+		return nil
+	}
+}
+
+// WithEOSMarker requests the EOS marker at the end of the stream. It is
+// always written for data of unknown size; with WithKnownSize the
+// marker follows the data, which is accepted by all decoders.
+func WithEOSMarker() WriterOption {
+	return func(o *writerOptions) error {
+		o.c.EOSMarker = true
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriterOptions(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog.")
+	n := int64(len(data))
+	tests := []struct {
+		name string
+		opts []WriterOption
+		size int64
+		eos  bool
+	}{
+		{"none", nil, -1, true},
+		{"known", []WriterOption{WithKnownSize(n)}, n, false},
+		{"knownEOS", []WriterOption{WithKnownSize(n),
+			WithEOSMarker()}, n, true},
+		{"unknown", []WriterOption{WithUnknownSize()}, -1, true},
+		{"unknownEOS", []WriterOption{WithEOSMarker(),
+			WithUnknownSize()}, -1, true},
+		{"knownTwice", []WriterOption{WithKnownSize(n),
+			WithKnownSize(n)}, n, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tc.opts...)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(p, data) {
+				t.Fatalf("decoded %q; want %q", p, data)
+			}
+			if r.h.size != tc.size {
+				t.Fatalf("header size %d; want %d", r.h.size,
+					tc.size)
+			}
+			if r.Reason() == EndByEOS != tc.eos {
+				t.Fatalf("end reason %v; want EOS marker %t",
+					r.Reason(), tc.eos)
+			}
+		})
+	}
+}
+
+func TestWriterOptionsEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WithKnownSize(0))
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if buf.Len() != HeaderLen+5 {
+		t.Fatalf("stream has %d bytes; want %d", buf.Len(),
+			HeaderLen+5)
+	}
+	w, err = NewWriter(ioutil.Discard, WithKnownSize(1))
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if err = w.Close(); err == nil {
+		t.Fatalf("Close accepted missing data of known size")
+	}
+}
+
+func TestWriterOptionsContradiction(t *testing.T) {
+	tests := [][]WriterOption{
+		{WithKnownSize(10), WithUnknownSize()},
+		{WithUnknownSize(), WithKnownSize(10)},
+		{WithKnownSize(10), WithKnownSize(11)},
+		{WithKnownSize(-1)},
+	}
+	for i, opts := range tests {
+		if _, err := NewWriter(ioutil.Discard, opts...); err == nil {
+			t.Errorf("test %d: NewWriter accepted the options", i)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"fmt"
+	"io"
+)
+
+// The functions write the lines of the operation traces described for
+// WriterConfig.OpTrace. The repeated distances are updated as by the
+// LZMA coder: MATCH puts its distance in front of the slots and REP
+// moves the distance of the slot used to the front.
+
+// traceLit writes the trace line for a literal.
+func traceLit(w io.Writer, b byte) error {
+	_, err := fmt.Fprintf(w, "LIT %02x\n", b)
+	return err
+}
+
+// traceMatch writes the trace line for a match with a new distance.
+func traceMatch(w io.Writer, n int, dist int64) error {
+	_, err := fmt.Fprintf(w, "MATCH %d %d\n", n, dist)
+	return err
+}
+
+// traceRep writes the trace line for a match with the repeated
+// distance in slot g.
+func traceRep(w io.Writer, g int, n int) error {
+	_, err := fmt.Fprintf(w, "REP %d %d\n", g, n)
+	return err
+}
+
+// traceEOS writes the trace line for the end-of-stream marker.
+func traceEOS(w io.Writer) error {
+	_, err := io.WriteString(w, "EOS\n")
+	return err
+}
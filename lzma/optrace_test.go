@@ -0,0 +1,177 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// replayTrace reconstructs the data from an operation trace. It
+// reports whether the trace ends with an EOS line.
+func replayTrace(trace string) (data []byte, eos bool, err error) {
+	var rep [4]int64
+	for i := range rep {
+		rep[i] = 1
+	}
+	copyMatch := func(n int, dist int64) error {
+		if dist > int64(len(data)) {
+			return fmt.Errorf("distance %d out of range", dist)
+		}
+		for ; n > 0; n-- {
+			data = append(data, data[int64(len(data))-dist])
+		}
+		return nil
+	}
+	s := bufio.NewScanner(strings.NewReader(trace))
+	for s.Scan() {
+		if eos {
+			return nil, false, fmt.Errorf("line after EOS")
+		}
+		line := s.Text()
+		var (
+			b    byte
+			n, g int
+			dist int64
+		)
+		switch {
+		case strings.HasPrefix(line, "LIT "):
+			if _, err = fmt.Sscanf(line, "LIT %x", &b); err != nil {
+				return nil, false, err
+			}
+			data = append(data, b)
+		case strings.HasPrefix(line, "MATCH "):
+			_, err = fmt.Sscanf(line, "MATCH %d %d", &n, &dist)
+			if err != nil {
+				return nil, false, err
+			}
+			rep[3], rep[2], rep[1], rep[0] =
+				rep[2], rep[1], rep[0], dist
+			if err = copyMatch(n, dist); err != nil {
+				return nil, false, err
+			}
+		case strings.HasPrefix(line, "REP "):
+			if _, err = fmt.Sscanf(line, "REP %d %d", &g, &n); err != nil {
+				return nil, false, err
+			}
+			dist = rep[g]
+			copy(rep[1:g+1], rep[:g])
+			rep[0] = dist
+			if err = copyMatch(n, dist); err != nil {
+				return nil, false, err
+			}
+		case line == "EOS":
+			eos = true
+		default:
+			return nil, false, fmt.Errorf("unexpected line %q", line)
+		}
+	}
+	return data, eos, s.Err()
+}
+
+func TestWriterOpTrace(t *testing.T) {
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(197)), 1<<16))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	for _, optimal := range []bool{false, true} {
+		var trace bytes.Buffer
+		compressWithConfig(t, WriterConfig{
+			OptimalParse: optimal,
+			OpTrace:      &trace,
+		}, data)
+		got, eos, err := replayTrace(trace.String())
+		if err != nil {
+			t.Fatalf("optimal %t: replayTrace error %s", optimal,
+				err)
+		}
+		if !eos {
+			t.Fatalf("optimal %t: trace without EOS", optimal)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("optimal %t: trace doesn't reconstruct"+
+				" the data", optimal)
+		}
+		if !strings.Contains(trace.String(), "REP ") {
+			t.Fatalf("optimal %t: trace contains no REP",
+				optimal)
+		}
+	}
+}
+
+func TestReaderOpTrace(t *testing.T) {
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(198)), 1<<16))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	for _, c := range []WriterConfig{
+		{},
+		{OptimalParse: true},
+		{Size: int64(len(data))},
+	} {
+		var wtrace, rtrace bytes.Buffer
+		c.OpTrace = &wtrace
+		// The small dictionaries prevent the resumed reader below
+		// from decoding the whole stream before the state is saved.
+		c.DictCap = MinDictCap
+		z := compressWithConfig(t, c, data)
+		r, err := ReaderConfig{OpTrace: &rtrace}.NewReader(
+			bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("decompressed data differs")
+		}
+		if wtrace.Len() == 0 {
+			t.Fatalf("empty writer trace")
+		}
+		if !bytes.Equal(rtrace.Bytes(), wtrace.Bytes()) {
+			t.Fatalf("reader trace differs from writer trace")
+		}
+
+		// A reader resumed from a saved state continues the
+		// trace.
+		rtrace.Reset()
+		rc := ReaderConfig{DictCap: MinDictCap, OpTrace: &rtrace}
+		r, err = rc.NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		if _, err = io.ReadFull(r, got[:len(got)/3]); err != nil {
+			t.Fatalf("ReadFull error %s", err)
+		}
+		state, err := r.SaveState()
+		if err != nil {
+			t.Fatalf("SaveState error %s", err)
+		}
+		off := r.InputOffset()
+		r, err = rc.NewReaderState(bytes.NewReader(z[off:]), state)
+		if err != nil {
+			t.Fatalf("NewReaderState error %s", err)
+		}
+		if _, err = ioutil.ReadAll(r); err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(rtrace.Bytes(), wtrace.Bytes()) {
+			t.Fatalf("trace of resumed reader differs from" +
+				" writer trace")
+		}
+	}
+}
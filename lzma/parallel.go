@@ -0,0 +1,196 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ulikunitz/xz/internal/ordered"
+)
+
+// BlockOffset describes a single block written by ParallelCompress.
+// Each block is an independent LZMA stream in the classic format.
+type BlockOffset struct {
+	// offset and size of the compressed stream in the output
+	Offset int64
+	Size   int64
+	// offset and size of the uncompressed data in the input
+	UncompressedOffset int64
+	UncompressedSize   int64
+}
+
+// ParallelCompress splits the input from src into blocks of blockSize
+// bytes and compresses each block as an independent LZMA stream in the
+// classic format. The blocks are compressed by the given number of
+// go routines and written to w in the order of the input. The function
+// returns the table of the block offsets, which allows the blocks to
+// be decoded separately.
+//
+// The configuration c may be nil, in which case default values are
+// used. The size of each block is written into its header. The RawSink
+// field of the configuration is ignored.
+func ParallelCompress(src io.Reader, w io.Writer, blockSize int64,
+	workers int, c *WriterConfig) (offsets []BlockOffset, err error) {
+
+	if blockSize <= 0 {
+		return nil, errors.New("lzma: block size must be positive")
+	}
+	if workers <= 0 {
+		return nil, errors.New("lzma: number of workers must be positive")
+	}
+	var cfg WriterConfig
+	if c != nil {
+		cfg = *c
+	}
+	cfg.RawSink = nil
+	cfg.Size = 0
+	cfg.SizeInHeader = true
+	if err = cfg.Verify(); err != nil {
+		return nil, err
+	}
+
+	var (
+		off, uoff int64
+		eof       bool
+	)
+	blocks := make([][]byte, workers)
+	out := make([]bytes.Buffer, workers)
+	errs := make([]error, workers)
+	for !eof {
+		// read up to workers blocks
+		n := 0
+		for ; n < workers; n++ {
+			var buf bytes.Buffer
+			k, err := io.CopyN(&buf, src, blockSize)
+			if err != nil && err != io.EOF {
+				return offsets, err
+			}
+			if k == 0 {
+				eof = true
+				break
+			}
+			blocks[n] = buf.Bytes()
+			if err == io.EOF {
+				eof = true
+				n++
+				break
+			}
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				out[i].Reset()
+				errs[i] = compressBlock(&out[i], cfg, blocks[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < n; i++ {
+			if errs[i] != nil {
+				return offsets, errs[i]
+			}
+			k, err := w.Write(out[i].Bytes())
+			if err != nil {
+				return offsets, err
+			}
+			u := int64(len(blocks[i]))
+			offsets = append(offsets, BlockOffset{
+				Offset:             off,
+				Size:               int64(k),
+				UncompressedOffset: uoff,
+				UncompressedSize:   u,
+			})
+			off += int64(k)
+			uoff += u
+		}
+	}
+	return offsets, nil
+}
+
+// compressBlock compresses the data in p as a single LZMA stream in the
+// classic format.
+func compressBlock(w io.Writer, c WriterConfig, p []byte) error {
+	c.Size = int64(len(p))
+	lw, err := c.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if _, err = lw.Write(p); err != nil {
+		return err
+	}
+	return lw.Close()
+}
+
+// ParallelDecompress decodes the blocks described by offsets, as
+// returned by ParallelCompress, from r and writes the uncompressed data
+// to w. The blocks are decoded by the given number of go routines
+// concurrently, but are written to w strictly in the order of the
+// offsets. Blocks that have been decoded out of order are buffered
+// until all preceding blocks have been written; at most twice the
+// number of workers blocks are held in memory. The function returns
+// the number of bytes written to w.
+//
+// The uncompressed offsets of the blocks must be contiguous. Every
+// block must decode to exactly its UncompressedSize bytes. The
+// configuration c may be nil, in which case default values are used.
+// An Allocator in the configuration must be safe for concurrent use.
+// Files in the xz format are decoded in parallel using their index by
+// the function of the same name in the xz package.
+func ParallelDecompress(r io.ReaderAt, offsets []BlockOffset, w io.Writer,
+	workers int, c *ReaderConfig) (n int64, err error) {
+
+	if workers <= 0 {
+		return 0, errors.New("lzma: number of workers must be positive")
+	}
+	var cfg ReaderConfig
+	if c != nil {
+		cfg = *c
+	}
+	if err = cfg.Verify(); err != nil {
+		return 0, err
+	}
+	var uoff int64
+	for _, o := range offsets {
+		if o.Offset < 0 || o.Size < 0 || o.UncompressedSize < 0 {
+			return 0, errors.New("lzma: negative block offset or size")
+		}
+		if o.UncompressedOffset != uoff {
+			return 0, errors.New(
+				"lzma: uncompressed block offsets are not contiguous")
+		}
+		uoff += o.UncompressedSize
+	}
+	return ordered.Write(w, len(offsets), workers,
+		func(i int) ([]byte, error) {
+			return decompressBlock(r, offsets[i], cfg)
+		})
+}
+
+// decompressBlock decodes the single LZMA stream described by o.
+func decompressBlock(r io.ReaderAt, o BlockOffset, c ReaderConfig) (
+	p []byte, err error) {
+
+	lr, err := c.NewReader(io.NewSectionReader(r, o.Offset, o.Size))
+	if err != nil {
+		return nil, err
+	}
+	defer lr.Close()
+	var buf bytes.Buffer
+	k, err := buf.ReadFrom(io.LimitReader(lr, o.UncompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if k != o.UncompressedSize {
+		return nil, errors.New(
+			"lzma: decoded block size doesn't match block offsets")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestParallelCompress(t *testing.T) {
+	const (
+		size      = 100000
+		blockSize = 16384
+	)
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(3)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	c := &WriterConfig{DictCap: MinDictCap}
+	offsets, err := ParallelCompress(bytes.NewReader(txt), &buf,
+		blockSize, 3, c)
+	if err != nil {
+		t.Fatalf("ParallelCompress error %s", err)
+	}
+	if n := (size + blockSize - 1) / blockSize; len(offsets) != n {
+		t.Fatalf("got %d blocks; want %d", len(offsets), n)
+	}
+	data := buf.Bytes()
+	var off, uoff int64
+	for i, o := range offsets {
+		if o.Offset != off || o.UncompressedOffset != uoff {
+			t.Fatalf("block %d: offsets %+v; want %d and %d",
+				i, o, off, uoff)
+		}
+		r, err := NewReader(bytes.NewReader(
+			data[o.Offset : o.Offset+o.Size]))
+		if err != nil {
+			t.Fatalf("block %d: NewReader error %s", i, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("block %d: ReadAll error %s", i, err)
+		}
+		want := txt[o.UncompressedOffset:][:o.UncompressedSize]
+		if !bytes.Equal(p, want) {
+			t.Fatalf("block %d: decoded data differs", i)
+		}
+		off += o.Size
+		uoff += o.UncompressedSize
+	}
+	if off != int64(len(data)) || uoff != size {
+		t.Fatalf("total sizes %d and %d; want %d and %d",
+			off, uoff, len(data), size)
+	}
+}
+
+func TestParallelDecompress(t *testing.T) {
+	const (
+		size      = 200000
+		blockSize = 8192
+	)
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(5)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	c := &WriterConfig{DictCap: MinDictCap}
+	offsets, err := ParallelCompress(bytes.NewReader(txt), &buf,
+		blockSize, 4, c)
+	if err != nil {
+		t.Fatalf("ParallelCompress error %s", err)
+	}
+	data := buf.Bytes()
+
+	// serial decode for comparison
+	var serial []byte
+	for i, o := range offsets {
+		r, err := NewReader(bytes.NewReader(
+			data[o.Offset : o.Offset+o.Size]))
+		if err != nil {
+			t.Fatalf("block %d: NewReader error %s", i, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("block %d: ReadAll error %s", i, err)
+		}
+		serial = append(serial, p...)
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		var out bytes.Buffer
+		n, err := ParallelDecompress(bytes.NewReader(data), offsets,
+			&out, workers, nil)
+		if err != nil {
+			t.Fatalf("workers %d: ParallelDecompress error %s",
+				workers, err)
+		}
+		if n != size {
+			t.Fatalf("workers %d: got %d bytes; want %d",
+				workers, n, size)
+		}
+		if !bytes.Equal(out.Bytes(), serial) {
+			t.Fatalf("workers %d: output differs from serial decode",
+				workers)
+		}
+		if !bytes.Equal(out.Bytes(), txt) {
+			t.Fatalf("workers %d: output differs from input",
+				workers)
+		}
+	}
+
+	// a wrong uncompressed size must be detected
+	bad := append([]BlockOffset(nil), offsets...)
+	bad[len(bad)-1].UncompressedSize--
+	_, err = ParallelDecompress(bytes.NewReader(data), bad,
+		ioutil.Discard, 3, nil)
+	if err == nil {
+		t.Fatalf("ParallelDecompress with wrong size: no error")
+	}
+	bad = append([]BlockOffset(nil), offsets...)
+	bad[1].UncompressedOffset++
+	if _, err = ParallelDecompress(bytes.NewReader(data), bad,
+		ioutil.Discard, 3, nil); err == nil {
+		t.Fatalf("ParallelDecompress with gap: no error")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParamsLen is the length of the parameter encoding written by
+// WriteParams.
+const ParamsLen = 4
+
+// paramsVersion is the version of the parameter encoding.
+const paramsVersion = 1
+
+// paramsEOS flags a stream terminated by an end-of-stream marker.
+const paramsEOS = 1
+
+// WriteParams writes the parameters required to decode a raw LZMA
+// stream, which has been written without header, in a compact form to
+// w. The encoding consists of ParamsLen bytes: a version byte, the
+// properties code, the dictionary capacity encoded as in LZMA2 and a
+// flags byte. The dictionary size is rounded up to the next value
+// supported by the encoding.
+//
+// The uncompressed size is not stored; a raw stream described this way
+// must be terminated by an EOS marker. So p.EOS must be set or
+// SizeInHeader must be false.
+func WriteParams(w io.Writer, p *Parameters) error {
+	if p == nil {
+		return errors.New("lzma: parameters are nil")
+	}
+	if p.SizeInHeader && !p.EOS {
+		return errors.New(
+			"lzma: parameters without EOS marker not supported")
+	}
+	h, err := paramsHeader(p)
+	if err != nil {
+		return err
+	}
+	data := []byte{
+		paramsVersion,
+		h.properties.Code(),
+		EncodeDictCap(int64(h.dictCap)),
+		paramsEOS,
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadParams reads the parameters written by WriteParams from r. The
+// returned parameters describe a stream without size that is
+// terminated by an EOS marker and can be passed to NewReaderParams.
+func ReadParams(r io.Reader) (*Parameters, error) {
+	data := make([]byte, ParamsLen)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if data[0] != paramsVersion {
+		return nil, fmt.Errorf(
+			"lzma: unsupported parameters version %d", data[0])
+	}
+	props, err := PropertiesForCode(data[1])
+	if err != nil {
+		return nil, err
+	}
+	dictCap, err := DecodeDictCap(data[2])
+	if err != nil {
+		return nil, err
+	}
+	if data[3] != paramsEOS {
+		return nil, errors.New("lzma: invalid parameters flags")
+	}
+	h := header{properties: props, dictCap: int(dictCap), size: -1}
+	return h.params(), nil
+}
+
+// Header5Len is the length of the short header read by ReadHeader5.
+const Header5Len = 5
+
+// ReadHeader5 reads the short header of Header5Len bytes consisting
+// only of the properties byte and the dictionary size, as in the
+// classic 13-byte header, but without the uncompressed size. Such
+// headers are used by the LZMA method of ZIP archives, after a
+// four-byte prefix containing the version and the size of the
+// properties, and for the coder properties stored in 7z archives. The
+// returned parameters describe a stream without size that is
+// terminated by an EOS marker and can be passed to NewReaderParams.
+func ReadHeader5(r io.Reader) (*Parameters, error) {
+	data := make([]byte, HeaderLen)
+	if _, err := io.ReadFull(r, data[:Header5Len]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	putUint64LE(data[Header5Len:], noHeaderSize)
+	var h header
+	if err := h.unmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return h.params(), nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParamsRoundTrip(t *testing.T) {
+	dictSizes := []int{MinDictCap, 1 << 16, 3 << 20, 8 << 20}
+	if d := int64(MaxDictCap); d <= maxInt {
+		dictSizes = append(dictSizes, int(d))
+	}
+	for lc := 0; lc <= maxLC; lc++ {
+		for lp := 0; lp <= maxLP; lp++ {
+			for pb := 0; pb <= maxPB; pb++ {
+				for _, d := range dictSizes {
+					p := &Parameters{LC: lc, LP: lp,
+						PB: pb, DictSize: d, Size: -1,
+						EOS: true}
+					var buf bytes.Buffer
+					if err := WriteParams(&buf, p); err != nil {
+						t.Fatalf("WriteParams(%+v) error %s",
+							p, err)
+					}
+					if buf.Len() != ParamsLen {
+						t.Fatalf("got %d bytes; want %d",
+							buf.Len(), ParamsLen)
+					}
+					q, err := ReadParams(&buf)
+					if err != nil {
+						t.Fatalf("ReadParams error %s", err)
+					}
+					if *q != *p {
+						t.Fatalf("got %+v; want %+v",
+							q, p)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestParamsRawStream(t *testing.T) {
+	data := []byte(testString)
+	c := WriterConfig{
+		Properties: &Properties{LC: 0, LP: 2, PB: 1},
+		DictCap:    100000,
+		NoHeader:   true,
+	}
+	raw := compressWithConfig(t, c, data)
+	p := &Parameters{LC: 0, LP: 2, PB: 1, DictSize: c.DictCap,
+		Size: -1, EOS: true}
+	var buf bytes.Buffer
+	if err := WriteParams(&buf, p); err != nil {
+		t.Fatalf("WriteParams error %s", err)
+	}
+	buf.Write(raw)
+	q, err := ReadParams(&buf)
+	if err != nil {
+		t.Fatalf("ReadParams error %s", err)
+	}
+	if q.DictSize < p.DictSize {
+		t.Fatalf("DictSize %d; want at least %d", q.DictSize,
+			p.DictSize)
+	}
+	r, err := NewReaderParams(&buf, q)
+	if err != nil {
+		t.Fatalf("NewReaderParams error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func TestParamsErrors(t *testing.T) {
+	p := &Parameters{LC: 3, PB: 2, DictSize: 1 << 20, Size: 10,
+		SizeInHeader: true}
+	if err := WriteParams(ioutil.Discard, p); err == nil {
+		t.Fatalf("WriteParams accepted parameters without EOS")
+	}
+	valid := []byte{paramsVersion, Properties{LC: 3, PB: 2}.Code(),
+		EncodeDictCap(1 << 20), paramsEOS}
+	tests := []struct {
+		name string
+		i    int
+		b    byte
+	}{
+		{"version", 0, 2},
+		{"properties", 1, 225},
+		{"dictCap", 2, 41},
+		{"flags", 3, 3},
+	}
+	for _, tc := range tests {
+		data := append([]byte(nil), valid...)
+		data[tc.i] = tc.b
+		if _, err := ReadParams(bytes.NewReader(data)); err == nil {
+			t.Fatalf("%s: ReadParams accepted %x", tc.name, data)
+		}
+	}
+	if _, err := ReadParams(bytes.NewReader(valid[:3])); err == nil {
+		t.Fatalf("ReadParams accepted short data")
+	}
+}
+
+func TestReadHeader5(t *testing.T) {
+	text := []byte(testString)
+	z := compressWithConfig(t, WriterConfig{DictCap: 1 << 16}, text)
+	// remove the size field of the classic header
+	z5 := append(append([]byte{}, z[:Header5Len]...), z[HeaderLen:]...)
+
+	br := bytes.NewReader(z5)
+	p, err := ReadHeader5(br)
+	if err != nil {
+		t.Fatalf("ReadHeader5 error %s", err)
+	}
+	want := Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 16,
+		Size: -1, EOS: true}
+	if *p != want {
+		t.Fatalf("ReadHeader5 returned %+v; want %+v", *p, want)
+	}
+	r, err := NewReaderParams(br, p)
+	if err != nil {
+		t.Fatalf("NewReaderParams error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, text) {
+		t.Fatalf("decompressed data differs")
+	}
+
+	if _, err = ReadHeader5(bytes.NewReader(z5[:3])); err == nil {
+		t.Errorf("ReadHeader5 of truncated header returned no error")
+	}
+	bad := append([]byte{}, z5[:Header5Len]...)
+	bad[0] = 225
+	if _, err = ReadHeader5(bytes.NewReader(bad)); err == nil {
+		t.Errorf("ReadHeader5 accepted invalid properties")
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package lzma
+
+import "os"
+
+// preallocate extends the file f to size bytes.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
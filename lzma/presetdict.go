@@ -0,0 +1,90 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/crc"
+)
+
+/* A preset dictionary is stored in a small container:
+ *
+ *   magic   4 bytes "LZPD"
+ *   version 1 byte
+ *   size    4 bytes little-endian length of the dictionary
+ *   data    size bytes
+ *   crc     4 bytes little-endian CRC-32 (IEEE) of the data
+ */
+
+// presetDictMagic contains the magic bytes of the preset dictionary
+// container.
+var presetDictMagic = []byte{'L', 'Z', 'P', 'D'}
+
+// presetDictVersion is the version of the container format.
+const presetDictVersion = 1
+
+// presetDictHeaderLen is the length of the container header.
+const presetDictHeaderLen = 9
+
+// Errors returned by LoadDict.
+var (
+	errPresetDictMagic   = errors.New("lzma: invalid preset dictionary magic")
+	errPresetDictVersion = errors.New("lzma: unsupported preset dictionary version")
+	errPresetDictCRC     = errors.New("lzma: preset dictionary checksum error")
+)
+
+// SaveDict writes the preset dictionary dict in a versioned container
+// format including a CRC-32 checksum to w.
+func SaveDict(w io.Writer, dict []byte) error {
+	if int64(len(dict)) > MaxDictCap {
+		return errors.New("lzma: preset dictionary too large")
+	}
+	p := make([]byte, presetDictHeaderLen, presetDictHeaderLen+len(dict)+4)
+	copy(p, presetDictMagic)
+	p[4] = presetDictVersion
+	putUint32LE(p[5:], uint32(len(dict)))
+	p = append(p, dict...)
+	var q [4]byte
+	putUint32LE(q[:], crc.ChecksumIEEE(dict))
+	p = append(p, q[:]...)
+	_, err := w.Write(p)
+	return err
+}
+
+// LoadDict reads a preset dictionary written by SaveDict from r. The
+// checksum of the dictionary is verified. The result can be used for
+// the PresetDict fields of WriterConfig and ReaderConfig.
+func LoadDict(r io.Reader) (dict []byte, err error) {
+	p := make([]byte, presetDictHeaderLen)
+	if _, err = io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !bytes.Equal(p[:4], presetDictMagic) {
+		return nil, errPresetDictMagic
+	}
+	if p[4] != presetDictVersion {
+		return nil, errPresetDictVersion
+	}
+	n := int64(uint32LE(p[5:]))
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r, n+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data := buf.Bytes()
+	dict, q := data[:n], data[n:]
+	if crc.ChecksumIEEE(dict) != uint32LE(q) {
+		return nil, errPresetDictCRC
+	}
+	return dict, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSaveLoadDict(t *testing.T) {
+	dict := []byte(testString)
+	var buf bytes.Buffer
+	if err := SaveDict(&buf, dict); err != nil {
+		t.Fatalf("SaveDict error %s", err)
+	}
+	data := buf.Bytes()
+	d, err := LoadDict(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadDict error %s", err)
+	}
+	if !bytes.Equal(d, dict) {
+		t.Fatalf("LoadDict returned %q; want %q", d, dict)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[presetDictHeaderLen+3] ^= 0x20
+	if _, err = LoadDict(bytes.NewReader(corrupt)); err != errPresetDictCRC {
+		t.Fatalf("LoadDict(corrupt) returned %v; want %v", err,
+			errPresetDictCRC)
+	}
+	_, err = LoadDict(bytes.NewReader(data[:len(data)-1]))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("LoadDict(truncated) returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	corrupt = append([]byte(nil), data...)
+	corrupt[4] = 2
+	if _, err = LoadDict(bytes.NewReader(corrupt)); err != errPresetDictVersion {
+		t.Fatalf("LoadDict(version 2) returned %v; want %v", err,
+			errPresetDictVersion)
+	}
+}
+
+func TestPresetDict(t *testing.T) {
+	dict := []byte(testString)
+	text := []byte(testString[100:] + testString[:50])
+	var buf bytes.Buffer
+	w, err := WriterConfig{PresetDict: dict}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(text); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	compressed := buf.Bytes()
+
+	var plain bytes.Buffer
+	w, err = NewWriter(&plain)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(text); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	t.Logf("compressed size with preset dictionary %d; without %d",
+		len(compressed), plain.Len())
+	if len(compressed) >= plain.Len() {
+		t.Errorf("preset dictionary didn't improve compression")
+	}
+
+	r, err := ReaderConfig{PresetDict: dict}.NewReader(
+		bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, text) {
+		t.Fatalf("decoded %q; want %q", out, text)
+	}
+}
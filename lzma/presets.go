@@ -0,0 +1,69 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// presetDictCapExps maps the preset levels to the exponents of the
+// dictionary capacities.
+var presetDictCapExps = [...]uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+// Presets returns the writer configurations for the compression
+// preset levels. The index of the slice is the level, so the levels
+// 0 to 9 are supported. All default values of the configurations are
+// filled in, so the properties, dictionary capacity and buffer size of
+// each level can be inspected.
+func Presets() []WriterConfig {
+	presets := make([]WriterConfig, len(presetDictCapExps))
+	for i, e := range presetDictCapExps {
+		c := &presets[i]
+		c.Properties = &Properties{LC: 3, LP: 0, PB: 2}
+		c.DictCap = 1 << e
+		c.fill()
+	}
+	return presets
+}
+
+// PresetForRatio returns the lowest preset level, and therewith the
+// fastest configuration, that compresses p with at least the given
+// compression ratio. The ratio is the length of p divided by the
+// length of the compressed LZMA stream including the header. The
+// function compresses p once for every level tried. An error is
+// returned if no preset level reaches the ratio.
+func PresetForRatio(p []byte, ratio float64) (level int, c WriterConfig,
+	err error) {
+	if !(ratio > 0) {
+		return 0, c, errors.New("lzma: ratio must be positive")
+	}
+	best := 0.0
+	var buf bytes.Buffer
+	for level, c = range Presets() {
+		buf.Reset()
+		w, err := c.NewWriter(&buf)
+		if err != nil {
+			return 0, c, err
+		}
+		if _, err = w.Write(p); err != nil {
+			return 0, c, err
+		}
+		if err = w.Close(); err != nil {
+			return 0, c, err
+		}
+		r := float64(len(p)) / float64(buf.Len())
+		if r >= ratio {
+			return level, c, nil
+		}
+		if r > best {
+			best = r
+		}
+	}
+	return 0, WriterConfig{}, fmt.Errorf(
+		"lzma: ratio %.3f not reachable; best ratio of presets is %.3f",
+		ratio, best)
+}
@@ -0,0 +1,95 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestPresets(t *testing.T) {
+	presets := Presets()
+	if len(presets) != 10 {
+		t.Fatalf("len(Presets()) is %d; want %d", len(presets), 10)
+	}
+	for i := range presets {
+		c := presets[i]
+		if err := c.Verify(); err != nil {
+			t.Errorf("preset %d: Verify error %s", i, err)
+		}
+		if i > 0 && c.DictCap < presets[i-1].DictCap {
+			t.Errorf("preset %d: DictCap %d smaller than for"+
+				" preset %d", i, c.DictCap, i-1)
+		}
+		t.Logf("preset %d: %s DictCap %d BufSize %d", i,
+			c.Properties, c.DictCap, c.BufSize)
+	}
+	if presets[6].DictCap != 8<<20 {
+		t.Errorf("preset 6: DictCap %d; want %d", presets[6].DictCap,
+			8<<20)
+	}
+}
+
+func TestPresetForRatio(t *testing.T) {
+	// The repetition can only be found with the dictionary of level 1.
+	p, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(5)), 300000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	p = append(p, p...)
+	presets := Presets()
+	ratio := func(level int) float64 {
+		var buf bytes.Buffer
+		w, err := presets[level].NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return float64(len(p)) / float64(buf.Len())
+	}
+	r0, r1 := ratio(0), ratio(1)
+	t.Logf("ratio of level 0: %.3f; level 1: %.3f", r0, r1)
+	if r1 <= r0 {
+		t.Fatalf("level 1 doesn't improve the ratio of level 0")
+	}
+	tests := []struct {
+		ratio float64
+		level int
+	}{
+		{1, 0},
+		{r0, 0},
+		{(r0 + r1) / 2, 1},
+		{r1, 1},
+	}
+	for _, tc := range tests {
+		level, c, err := PresetForRatio(p, tc.ratio)
+		if err != nil {
+			t.Fatalf("PresetForRatio(%.3f) error %s", tc.ratio, err)
+		}
+		if level != tc.level || c.DictCap != presets[level].DictCap {
+			t.Fatalf("PresetForRatio(%.3f) returned level %d;"+
+				" want %d", tc.ratio, level, tc.level)
+		}
+	}
+	if _, _, err = PresetForRatio(p, 100); err == nil {
+		t.Fatalf("PresetForRatio(100) returned no error")
+	} else {
+		t.Logf("PresetForRatio(100) error %s", err)
+	}
+	if _, _, err = PresetForRatio(p, 0); err == nil {
+		t.Fatalf("PresetForRatio(0) returned no error")
+	}
+}
@@ -4,10 +4,7 @@
 
 package lzma
 
-import (
-	"errors"
-	"io"
-)
+import "io"
 
 // rangeEncoder implements range encoding of single bits. The low value can
 // overflow therefore we need uint64. The cache value is used to handle
@@ -88,7 +85,12 @@ func (e *rangeEncoder) EncodeBit(b uint32, p *prob) error {
 	return e.shiftLow()
 }
 
-// Close writes a complete copy of the low value.
+// Close writes a complete copy of the low value. The five calls of
+// shiftLow write the cached byte, the pending 0xff bytes and the four
+// bytes of low. This is the finalization of the range encoder of XZ
+// Utils and the LZMA SDK, so the encoder produces the same final bytes
+// as these tools for the same sequence of operations. Decoders read
+// exactly these bytes, so the flush must not be shortened.
 func (e *rangeEncoder) Close() error {
 	for i := 0; i < 5; i++ {
 		if err := e.shiftLow(); err != nil {
@@ -141,7 +143,8 @@ func newRangeDecoder(br io.ByteReader) (d *rangeDecoder, err error) {
 		return nil, err
 	}
 	if b != 0 {
-		return nil, errors.New("newRangeDecoder: first byte not zero")
+		return nil, corruptError(
+			"lzma: first byte of range-coded data not zero")
 	}
 
 	for i := 0; i < 4; i++ {
@@ -151,7 +154,8 @@ func newRangeDecoder(br io.ByteReader) (d *rangeDecoder, err error) {
 	}
 
 	if d.code >= d.nrange {
-		return nil, errors.New("newRangeDecoder: d.code >= d.nrange")
+		return nil, corruptError(
+			"lzma: invalid initial range decoder code")
 	}
 
 	return d, nil
@@ -189,17 +193,20 @@ func (d *rangeDecoder) DirectDecodeBit() (b uint32, err error) {
 // least-significant position. All other bits will be zero. The probability
 // value will be updated.
 func (d *rangeDecoder) DecodeBit(p *prob) (b uint32, err error) {
-	bound := p.bound(d.nrange)
-	if d.code < bound {
-		d.nrange = bound
-		p.inc()
-		b = 0
-	} else {
-		d.code -= bound
-		d.nrange -= bound
-		p.dec()
-		b = 1
-	}
+	// The function avoids branches depending on the decoded bit,
+	// because the CPU cannot predict them.
+	q := uint32(*p)
+	bound := (d.nrange >> probbits) * q
+	// b is 1 if d.code >= bound
+	b = uint32((uint64(d.code)-uint64(bound))>>63) ^ 1
+	// mask has all bits set if b is 1
+	mask := -b
+	d.code -= bound & mask
+	d.nrange = (bound &^ mask) | ((d.nrange - bound) & mask)
+	// see prob.inc and prob.dec
+	inc := ((1 << probbits) - q) >> movebits
+	dec := q >> movebits
+	*p = prob(q + (inc &^ mask) - (dec & mask))
 	// normalize
 	// assume d.code < d.nrange
 	const top = 1 << 24
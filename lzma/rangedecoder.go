@@ -0,0 +1,58 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"io"
+)
+
+// ProbInit is the initial value for the probabilities used by
+// RangeDecoder.DecodeBit. It represents the probability 0.5.
+const ProbInit = uint16(probInit)
+
+// RangeDecoder provides the range decoder used by the LZMA decoder. It
+// decodes single bits either using an adaptive probability or with the
+// fixed probability 0.5.
+//
+// A probability is an 11-bit value giving the probability for a zero
+// bit. It should be initialized with ProbInit and is updated by every
+// call of DecodeBit.
+type RangeDecoder struct {
+	d *rangeDecoder
+}
+
+// NewRangeDecoder creates a new range decoder. It reads the first five
+// bytes of the range-encoded data from br. The first byte must be zero.
+func NewRangeDecoder(br io.ByteReader) (*RangeDecoder, error) {
+	d, err := newRangeDecoder(br)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeDecoder{d: d}, nil
+}
+
+// DecodeBit decodes a single bit using the probability p, which will
+// be updated. The bit is returned as 0 or 1.
+func (d *RangeDecoder) DecodeBit(p *uint16) (b int, err error) {
+	v, err := d.d.DecodeBit((*prob)(p))
+	return int(v), err
+}
+
+// DecodeDirectBits decodes n bits with the fixed probability 0.5. The
+// most-significant bit is decoded first. The value n must be in the
+// range [0,32].
+func (d *RangeDecoder) DecodeDirectBits(n int) (v uint32, err error) {
+	if !(0 <= n && n <= 32) {
+		return 0, errors.New("lzma: number of direct bits out of range")
+	}
+	return directCodec(n).Decode(d.d)
+}
+
+// PossiblyAtEnd returns whether the range-encoded data may be complete.
+// An LZMA stream can only end if this function returns true.
+func (d *RangeDecoder) PossiblyAtEnd() bool {
+	return d.d.possiblyAtEnd()
+}
@@ -0,0 +1,143 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestRangeDecoder(t *testing.T) {
+	const n = 20000
+	rng := rand.New(rand.NewSource(19))
+	type symbol struct {
+		direct int
+		v      uint32
+	}
+	symbols := make([]symbol, n)
+	for i := range symbols {
+		s := &symbols[i]
+		switch rng.Intn(4) {
+		case 0:
+			s.direct = 1 + rng.Intn(32)
+			s.v = rng.Uint32() >> uint(32-s.direct)
+		case 1:
+			// mostly zero bits drive the probability to its
+			// limit
+			if rng.Intn(50) == 0 {
+				s.v = 1
+			}
+		default:
+			s.v = uint32(rng.Intn(2))
+		}
+	}
+
+	var buf bytes.Buffer
+	e, err := newRangeEncoder(&buf)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	pe := probInit
+	for _, s := range symbols {
+		if s.direct > 0 {
+			err = directCodec(s.direct).Encode(e, s.v)
+		} else {
+			err = e.EncodeBit(s.v, &pe)
+		}
+		if err != nil {
+			t.Fatalf("encode error %s", err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatalf("e.Close error %s", err)
+	}
+
+	br := bytes.NewReader(buf.Bytes())
+	d, err := NewRangeDecoder(br)
+	if err != nil {
+		t.Fatalf("NewRangeDecoder error %s", err)
+	}
+	p := ProbInit
+	for i, s := range symbols {
+		var v uint32
+		if s.direct > 0 {
+			v, err = d.DecodeDirectBits(s.direct)
+		} else {
+			var b int
+			b, err = d.DecodeBit(&p)
+			v = uint32(b)
+		}
+		if err != nil {
+			t.Fatalf("symbol %d: decode error %s", i, err)
+		}
+		if v != s.v {
+			t.Fatalf("symbol %d: decoded %#x; want %#x", i, v, s.v)
+		}
+	}
+	if !d.PossiblyAtEnd() {
+		t.Errorf("PossiblyAtEnd returned false at the end")
+	}
+	if br.Len() != 0 {
+		t.Errorf("%d bytes not read by the decoder", br.Len())
+	}
+	if _, err = d.DecodeDirectBits(33); err == nil {
+		t.Errorf("DecodeDirectBits(33) returned no error")
+	}
+}
+
+func TestRangeDecoderNormalization(t *testing.T) {
+	const top = 1 << 24
+	tests := []struct {
+		nrange uint32
+		// bytes read by the normalization
+		n int
+	}{
+		{2*top - 2, 1},
+		{2 * top, 0},
+		{top, 1},
+	}
+	for _, tc := range tests {
+		br := bytes.NewReader(make([]byte, 10))
+		d, err := NewRangeDecoder(br)
+		if err != nil {
+			t.Fatalf("NewRangeDecoder error %s", err)
+		}
+		d.d.nrange = tc.nrange
+		k := br.Len()
+		if _, err = d.DecodeDirectBits(1); err != nil {
+			t.Fatalf("DecodeDirectBits error %s", err)
+		}
+		if n := k - br.Len(); n != tc.n {
+			t.Errorf("nrange %#x: direct bit read %d bytes; want %d",
+				tc.nrange, n, tc.n)
+		}
+		if d.d.nrange < top {
+			t.Errorf("nrange %#x: not normalized after direct bit",
+				tc.nrange)
+		}
+	}
+
+	// A bit with the lowest probability requires normalization, but
+	// a single byte is always sufficient.
+	br := bytes.NewReader(make([]byte, 10))
+	d, err := NewRangeDecoder(br)
+	if err != nil {
+		t.Fatalf("NewRangeDecoder error %s", err)
+	}
+	d.d.nrange = top
+	p := uint16(1 << movebits)
+	k := br.Len()
+	if _, err = d.DecodeBit(&p); err != nil {
+		t.Fatalf("DecodeBit error %s", err)
+	}
+	if n := k - br.Len(); n != 1 {
+		t.Errorf("DecodeBit read %d bytes; want 1", n)
+	}
+	if d.d.nrange < top || d.d.code >= d.d.nrange {
+		t.Errorf("range %#x and code %#x not normalized", d.d.nrange,
+			d.d.code)
+	}
+}
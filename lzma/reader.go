@@ -11,7 +11,9 @@
 package lzma
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -19,12 +21,77 @@ import (
 // format.
 type ReaderConfig struct {
 	DictCap int
+	// PresetDict provides the preset dictionary that has been used
+	// by the writer of the stream.
+	PresetDict []byte
+	// MaxDecompressedSize limits the number of bytes the reader
+	// will return. If more data would be decompressed, Read returns
+	// ErrSizeLimit. The value zero indicates no limit.
+	MaxDecompressedSize int64
+	// AllowNoEOS supports legacy streams that have neither a size in
+	// the header nor an EOS marker. The end of the underlying reader
+	// at an operation boundary will be treated as end of the stream
+	// instead of returning io.ErrUnexpectedEOF.
+	AllowNoEOS bool
+	// InitialReps sets the initial values of the repeated distances
+	// rep0 to rep3 as distances minus one. It must have the value
+	// used by the writer of the stream. The zero value provides the
+	// standard initialization.
+	InitialReps [4]uint32
+	// Allocator provides the memory for the dictionary buffer. The
+	// buffer is returned to the allocator by Close. If Allocator is
+	// nil the buffer is allocated with make.
+	Allocator BufferAllocator
+	// DictBuffer provides the memory for the dictionary buffer. It
+	// allows decoding without allocating a dictionary if the caller
+	// reuses the slice for many streams. The slice must be longer
+	// than the dictionary capacity of the stream and DictCap, whose
+	// zero value selects MinDictCap in this case. The reader uses
+	// all of the slice. The slice may be reused after the Reader
+	// isn't used anymore. If DictBuffer is nil the buffer is
+	// allocated as usual. It can't be combined with Allocator.
+	DictBuffer []byte
+	// MaxWorkUnits limits the work the decoder may spend on the
+	// stream. Every decoded literal costs one unit and every match
+	// one unit plus one unit per byte it copies. If the budget would
+	// be exceeded, Read returns ErrBudgetExceeded after the data
+	// decoded before. In contrast to MaxDecompressedSize the budget
+	// also bounds the CPU time for streams that need much work for
+	// little output. The value zero indicates no limit.
+	MaxWorkUnits int64
+	// WipeOnReset requests that Close and RestoreState zero the
+	// dictionary buffer and the scratch buffer used by Window and
+	// SaveState before the memory is released or reused, so no
+	// decompressed data remains in it. Wiping costs time
+	// proportional to the dictionary size.
+	WipeOnReset bool
+	// ClampDictCap lowers a dictionary size in the header that is
+	// larger than the value to this value. Some misconfigured
+	// encoders declare huge dictionaries, e.g. 4 GiB, while the
+	// matches use only a small window; the reader would allocate the
+	// declared size otherwise. Decoding fails with a corrupt input
+	// error only if a match actually references data beyond the
+	// clamped window. The reader still uses DictCap if it is larger.
+	// The zero value disables clamping.
+	ClampDictCap int
+	// OpTrace receives a line for every operation decoded, in the
+	// format described for WriterConfig.OpTrace. For a round trip
+	// the traces of the writer and the reader are identical, which
+	// helps to localize bugs of the encoder or decoder. An error
+	// returned by OpTrace is reported by the Reader method that
+	// caused it. If OpTrace is nil, the default, no trace is
+	// written.
+	OpTrace io.Writer
 }
 
 // fill converts the zero values of the configuration to the default values.
 func (c *ReaderConfig) fill() {
 	if c.DictCap == 0 {
 		c.DictCap = 8 * 1024 * 1024
+		// the buffer size is controlled by the caller
+		if c.DictBuffer != nil {
+			c.DictCap = MinDictCap
+		}
 	}
 }
 
@@ -35,6 +102,25 @@ func (c *ReaderConfig) Verify() error {
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
 		return errors.New("lzma: dictionary capacity is out of range")
 	}
+	if c.MaxDecompressedSize < 0 {
+		return errors.New("lzma: negative MaxDecompressedSize")
+	}
+	if c.MaxWorkUnits < 0 {
+		return errors.New("lzma: negative MaxWorkUnits")
+	}
+	if c.ClampDictCap != 0 && !(MinDictCap <= c.ClampDictCap &&
+		int64(c.ClampDictCap) <= MaxDictCap) {
+		return errors.New("lzma: ClampDictCap is out of range")
+	}
+	for _, r := range c.InitialReps {
+		if int64(r) >= MaxDictCap {
+			return errors.New("lzma: initial rep distance out of range")
+		}
+	}
+	if c.DictBuffer != nil && c.Allocator != nil {
+		return errors.New(
+			"lzma: DictBuffer and Allocator are both set")
+	}
 	return nil
 }
 
@@ -43,6 +129,25 @@ type Reader struct {
 	lzma io.Reader
 	h    header
 	d    *decoder
+	// limit for the decompressed size; zero means no limit
+	maxSize int64
+	// number of bytes returned by Read
+	n int64
+	// buffered is set if the underlying reader reports the number of
+	// buffered bytes; it is required by TryRead
+	buffered interface{ Buffered() int }
+	// the underlying reader has received the complete input
+	inputComplete bool
+	// scratch buffer for Window
+	scratch []byte
+	// slices returned by ReadBuffers
+	bufs [2][]byte
+	// allocator of the dictionary buffer
+	alloc BufferAllocator
+	// counts the compressed bytes read by the decoder
+	in *countingByteReader
+	// zero buffers on Close and RestoreState
+	wipe bool
 }
 
 // NewReader creates a new reader for an LZMA stream using the classic
@@ -65,36 +170,404 @@ func (c ReaderConfig) NewReader(lzma io.Reader) (r *Reader, err error) {
 		}
 		return nil, err
 	}
-	r = &Reader{lzma: lzma}
-	if err = r.h.unmarshalBinary(data); err != nil {
+	var h header
+	if err = h.unmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if r, err = c.newReader(lzma, h); err != nil {
+		return nil, err
+	}
+	r.in.n += HeaderLen
+	return r, nil
+}
+
+// NewReaderParams creates a new reader for a raw LZMA stream without
+// header. The parameters normally provided by the header are taken
+// from p. If p.SizeInHeader is set the stream must contain p.Size
+// bytes of uncompressed data, otherwise it must be terminated by an
+// end-of-stream marker.
+func NewReaderParams(lzma io.Reader, p *Parameters) (r *Reader, err error) {
+	return ReaderConfig{}.NewReaderParams(lzma, p)
+}
+
+// NewReaderParams creates a new reader for a raw LZMA stream without
+// header using the parameters p instead of reading a header. Streams
+// written using the NoHeader option of WriterConfig can be read this
+// way.
+func (c ReaderConfig) NewReaderParams(lzma io.Reader, p *Parameters) (
+	r *Reader, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.New("lzma: parameters are nil")
+	}
+	h, err := paramsHeader(p)
+	if err != nil {
+		return nil, err
+	}
+	return c.newReader(lzma, h)
+}
+
+// newReader creates the reader for the stream following the header h.
+func (c *ReaderConfig) newReader(lzma io.Reader, h header) (
+	r *Reader, err error) {
+	if r, err = c.initReader(lzma, h); err != nil {
+		return nil, err
+	}
+	if r.d.rd, err = newRangeDecoder(r.in); err != nil {
 		return nil, err
 	}
+	return r, nil
+}
+
+// initReader creates the reader for the stream following the header h
+// without reading from lzma. The range decoder of the decoder must
+// still be created.
+func (c *ReaderConfig) initReader(lzma io.Reader, h header) (
+	r *Reader, err error) {
+	r = &Reader{lzma: lzma, h: h, maxSize: c.MaxDecompressedSize,
+		wipe: c.WipeOnReset}
+	r.buffered, _ = lzma.(interface{ Buffered() int })
 	if r.h.dictCap < MinDictCap {
 		r.h.dictCap = MinDictCap
 	}
+	if c.ClampDictCap > 0 && r.h.dictCap > c.ClampDictCap {
+		r.h.dictCap = c.ClampDictCap
+	}
 	dictCap := r.h.dictCap
 	if c.DictCap > dictCap {
 		dictCap = c.DictCap
 	}
 
 	state := newState(r.h.properties)
-	dict, err := newDecoderDict(dictCap)
-	if err != nil {
-		return nil, err
+	state.rep = c.InitialReps
+	alloc := c.Allocator
+	if c.DictBuffer != nil {
+		if len(c.DictBuffer) <= dictCap {
+			return nil, fmt.Errorf(
+				"lzma: DictBuffer has %d bytes; need at least %d",
+				len(c.DictBuffer), dictCap+1)
+		}
+		dictCap = len(c.DictBuffer) - 1
+		alloc = sliceAllocator(c.DictBuffer)
 	}
-	r.d, err = newDecoder(ByteReader(lzma), state, dict, r.h.size)
+	dict, err := newDecoderDictAlloc(dictCap, alloc)
 	if err != nil {
 		return nil, err
 	}
+	r.alloc = alloc
+	dict.preset(c.PresetDict)
+	r.in = &countingByteReader{br: ByteReader(lzma)}
+	r.d = &decoder{
+		State:      state,
+		Dict:       dict,
+		size:       r.h.size,
+		start:      dict.pos(),
+		allowNoEOS: c.AllowNoEOS,
+		maxWork:    c.MaxWorkUnits,
+		trace:      c.OpTrace,
+	}
 	return r, nil
 }
 
+// NewReaderAt creates a new reader for an LZMA stream in the classic
+// format that is stored at the given offset with the given length in
+// r.
+func NewReaderAt(r io.ReaderAt, offset, length int64) (*Reader, error) {
+	return ReaderConfig{}.NewReaderAt(r, offset, length)
+}
+
+// NewReaderAt creates a new reader for an LZMA stream in the classic
+// format that is embedded in r at the given offset and has the given
+// length. The reader never reads data beyond offset+length. If the
+// stream requires more data, io.ErrUnexpectedEOF is returned.
+func (c ReaderConfig) NewReaderAt(r io.ReaderAt, offset, length int64) (
+	lr *Reader, err error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.New("lzma: negative offset or length")
+	}
+	sr := io.NewSectionReader(r, offset, length)
+	lr, err = c.NewReader(bufio.NewReader(sr))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return lr, err
+}
+
+// Parameters describes the parameters of an LZMA stream in the classic
+// format as provided by its header.
+type Parameters struct {
+	LC int
+	LP int
+	PB int
+	// dictionary size given by the header; values smaller than
+	// MinDictCap are raised to MinDictCap and values larger than
+	// ClampDictCap of the reader configuration are lowered to it
+	DictSize int
+	// uncompressed size; -1 if the header provides no size
+	Size int64
+	// the header contains the uncompressed size
+	SizeInHeader bool
+	// the stream must be terminated by an end-of-stream marker,
+	// because no size is given in the header
+	EOS bool
+}
+
+// Parameters returns a copy of the parameters read from the header of
+// the LZMA stream.
+func (r *Reader) Parameters() *Parameters {
+	return r.h.params()
+}
+
+// ReadBuffers returns the next uncompressed data as slices referencing
+// the dictionary of the decoder directly, avoiding the copy made by
+// Read. The data returned is consumed; it will not be returned by Read
+// again. At the end of the stream ReadBuffers returns io.EOF.
+//
+// The slices must not be modified and are valid only until the next
+// call of Read, TryRead, ReadBuffers or Window, because the decoder
+// overwrites the data. The slices can be converted into net.Buffers to
+// write them directly to a connection. Callers who need to keep the
+// data longer must copy it or use Read, which is the safe alternative.
+func (r *Reader) ReadBuffers() (bufs [][]byte, err error) {
+	if r.d.Dict.buf.Buffered() == 0 {
+		if r.d.err != nil {
+			return nil, r.d.err
+		}
+		if err = r.d.decompress(); err != nil && err != io.EOF {
+			// return the data decoded before the error first
+			r.d.err = err
+		}
+		if r.d.Dict.buf.Buffered() == 0 {
+			if r.d.err != nil {
+				return nil, r.d.err
+			}
+			return nil, io.EOF
+		}
+	}
+	bufs = r.d.Dict.readBuffers(&r.bufs)
+	if r.maxSize <= 0 {
+		return bufs, nil
+	}
+	m := r.maxSize - r.n
+	for i, p := range bufs {
+		if int64(len(p)) > m {
+			bufs[i] = p[:m]
+			r.n = r.maxSize
+			return bufs[:i+1], ErrSizeLimit
+		}
+		m -= int64(len(p))
+		r.n += int64(len(p))
+	}
+	return bufs, nil
+}
+
+// Window returns the current contents of the decoder dictionary. These
+// are the last bytes decompressed by the reader; the window holds
+// min(n, c) bytes, where n is the number of bytes decompressed so far,
+// including a preset dictionary, and c is the dictionary capacity used
+// by the reader, which is the maximum of the dictionary size in the
+// header and the DictCap value of the reader configuration.
+//
+// The decoder works ahead of Read, so the window may end with bytes
+// that have not yet been returned by Read. Those bytes will be returned
+// by the next Read calls.
+//
+// The returned slice must not be modified. It is volatile: it
+// refers to the internal dictionary or a scratch buffer of the reader
+// and is valid only until the next call of Read, TryRead, ReadBuffers
+// or Window.
+// Copy the data if it is needed later.
+func (r *Reader) Window() []byte {
+	return r.d.Dict.window(&r.scratch)
+}
+
+// Compatible returns whether streams described by the parameters a and
+// b can be decoded with the same properties and dictionary size. The
+// fields Size, SizeInHeader and EOS are ignored. Nil parameters are
+// not compatible.
+func Compatible(a, b *Parameters) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.LC == b.LC && a.LP == b.LP && a.PB == b.PB &&
+		a.DictSize == b.DictSize
+}
+
+// Complete reports whether the reader has returned all data of a
+// stream that ended properly, either with an EOS marker or by reaching
+// the size given in the header. It returns false if the caller
+// stopped reading early or decoding failed.
+func (r *Reader) Complete() bool {
+	return r.d.end != EndNone && r.d.Dict.buf.Buffered() == 0
+}
+
+// EndReason describes what terminated an LZMA stream.
+type EndReason byte
+
+// Reasons for the end of a stream. EndNone is reported as long as the
+// stream has not ended properly. A stream with a size in the header
+// and an EOS marker is reported as ended by EndByEOS.
+const (
+	EndNone EndReason = iota
+	EndByEOS
+	EndBySize
+	EndByUnderlyingEOF
+)
+
+// erStrings are used by the String method.
+var erStrings = map[EndReason]string{
+	EndNone:            "EndNone",
+	EndByEOS:           "EndByEOS",
+	EndBySize:          "EndBySize",
+	EndByUnderlyingEOF: "EndByUnderlyingEOF",
+}
+
+// String returns a string representation of the end reason.
+func (e EndReason) String() string {
+	if s, ok := erStrings[e]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// Reason reports what terminated the stream after the reader has
+// returned all its data: the EOS marker, the size given in the header
+// or, for legacy streams read with AllowNoEOS, the end of the
+// underlying reader. It returns EndNone under the same conditions
+// under which Complete returns false.
+func (r *Reader) Reason() EndReason {
+	if !r.Complete() {
+		return EndNone
+	}
+	return r.d.end
+}
+
+// Remaining returns the number of bytes the reader still has to return
+// according to the uncompressed size given in the header. It returns -1
+// if the header doesn't provide the size.
+func (r *Reader) Remaining() int64 {
+	if r.h.size < 0 {
+		return -1
+	}
+	n := r.d.Decompressed() - int64(r.d.Dict.buf.Buffered())
+	return r.h.size - n
+}
+
 // EOSMarker indicates that an EOS marker has been encountered.
 func (r *Reader) EOSMarker() bool {
 	return r.d.eosMarker
 }
 
+// ErrSizeLimit indicates that the decompressed data exceeds the
+// MaxDecompressedSize value of the reader configuration.
+var ErrSizeLimit = errors.New("lzma: decompressed size limit exceeded")
+
+// ErrBudgetExceeded indicates that decoding the stream requires more
+// work units than allowed by MaxWorkUnits of the reader configuration.
+var ErrBudgetExceeded = errors.New("lzma: work budget exceeded")
+
 // Read returns uncompressed data.
 func (r *Reader) Read(p []byte) (n int, err error) {
-	return r.d.Read(p)
+	return r.limitRead(p, r.d.Read)
+}
+
+// limitRead calls read and enforces the limit for the decompressed
+// size.
+func (r *Reader) limitRead(p []byte, read func(p []byte) (int, error)) (
+	n int, err error) {
+	if r.maxSize <= 0 {
+		return read(p)
+	}
+	// We read one byte more than allowed to detect the overflow.
+	m := r.maxSize - r.n
+	if int64(len(p)) > m {
+		p = p[:m+1]
+	}
+	n, err = read(p)
+	if int64(n) > m {
+		n, err = int(m), ErrSizeLimit
+	}
+	r.n += int64(n)
+	return n, err
+}
+
+// errReaderClosed is returned by the read methods after Close.
+var errReaderClosed = errors.New("lzma: reader closed")
+
+// Close returns the dictionary buffer to the allocator of the reader
+// configuration. Buffered data is discarded and all following reads
+// return an error. If WipeOnReset is set, the buffers are zeroed
+// first. Close doesn't close the underlying reader.
+func (r *Reader) Close() error {
+	if r.d.err == errReaderClosed {
+		return errReaderClosed
+	}
+	if r.wipe {
+		r.d.Dict.buf.Reset()
+		r.d.Dict.buf.wipe()
+		zero(r.scratch)
+	}
+	r.d.Dict.buf.free(r.alloc)
+	r.d.Dict.Reset()
+	r.d.err = errReaderClosed
+	return nil
+}
+
+// ErrNoProgress is returned by TryRead if no uncompressed data can be
+// provided without reading more input from the underlying reader.
+var ErrNoProgress = errors.New("lzma: more input required")
+
+// maxOpInputLen is an upper bound for the number of bytes the range
+// decoder consumes for a single operation. A match requires at most 48
+// bits and every bit consumes at most a single byte.
+const maxOpInputLen = 64
+
+// TryRead works like Read but never blocks on the underlying reader. It
+// returns the uncompressed data that is already available or that can
+// be decoded from the input already buffered by the underlying reader.
+// If no data can be provided, TryRead returns 0 and ErrNoProgress. The
+// caller should then supply more input to the underlying reader and
+// call TryRead again. At the end of the stream io.EOF is returned.
+//
+// The underlying reader must provide a Buffered method as bufio.Reader
+// does, otherwise TryRead returns an error. Operations are only decoded
+// if at least 128 bytes are buffered, which is twice the upper bound of
+// the input required for a single operation, because the decoder may
+// read the EOS marker after the last operation. After the underlying
+// reader has received the complete input, the caller must call
+// InputComplete, so that TryRead decodes the rest of the stream.
+func (r *Reader) TryRead(p []byte) (n int, err error) {
+	if r.buffered == nil {
+		return 0, errors.New(
+			"lzma: TryRead requires a reader with Buffered method")
+	}
+	ready := func() bool {
+		return r.inputComplete ||
+			r.buffered.Buffered() >= 2*maxOpInputLen
+	}
+	return r.limitRead(p, func(p []byte) (int, error) {
+		return r.d.tryRead(p, ready)
+	})
+}
+
+// InputComplete tells TryRead that the underlying reader has received
+// the complete input, so that reading from it doesn't block anymore.
+// TryRead then decodes the operations at the end of the stream, for
+// which fewer than 128 bytes are buffered.
+func (r *Reader) InputComplete() {
+	r.inputComplete = true
+}
+
+// VerifyStream checks whether lzma provides a valid LZMA stream in the
+// classic format. The stream is fully decoded but the uncompressed data
+// is discarded directly in the dictionary. The function returns nil if
+// the stream could be decoded without error.
+func VerifyStream(lzma io.Reader) error {
+	r, err := NewReader(lzma)
+	if err != nil {
+		return err
+	}
+	return r.d.verify()
 }
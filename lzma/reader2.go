@@ -15,6 +15,17 @@ import (
 // format.
 type Reader2Config struct {
 	DictCap int
+	// PresetDict provides the preset dictionary that has been used
+	// by the writer of the chunk sequence. The first chunk must not
+	// reset the dictionary then.
+	PresetDict []byte
+	// WipeOnReset requests that a dictionary reset of the chunk
+	// sequence zeroes the parts of the dictionary buffer that don't
+	// hold data still to be read and that Close zeroes the whole
+	// buffer. So decompressed data doesn't remain in the buffer
+	// after it is not needed anymore. Every dictionary reset then
+	// costs time proportional to the dictionary size.
+	WipeOnReset bool
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -48,6 +59,8 @@ type Reader2 struct {
 	chunkReader io.Reader
 
 	cstate chunkState
+	// zero the dictionary on resets and Close
+	wipe bool
 }
 
 // NewReader2 creates a reader for an LZMA2 chunk sequence.
@@ -60,11 +73,16 @@ func (c Reader2Config) NewReader2(lzma2 io.Reader) (r *Reader2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader2{r: lzma2, cstate: start}
+	r = &Reader2{
+		r:      lzma2,
+		cstate: startState(len(c.PresetDict) > 0),
+		wipe:   c.WipeOnReset,
+	}
 	r.dict, err = newDecoderDict(c.DictCap)
 	if err != nil {
 		return nil, err
 	}
+	r.dict.preset(c.PresetDict)
 	if err = r.startChunk(); err != nil {
 		r.err = err
 	}
@@ -96,6 +114,9 @@ func (r *Reader2) startChunk() error {
 	}
 	if header.ctype == cUD || header.ctype == cLRND {
 		r.dict.Reset()
+		if r.wipe {
+			r.dict.buf.wipe()
+		}
 	}
 	size := int64(header.uncompressed) + 1
 	if uncompressed(header.ctype) {
@@ -112,6 +133,9 @@ func (r *Reader2) startChunk() error {
 		state := newState(header.props)
 		r.decoder, err = newDecoder(br, state, r.dict, size)
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return err
 		}
 		r.chunkReader = r.decoder
@@ -125,6 +149,9 @@ func (r *Reader2) startChunk() error {
 	}
 	err = r.decoder.Reopen(br, size)
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return err
 	}
 	r.chunkReader = r.decoder
@@ -158,6 +185,22 @@ func (r *Reader2) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// Close discards the buffered data and zeroes the dictionary buffer if
+// WipeOnReset has been set. All following reads return an error. Close
+// doesn't close the underlying reader.
+func (r *Reader2) Close() error {
+	if r.err == errReaderClosed {
+		return errReaderClosed
+	}
+	r.dict.buf.Reset()
+	if r.wipe {
+		r.dict.buf.wipe()
+	}
+	r.dict.Reset()
+	r.err = errReaderClosed
+	return nil
+}
+
 // EOS returns whether the LZMA2 stream has been terminated by an
 // end-of-stream chunk.
 func (r *Reader2) EOS() bool {
@@ -0,0 +1,156 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// uncompressedStream creates an LZMA2 stream that consists only of
+// uncompressed chunks.
+func uncompressedStream(t *testing.T, data []byte) []byte {
+	const chunkLen = 1 << 16
+	var buf bytes.Buffer
+	h := chunkHeader{ctype: cUD}
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunkLen {
+			n = chunkLen
+		}
+		h.uncompressed = uint32(n - 1)
+		p, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error %s", err)
+		}
+		buf.Write(p)
+		buf.Write(data[:n])
+		data = data[n:]
+		h.ctype = cU
+	}
+	buf.WriteByte(hEOS)
+	return buf.Bytes()
+}
+
+func TestReader2Uncompressed(t *testing.T) {
+	data := make([]byte, 200000)
+	rand.New(rand.NewSource(17)).Read(data)
+	stream := uncompressedStream(t, data)
+	r, err := Reader2Config{DictCap: MinDictCap}.NewReader2(
+		bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+	if !r.EOS() {
+		t.Fatalf("EOS chunk not found")
+	}
+	if r.decoder != nil {
+		t.Fatalf("range decoder has been initialized")
+	}
+}
+
+func TestReader2Truncated(t *testing.T) {
+	data := []byte(testString)
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	stream := buf.Bytes()
+	// Truncate the stream in the header, at the start of the range
+	// coder data and in the middle of the chunk.
+	for _, n := range []int{3, 8, len(stream) / 2} {
+		r, err := NewReader2(bytes.NewReader(stream[:n]))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("truncated at %d: ReadAll error %v; want %v",
+				n, err, io.ErrUnexpectedEOF)
+		}
+		if !bytes.Equal(got, data[:len(got)]) {
+			t.Fatalf("truncated at %d: recovered data isn't a"+
+				" prefix of the original", n)
+		}
+	}
+}
+
+func TestReader2WipeOnReset(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog.")
+	// The first sequence is only flushed, so the second sequence
+	// continues the stream with a dictionary reset.
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: MinDictCap}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush error %s", err)
+	}
+	w, err = Writer2Config{DictCap: MinDictCap}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write([]byte{'x'}); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	stream := buf.Bytes()
+
+	for _, wipe := range []bool{false, true} {
+		c := Reader2Config{DictCap: MinDictCap, WipeOnReset: wipe}
+		r, err := c.NewReader2(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p := make([]byte, len(data)+1)
+		if _, err = io.ReadFull(r, p); err != nil {
+			t.Fatalf("ReadFull error %s", err)
+		}
+		if !bytes.Equal(p[:len(data)], data) || p[len(data)] != 'x' {
+			t.Fatalf("decoded %q", p)
+		}
+		// only the byte after the reset remains
+		n := nonZero(r.dict.buf.data)
+		if wipe && n != 1 {
+			t.Fatalf("%d bytes in dictionary after reset; want 1",
+				n)
+		}
+		if !wipe && n <= 1 {
+			t.Fatalf("dictionary empty without wipe")
+		}
+		if err = r.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		if n = nonZero(r.dict.buf.data); wipe && n != 0 {
+			t.Fatalf("%d bytes not wiped by Close", n)
+		}
+		if _, err = r.Read(p); err != errReaderClosed {
+			t.Fatalf("Read after Close returned %v", err)
+		}
+	}
+}
@@ -7,13 +7,19 @@ package lzma
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"testing/iotest"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
 )
 
 func TestNewReader(t *testing.T) {
@@ -349,3 +355,1135 @@ func TestMinDictSize(t *testing.T) {
 		t.Fatalf("got %q; want %q", u, uncompressed)
 	}
 }
+
+func TestVerifyStream(t *testing.T) {
+	tests := []struct {
+		file  string
+		valid bool
+	}{
+		{"a.lzma", true},
+		{"a_eos.lzma", true},
+		{"a_eos_and_size.lzma", true},
+		{"a_lp1_lc2_pb1.lzma", true},
+		{"a_lc0_lp0_pb0.lzma", true},
+		{"bad_corrupted.lzma", false},
+		{"bad_eos_incorrect_size.lzma", false},
+		{"bad_incorrect_size.lzma", false},
+	}
+	for _, tc := range tests {
+		data, err := ioutil.ReadFile(filepath.Join(dirname, tc.file))
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		err = VerifyStream(bytes.NewReader(data))
+		if tc.valid && err != nil {
+			t.Errorf("VerifyStream(%s) error %s", tc.file, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("VerifyStream(%s) returned no error", tc.file)
+		}
+	}
+}
+
+func TestReaderMaxDecompressedSize(t *testing.T) {
+	const size = 1 << 20
+	data := compressWithConfig(t, WriterConfig{}, make([]byte, size))
+	t.Logf("%d zero bytes compressed to %d bytes", size, len(data))
+
+	const limit = 1000
+	r, err := ReaderConfig{MaxDecompressedSize: limit}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != ErrSizeLimit {
+		t.Fatalf("io.Copy returned error %v; want %v", err,
+			ErrSizeLimit)
+	}
+	if n != limit {
+		t.Fatalf("io.Copy returned %d bytes; want %d", n, limit)
+	}
+
+	r, err = ReaderConfig{MaxDecompressedSize: size}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if n, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if n != size {
+		t.Fatalf("io.Copy returned %d bytes; want %d", n, size)
+	}
+}
+
+func TestNewReaderAt(t *testing.T) {
+	orig := readOrigFile(t)
+	stream, err := ioutil.ReadFile(filepath.Join(dirname, "a.lzma"))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	prefix := []byte("container header")
+	var buf bytes.Buffer
+	buf.Write(prefix)
+	buf.Write(stream)
+	buf.WriteString("trailing data")
+	data := buf.Bytes()
+
+	off, n := int64(len(prefix)), int64(len(stream))
+	r, err := NewReaderAt(bytes.NewReader(data), off, n)
+	if err != nil {
+		t.Fatalf("NewReaderAt error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, orig) {
+		t.Fatalf("decoded data differs from original")
+	}
+
+	for _, m := range []int64{2, HeaderLen + 2, n - 10} {
+		r, err = NewReaderAt(bytes.NewReader(data), off, m)
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("length %d: got error %v; want %v", m, err,
+				io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestReaderAllowNoEOS(t *testing.T) {
+	orig := readOrigFile(t)
+	data := compressWithConfig(t,
+		WriterConfig{Size: int64(len(orig))}, orig)
+	// Remove the size from the header to create a legacy stream
+	// without size and EOS marker.
+	putUint64LE(data[5:13], noHeaderSize)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAll returned error %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+
+	r, err = ReaderConfig{AllowNoEOS: true}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, orig) {
+		t.Fatalf("decoded data differs from original")
+	}
+
+	// truncated streams must still be reported
+	r, err = ReaderConfig{AllowNoEOS: true}.NewReader(
+		bytes.NewReader(data[:len(data)-20]))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAll of truncated stream returned error %v;"+
+			" want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// stagedReader provides only the first avail bytes of data. Reading
+// beyond avail returns errBlocked.
+type stagedReader struct {
+	data  []byte
+	pos   int
+	avail int
+}
+
+var errBlocked = errors.New("read would block")
+
+func (r *stagedReader) ReadByte() (c byte, err error) {
+	if r.pos >= len(r.data) {
+		return 0, io.EOF
+	}
+	if r.pos >= r.avail {
+		return 0, errBlocked
+	}
+	c = r.data[r.pos]
+	r.pos++
+	return c, nil
+}
+
+func (r *stagedReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if p[n], err = r.ReadByte(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (r *stagedReader) Buffered() int { return r.avail - r.pos }
+
+func TestReaderTryRead(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(7)), 50000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	sr := &stagedReader{data: buf.Bytes(), avail: HeaderLen + 5}
+	r, err := NewReader(sr)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var out bytes.Buffer
+	p := make([]byte, 1000)
+	for sr.avail < len(sr.data) {
+		n, err := r.TryRead(p)
+		out.Write(p[:n])
+		switch err {
+		case nil:
+			continue
+		case ErrNoProgress:
+			if n != 0 {
+				t.Fatalf("TryRead returned n=%d with %s", n, err)
+			}
+		default:
+			t.Fatalf("TryRead error %s", err)
+		}
+		sr.avail += 37
+		if sr.avail > len(sr.data) {
+			sr.avail = len(sr.data)
+		}
+	}
+	t.Logf("TryRead provided %d of %d bytes", out.Len(), len(txt))
+	if out.Len() == 0 {
+		t.Fatalf("TryRead provided no data")
+	}
+	r.InputComplete()
+	if err = tryReadAll(&out, r, p); err != nil {
+		t.Fatalf("tryReadAll error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), txt) {
+		t.Fatalf("decoded data differs")
+	}
+
+	r, err = NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = r.TryRead(p); err == nil {
+		t.Fatalf("TryRead without Buffered method returned no error")
+	}
+}
+
+// tryReadAll calls TryRead until the end of the stream and writes the
+// data to w.
+func tryReadAll(w io.Writer, r *Reader, p []byte) error {
+	for {
+		n, err := r.TryRead(p)
+		w.Write(p[:n])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestReaderTryReadShort(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte(testString)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	sr := &stagedReader{data: buf.Bytes(), avail: buf.Len()}
+	r, err := NewReader(sr)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	// The compressed stream is shorter than 128 bytes, so nothing is
+	// decoded before InputComplete has been called.
+	p := make([]byte, 100)
+	if n, err := r.TryRead(p); n != 0 || err != ErrNoProgress {
+		t.Fatalf("TryRead returned %d, %v; want 0, %v",
+			n, err, ErrNoProgress)
+	}
+	r.InputComplete()
+	var out bytes.Buffer
+	if err = tryReadAll(&out, r, p); err != nil {
+		t.Fatalf("tryReadAll error %s", err)
+	}
+	if out.String() != testString {
+		t.Fatalf("TryRead returned %q; want %q", out.String(),
+			testString)
+	}
+}
+
+func TestReaderParameters(t *testing.T) {
+	tests := []struct {
+		file string
+		want Parameters
+	}{
+		{"examples/a.lzma", Parameters{LC: 3, LP: 0, PB: 2,
+			DictSize: 8 << 20, Size: 327, SizeInHeader: true}},
+		{"examples/a_eos.lzma", Parameters{LC: 3, LP: 0, PB: 2,
+			DictSize: 64 << 10, Size: -1, EOS: true}},
+	}
+	for _, tc := range tests {
+		f, err := os.Open(tc.file)
+		if err != nil {
+			t.Fatalf("os.Open(%q) error %s", tc.file, err)
+		}
+		r, err := NewReader(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.file, err)
+		}
+		p := r.Parameters()
+		if *p != tc.want {
+			t.Errorf("%s: Parameters returned %+v; want %+v",
+				tc.file, *p, tc.want)
+		}
+	}
+}
+
+func TestReaderWindow(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(11)), 20000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	const dictCap = MinDictCap
+	c := WriterConfig{DictCap: dictCap}
+	data := compressWithConfig(t, c, txt)
+	r, err := ReaderConfig{DictCap: dictCap}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var out []byte
+	p := make([]byte, 999)
+	for {
+		n, err := r.Read(p)
+		out = append(out, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read error %s", err)
+		}
+		w := r.Window()
+		decoded := len(out) + r.d.Dict.buf.Buffered()
+		want := decoded
+		if want > dictCap {
+			want = dictCap
+		}
+		if len(w) != want {
+			t.Fatalf("len(Window()) is %d; want %d", len(w), want)
+		}
+		if !bytes.Equal(w, txt[decoded-want:decoded]) {
+			t.Fatalf("Window() doesn't match decoded data")
+		}
+	}
+	if !bytes.Equal(out, txt) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func TestReaderReadBuffers(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(13)), 30000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	data := compressWithConfig(t, WriterConfig{DictCap: MinDictCap}, txt)
+	c := ReaderConfig{DictCap: MinDictCap}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var out []byte
+	calls, wrapped := 0, 0
+	for {
+		bufs, err := r.ReadBuffers()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadBuffers error %s", err)
+		}
+		calls++
+		if len(bufs) == 2 {
+			wrapped++
+		}
+		for _, p := range bufs {
+			out = append(out, p...)
+		}
+	}
+	t.Logf("%d calls of ReadBuffers, %d with two slices", calls, wrapped)
+	if !bytes.Equal(out, txt) {
+		t.Fatalf("ReadBuffers data differs from Read data")
+	}
+
+	c.MaxDecompressedSize = 10000
+	r, err = c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out = out[:0]
+	for {
+		bufs, err := r.ReadBuffers()
+		for _, p := range bufs {
+			out = append(out, p...)
+		}
+		if err == ErrSizeLimit {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadBuffers error %v", err)
+		}
+	}
+	if !bytes.Equal(out, txt[:c.MaxDecompressedSize]) {
+		t.Fatalf("got %d bytes with size limit; want %d", len(out),
+			c.MaxDecompressedSize)
+	}
+}
+
+func TestReaderMaxProperties(t *testing.T) {
+	props := Properties{LC: maxLC, LP: maxLP, PB: maxPB}
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(23)), 30000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	data := compressWithConfig(t, WriterConfig{Properties: &props}, txt)
+	if data[0] != 224 {
+		t.Fatalf("properties byte %d; want %d", data[0], 224)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p := r.Parameters()
+	if p.LC != props.LC || p.LP != props.LP || p.PB != props.PB {
+		t.Fatalf("parameters %+v; want properties %v", p, &props)
+	}
+	if n := len(r.d.State.litCodec.probs); n != 0x300<<12 {
+		t.Fatalf("literal codec has %d probabilities; want %d", n,
+			0x300<<12)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	a := &Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 20, Size: 10,
+		SizeInHeader: true}
+	tests := []struct {
+		b    *Parameters
+		want bool
+	}{
+		{&Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 20,
+			Size: -1, EOS: true}, true},
+		{&Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 20}, true},
+		{&Parameters{LC: 4, LP: 0, PB: 2, DictSize: 1 << 20}, false},
+		{&Parameters{LC: 3, LP: 1, PB: 2, DictSize: 1 << 20}, false},
+		{&Parameters{LC: 3, LP: 0, PB: 0, DictSize: 1 << 20}, false},
+		{&Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 21}, false},
+		{nil, false},
+	}
+	for _, tc := range tests {
+		if got := Compatible(a, tc.b); got != tc.want {
+			t.Errorf("Compatible(%+v, %+v) returned %t; want %t",
+				a, tc.b, got, tc.want)
+		}
+		if got := Compatible(tc.b, a); got != tc.want {
+			t.Errorf("Compatible(%+v, %+v) returned %t; want %t",
+				tc.b, a, got, tc.want)
+		}
+	}
+}
+
+func TestNewReaderParams(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  WriterConfig
+	}{
+		{"eos", WriterConfig{}},
+		{"size", WriterConfig{Size: int64(len(testString))}},
+		{"props", WriterConfig{
+			Properties: &Properties{LC: 0, LP: 2, PB: 0},
+			DictCap:    1 << 16,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.NoHeader = true
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = io.WriteString(w, testString); err != nil {
+				t.Fatalf("WriteString error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			if err = cfg.Verify(); err != nil {
+				t.Fatalf("cfg.Verify error %s", err)
+			}
+			p := &Parameters{
+				LC:           cfg.Properties.LC,
+				LP:           cfg.Properties.LP,
+				PB:           cfg.Properties.PB,
+				DictSize:     cfg.DictCap,
+				Size:         -1,
+				SizeInHeader: cfg.SizeInHeader,
+				EOS:          cfg.EOSMarker,
+			}
+			if cfg.SizeInHeader {
+				p.Size = cfg.Size
+			}
+			r, err := NewReaderParams(&buf, p)
+			if err != nil {
+				t.Fatalf("NewReaderParams error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if string(got) != testString {
+				t.Fatalf("got %q; want %q", got, testString)
+			}
+			if q := r.Parameters(); !Compatible(p, q) {
+				t.Fatalf("Parameters() returned %+v; want %+v",
+					q, p)
+			}
+		})
+	}
+
+	if _, err := NewReaderParams(bytes.NewReader(nil),
+		&Parameters{LC: 9}); err == nil {
+		t.Fatalf("NewReaderParams accepted LC 9")
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(136)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	stream := buf.Bytes()
+	for _, n := range []int{HeaderLen + 100, len(stream) / 2,
+		len(stream) - 1} {
+		r, err := NewReader(bytes.NewReader(stream[:n]))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("truncated at %d: ReadAll error %v; want %v",
+				n, err, io.ErrUnexpectedEOF)
+		}
+		if len(got) == 0 {
+			t.Fatalf("truncated at %d: no data recovered", n)
+		}
+		if !bytes.Equal(got, data[:len(got)]) {
+			t.Fatalf("truncated at %d: recovered data isn't a"+
+				" prefix of the original", n)
+		}
+		t.Logf("truncated at %d of %d: recovered %d of %d bytes",
+			n, len(stream), len(got), len(data))
+		// The error must be returned again.
+		if k, err := r.Read(make([]byte, 1)); k != 0 ||
+			err != io.ErrUnexpectedEOF {
+			t.Fatalf("Read after error returned %d, %v", k, err)
+		}
+	}
+}
+
+// TestReaderSizeBoundary checks streams with a size in the header,
+// whose underlying reader returns io.EOF together with the last byte
+// of the stream, one byte early or one byte late.
+func TestReaderSizeBoundary(t *testing.T) {
+	texts := []string{"a", "abcabcabcabc", testString}
+	for _, text := range texts {
+		for _, eos := range []bool{false, true} {
+			var buf bytes.Buffer
+			w, err := WriterConfig{
+				Size:      int64(len(text)),
+				EOSMarker: eos,
+			}.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = io.WriteString(w, text); err != nil {
+				t.Fatalf("WriteString error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			stream := buf.Bytes()
+			tests := []struct {
+				name string
+				data []byte
+				err  error
+			}{
+				{"size-1", stream[:len(stream)-1],
+					io.ErrUnexpectedEOF},
+				{"size", stream, nil},
+				{"size+1", append(stream[:len(stream):len(stream)], 0),
+					nil},
+			}
+			for _, tc := range tests {
+				r, err := NewReader(iotest.DataErrReader(
+					iotest.OneByteReader(
+						bytes.NewReader(tc.data))))
+				if err != nil {
+					t.Fatalf("NewReader error %s", err)
+				}
+				got, err := ioutil.ReadAll(r)
+				if err != tc.err {
+					t.Fatalf("len %d eos %t %s: ReadAll error"+
+						" %v; want %v", len(text), eos,
+						tc.name, err, tc.err)
+				}
+				if err == nil && string(got) != text {
+					t.Fatalf("len %d eos %t %s: got %q;"+
+						" want %q", len(text), eos,
+						tc.name, got, text)
+				}
+			}
+		}
+	}
+}
+
+func TestReaderComplete(t *testing.T) {
+	text := []byte(testString)
+	tests := []struct {
+		name string
+		cfg  WriterConfig
+	}{
+		{"eos", WriterConfig{}},
+		{"size", WriterConfig{Size: int64(len(text))}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := compressWithConfig(t, tc.cfg, text)
+
+			r, err := NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			if r.Complete() {
+				t.Fatalf("Complete returned true before reading")
+			}
+			p := make([]byte, 10)
+			if _, err = io.ReadFull(r, p); err != nil {
+				t.Fatalf("ReadFull error %s", err)
+			}
+			if r.Complete() {
+				t.Fatalf("Complete returned true after" +
+					" partial read")
+			}
+			if _, err = ioutil.ReadAll(r); err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !r.Complete() {
+				t.Fatalf("Complete returned false after" +
+					" reading the stream")
+			}
+
+			r, err = NewReader(bytes.NewReader(
+				stream[:len(stream)-2]))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			if _, err = ioutil.ReadAll(r); err == nil {
+				t.Fatalf("ReadAll of truncated stream" +
+					" returned no error")
+			}
+			if r.Complete() {
+				t.Fatalf("Complete returned true for" +
+					" truncated stream")
+			}
+		})
+	}
+}
+
+// TestParametersShared uses the same Parameters and Properties values
+// in multiple goroutines. Run it with the race detector.
+func TestParametersShared(t *testing.T) {
+	text := []byte(testString)
+	props := &Properties{LC: 3, LP: 0, PB: 2}
+	params := &Parameters{LC: 3, LP: 0, PB: 2, DictSize: 1 << 16,
+		Size: -1, EOS: true}
+	want := *params
+	src := compressWithConfig(t, WriterConfig{Properties: props}, text)
+	raw := compressWithConfig(t, WriterConfig{
+		Properties: props,
+		DictCap:    params.DictSize,
+		NoHeader:   true,
+	}, text)
+
+	const n = 16
+	errs := make(chan error, 2*n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := Transcode(ioutil.Discard, params,
+				bytes.NewReader(src))
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			r, err := NewReaderParams(bytes.NewReader(raw), params)
+			if err == nil {
+				_, err = ioutil.ReadAll(r)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine error %s", err)
+		}
+	}
+	if *params != want {
+		t.Fatalf("parameters modified to %+v; want %+v", *params, want)
+	}
+	if *props != (Properties{LC: 3, LP: 0, PB: 2}) {
+		t.Fatalf("properties modified to %+v", *props)
+	}
+}
+
+func TestReaderLC0(t *testing.T) {
+	// stream created by xz with lc=0, lp=0 and pb=0
+	data, err := ioutil.ReadFile(filepath.Join(dirname,
+		"a_lc0_lp0_pb0.lzma"))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p := r.Parameters(); p.LC != 0 || p.LP != 0 || p.PB != 0 {
+		t.Fatalf("got LC %d, LP %d, PB %d; want all zero",
+			p.LC, p.LP, p.PB)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, readOrigFile(t)) {
+		t.Fatalf("decoded data differs from original")
+	}
+
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(153)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	for _, props := range []Properties{
+		{LC: 0, LP: 0, PB: 0},
+		{LC: 0, LP: 0, PB: 2},
+		{LC: 0, LP: 4, PB: 4},
+	} {
+		props := props
+		stream := compressWithConfig(t,
+			WriterConfig{Properties: &props}, txt)
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%+v: NewReader error %s", props, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%+v: ReadAll error %s", props, err)
+		}
+		if !bytes.Equal(got, txt) {
+			t.Fatalf("%+v: decompressed data differs", props)
+		}
+	}
+}
+
+func TestReaderReason(t *testing.T) {
+	text := []byte(testString)
+	size := int64(len(text))
+	noEOS := compressWithConfig(t, WriterConfig{Size: size}, text)
+	// remove the size from the header
+	noEOS = append([]byte{}, noEOS...)
+	putUint64LE(noEOS[5:13], noHeaderSize)
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   EndReason
+	}{
+		{"eos", compressWithConfig(t, WriterConfig{}, text), EndByEOS},
+		{"size", compressWithConfig(t, WriterConfig{Size: size}, text),
+			EndBySize},
+		{"sizeAndEOS", compressWithConfig(t, WriterConfig{
+			Size:      size,
+			EOSMarker: true,
+		}, text), EndByEOS},
+		{"underlyingEOF", noEOS, EndByUnderlyingEOF},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := ReaderConfig{AllowNoEOS: true}.NewReader(
+				bytes.NewReader(tc.stream))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			p := make([]byte, 10)
+			if _, err = io.ReadFull(r, p); err != nil {
+				t.Fatalf("ReadFull error %s", err)
+			}
+			if reason := r.Reason(); reason != EndNone {
+				t.Fatalf("Reason returned %v before the end",
+					reason)
+			}
+			if _, err = ioutil.ReadAll(r); err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if reason := r.Reason(); reason != tc.want {
+				t.Fatalf("Reason returned %v; want %v",
+					reason, tc.want)
+			}
+
+			r, err = ReaderConfig{AllowNoEOS: true}.NewReader(
+				bytes.NewReader(tc.stream[:len(tc.stream)-3]))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			if _, err = ioutil.ReadAll(r); err == nil {
+				t.Fatalf("ReadAll of truncated stream" +
+					" returned no error")
+			}
+			if reason := r.Reason(); reason != EndNone {
+				t.Fatalf("Reason returned %v for truncated"+
+					" stream", reason)
+			}
+		})
+	}
+}
+
+func TestReaderSizeMismatch(t *testing.T) {
+	data := []byte(testString)
+	n := int64(len(data))
+	tests := []struct {
+		name     string
+		eos      bool
+		declared int64
+	}{
+		{"shorter", true, n + 10},
+		{"longer", true, n - 10},
+		{"longerNoEOS", false, n - 10},
+	}
+	for _, tc := range tests {
+		z := compressWithConfig(t,
+			WriterConfig{Size: n, EOSMarker: tc.eos}, data)
+		putUint64LE(z[5:], uint64(tc.declared))
+		r, err := NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		_, err = ioutil.ReadAll(r)
+		var e *SizeMismatchError
+		if !errors.As(err, &e) {
+			t.Fatalf("%s: ReadAll returned %v; want SizeMismatchError",
+				tc.name, err)
+		}
+		if e.Declared != tc.declared {
+			t.Fatalf("%s: Declared %d; want %d", tc.name,
+				e.Declared, tc.declared)
+		}
+		if tc.declared > n {
+			if e.Actual != n {
+				t.Fatalf("%s: Actual %d; want %d", tc.name,
+					e.Actual, n)
+			}
+		} else if e.Actual <= e.Declared {
+			t.Fatalf("%s: Actual %d; want more than %d", tc.name,
+				e.Actual, e.Declared)
+		}
+		t.Logf("%s: %s", tc.name, err)
+	}
+}
+
+func TestReaderDictBuffer(t *testing.T) {
+	const dictCap = 1 << 20
+	data := []byte(testString)
+	z := compressWithConfig(t, WriterConfig{DictCap: dictCap}, data)
+	dictBuf := make([]byte, dictCap+1)
+	out := make([]byte, len(data)+1)
+	br := bytes.NewReader(z)
+	decode := func() {
+		br.Reset(z)
+		c := ReaderConfig{DictBuffer: dictBuf}
+		r, err := c.NewReader(br)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		n, err := io.ReadFull(r, out)
+		if err != io.ErrUnexpectedEOF || n != len(data) {
+			t.Fatalf("ReadFull returned %d, %v; want %d, %v",
+				n, err, len(data), io.ErrUnexpectedEOF)
+		}
+		if !bytes.Equal(out[:n], data) {
+			t.Fatalf("decoded data differs")
+		}
+		if err = r.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+	}
+	decode()
+	const runs = 50
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < runs; i++ {
+		decode()
+	}
+	runtime.ReadMemStats(&after)
+	// The dictionary must not be allocated; only the state of the
+	// reader and the decoder remains.
+	perRun := (after.TotalAlloc - before.TotalAlloc) / runs
+	t.Logf("%d bytes allocated per decode", perRun)
+	if perRun >= dictCap/16 {
+		t.Fatalf("%d bytes allocated per decode; dictionary buffer"+
+			" not reused", perRun)
+	}
+
+	c := ReaderConfig{DictBuffer: make([]byte, dictCap)}
+	if _, err := c.NewReader(bytes.NewReader(z)); err == nil {
+		t.Fatalf("NewReader accepted a too small DictBuffer")
+	}
+}
+
+// nonZero counts the bytes of p that are not zero.
+func nonZero(p []byte) int {
+	var n int
+	for _, c := range p {
+		if c != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReaderWipeOnReset(t *testing.T) {
+	data := []byte(testString)
+	z := compressWithConfig(t, WriterConfig{DictCap: MinDictCap}, data)
+	for _, wipe := range []bool{false, true} {
+		dictBuf := make([]byte, MinDictCap+1)
+		c := ReaderConfig{DictBuffer: dictBuf, WipeOnReset: wipe}
+		r, err := c.NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("decoded data differs")
+		}
+		r.Window()
+		if err = r.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		n := nonZero(dictBuf) + nonZero(r.scratch)
+		if wipe && n != 0 {
+			t.Fatalf("%d bytes not wiped by Close", n)
+		}
+		if !wipe && n == 0 {
+			t.Fatalf("dictionary buffer empty without wipe")
+		}
+	}
+}
+
+func TestReaderMaxWorkUnits(t *testing.T) {
+	data := append(bytes.Repeat([]byte("abc"), 100000), testString...)
+	z := compressWithConfig(t, WriterConfig{}, data)
+	ops, _ := decodeOps(t, z)
+	var work int64
+	for _, op := range ops {
+		work++
+		if m, ok := op.(match); ok {
+			work += int64(m.n)
+		}
+	}
+	t.Logf("%d operations; %d work units", len(ops), work)
+	read := func(budget int64) ([]byte, error) {
+		r, err := ReaderConfig{MaxWorkUnits: budget}.NewReader(
+			bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		return ioutil.ReadAll(r)
+	}
+	p, err := read(work)
+	if err != nil {
+		t.Fatalf("ReadAll with budget %d error %s", work, err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+	for _, budget := range []int64{1, work / 2, work - 1} {
+		p, err = read(budget)
+		if err != ErrBudgetExceeded {
+			t.Fatalf("ReadAll with budget %d returned %v; want %v",
+				budget, err, ErrBudgetExceeded)
+		}
+		if int64(len(p)) > budget || !bytes.Equal(p, data[:len(p)]) {
+			t.Fatalf("budget %d: returned %d bytes", budget,
+				len(p))
+		}
+	}
+	if _, err = (ReaderConfig{MaxWorkUnits: -1}).NewReader(
+		bytes.NewReader(z)); err == nil {
+		t.Fatalf("NewReader accepted negative MaxWorkUnits")
+	}
+}
+
+func TestReaderRemaining(t *testing.T) {
+	text := []byte(testString)
+	size := int64(len(text))
+	z := compressWithConfig(t, WriterConfig{Size: size}, text)
+	r, err := NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if n := r.Remaining(); n != size {
+		t.Fatalf("Remaining returned %d at start; want %d", n, size)
+	}
+	p := make([]byte, 7)
+	var total int64
+	for {
+		k, err := r.Read(p)
+		total += int64(k)
+		if n := r.Remaining(); n != size-total {
+			t.Fatalf("Remaining returned %d after %d bytes; want %d",
+				n, total, size-total)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read error %s", err)
+		}
+	}
+	if n := r.Remaining(); n != 0 {
+		t.Fatalf("Remaining returned %d at end; want 0", n)
+	}
+
+	r, err = NewReader(bytes.NewReader(
+		compressWithConfig(t, WriterConfig{}, text)))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if n := r.Remaining(); n != -1 {
+		t.Fatalf("Remaining returned %d for EOS stream; want -1", n)
+	}
+}
+
+func TestReaderClampDictCap(t *testing.T) {
+	block := make([]byte, 8192)
+	rand.New(rand.NewSource(192)).Read(block)
+	tests := []struct {
+		name    string
+		data    []byte
+		corrupt bool
+	}{
+		{"text", []byte(testString), false},
+		{"farMatches", append(append([]byte{}, block...), block...),
+			true},
+	}
+	for _, tc := range tests {
+		z := compressWithConfig(t, WriterConfig{DictCap: 1 << 16},
+			tc.data)
+		// declare a dictionary of 1 GiB, which is still
+		// representable on 32-bit platforms
+		putUint32LE(z[1:5], 1<<30)
+		c := ReaderConfig{DictCap: MinDictCap, ClampDictCap: MinDictCap}
+		r, err := c.NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		if d := r.Parameters().DictSize; d != MinDictCap {
+			t.Fatalf("%s: DictSize %d; want %d", tc.name, d,
+				MinDictCap)
+		}
+		got, err := ioutil.ReadAll(r)
+		if tc.corrupt {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("%s: ReadAll returned %v; want"+
+					" corrupt input error", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.name, err)
+		}
+		if !bytes.Equal(got, tc.data) {
+			t.Fatalf("%s: decompressed data differs", tc.name)
+		}
+	}
+	c := ReaderConfig{ClampDictCap: MinDictCap - 1}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted ClampDictCap %d", c.ClampDictCap)
+	}
+}
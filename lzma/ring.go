@@ -0,0 +1,106 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"io"
+)
+
+// RingReader decodes a stream into a ring buffer of fixed size and keeps
+// only the most recent output. Older output is overwritten, so the
+// memory used stays bounded however long the stream is, which supports
+// tailing compressed logs. The ring is separate from the dictionary of
+// the decoder: the underlying reader, usually a Reader or Reader2,
+// still decodes every byte of the stream.
+type RingReader struct {
+	r    io.Reader
+	ring []byte
+	// index of the next byte to write into the ring
+	pos int
+	// total number of bytes decoded
+	n   int64
+	err error
+	// scratch buffer for Window
+	scratch []byte
+}
+
+// NewRingReader creates a ring reader that keeps the last size bytes
+// read from r.
+func NewRingReader(r io.Reader, size int) (*RingReader, error) {
+	if size <= 0 {
+		return nil, errors.New("lzma: ring size must be positive")
+	}
+	return &RingReader{r: r, ring: make([]byte, size)}, nil
+}
+
+// Fill reads up to n bytes from the underlying reader into the ring. It
+// returns the number of bytes read and io.EOF at the end of the
+// stream. Other errors of the underlying reader are returned as well
+// and are sticky.
+func (rr *RingReader) Fill(n int) (k int, err error) {
+	if rr.err != nil {
+		return 0, rr.err
+	}
+	for k < n {
+		p := rr.ring[rr.pos:]
+		if len(p) > n-k {
+			p = p[:n-k]
+		}
+		var m int
+		m, err = rr.r.Read(p)
+		k += m
+		rr.n += int64(m)
+		rr.pos += m
+		if rr.pos == len(rr.ring) {
+			rr.pos = 0
+		}
+		if err != nil {
+			rr.err = err
+			return k, err
+		}
+	}
+	return k, nil
+}
+
+// Drain reads the underlying reader until the end of the stream. It
+// returns the number of bytes read. In contrast to Fill the end of the
+// stream is not reported as an error.
+func (rr *RingReader) Drain() (n int64, err error) {
+	const step = 1 << 16
+	for {
+		k, err := rr.Fill(step)
+		n += int64(k)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Total returns the number of bytes decoded so far, including the bytes
+// that have been dropped from the ring.
+func (rr *RingReader) Total() int64 {
+	return rr.n
+}
+
+// Window returns the most recent output in order. It holds min(n, s)
+// bytes, where n is the number of bytes decoded so far and s the size
+// of the ring. The returned slice must not be modified and is valid
+// only until the next call of Fill, Drain or Window.
+func (rr *RingReader) Window() []byte {
+	if rr.n < int64(len(rr.ring)) {
+		return rr.ring[:rr.pos]
+	}
+	if rr.pos == 0 {
+		return rr.ring
+	}
+	p := append(rr.scratch[:0], rr.ring[rr.pos:]...)
+	p = append(p, rr.ring[:rr.pos]...)
+	rr.scratch = p
+	return p
+}
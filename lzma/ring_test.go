@@ -0,0 +1,72 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestRingReader(t *testing.T) {
+	const size = 3 << 20
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(21)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	z := compressWithConfig(t, WriterConfig{DictCap: 1 << 20}, data)
+	for _, ringSize := range []int{1, 1000, 1 << 16, size + 10} {
+		r, err := NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		rr, err := NewRingReader(r, ringSize)
+		if err != nil {
+			t.Fatalf("NewRingReader error %s", err)
+		}
+		// irregular steps wrap the ring at different positions
+		for _, step := range []int{7, 5000, 123457} {
+			if _, err = rr.Fill(step); err != nil {
+				t.Fatalf("Fill(%d) error %s", step, err)
+			}
+			checkRingWindow(t, rr, data, ringSize)
+		}
+		n, err := rr.Drain()
+		if err != nil {
+			t.Fatalf("Drain error %s", err)
+		}
+		if rr.Total() != size || n != size-(7+5000+123457) {
+			t.Fatalf("Drain returned %d; Total %d", n, rr.Total())
+		}
+		checkRingWindow(t, rr, data, ringSize)
+		if _, err = rr.Fill(1); err != io.EOF {
+			t.Fatalf("Fill after end returned %v; want %v",
+				err, io.EOF)
+		}
+	}
+	if _, err := NewRingReader(bytes.NewReader(nil), 0); err == nil {
+		t.Fatalf("NewRingReader accepted size 0")
+	}
+}
+
+// checkRingWindow checks that the window of the ring reader contains
+// the last bytes of the data decoded so far.
+func checkRingWindow(t *testing.T, rr *RingReader, data []byte, size int) {
+	t.Helper()
+	total := rr.Total()
+	start := total - int64(size)
+	if start < 0 {
+		start = 0
+	}
+	if w := rr.Window(); !bytes.Equal(w, data[start:total]) {
+		t.Fatalf("ring size %d: window of %d bytes differs after %d"+
+			" bytes", size, len(w), total)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"io"
+)
+
+// Transcode decodes the LZMA stream in the classic format from src and
+// encodes the uncompressed data with the parameters dstParams into dst.
+// The uncompressed data is never written anywhere else; the slices of
+// the decoder dictionary are passed directly to the encoder, so no
+// additional buffer is required. The function returns the number of
+// uncompressed bytes.
+//
+// If dstParams is nil, the default writer configuration is used. A
+// zero DictSize selects the default dictionary capacity. If
+// SizeInHeader is set with a negative Size, the size of the source
+// stream is used, which must then be given by its header.
+func Transcode(dst io.Writer, dstParams *Parameters, src io.Reader) (
+	n int64, err error) {
+	r, err := NewReader(src)
+	if err != nil {
+		return 0, err
+	}
+	var c WriterConfig
+	if dstParams != nil {
+		c = WriterConfig{
+			Properties: &Properties{
+				LC: dstParams.LC,
+				LP: dstParams.LP,
+				PB: dstParams.PB,
+			},
+			DictCap:      dstParams.DictSize,
+			SizeInHeader: dstParams.SizeInHeader,
+			Size:         dstParams.Size,
+			EOSMarker:    dstParams.EOS,
+		}
+		if c.SizeInHeader && c.Size < 0 {
+			if c.Size = r.h.size; c.Size < 0 {
+				return 0, errors.New(
+					"lzma: source stream has no size")
+			}
+		}
+		if !c.SizeInHeader && c.Size < 0 {
+			c.Size = 0
+		}
+	}
+	w, err := c.NewWriter(dst)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		bufs, rerr := r.ReadBuffers()
+		for _, p := range bufs {
+			k, err := w.Write(p)
+			n += int64(k)
+			if err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+	if err = w.Close(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestTranscode(t *testing.T) {
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(143)), 300000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	// data compressed with the fastest preset level
+	src := compressWithConfig(t, Presets()[0], data)
+
+	tests := []struct {
+		name   string
+		params *Parameters
+	}{
+		{"default", nil},
+		{"level9", &Parameters{LC: 3, LP: 0, PB: 2,
+			DictSize: Presets()[9].DictCap, Size: -1, EOS: true}},
+		{"props", &Parameters{LC: 0, LP: 2, PB: 0,
+			DictSize: 1 << 16, Size: -1, EOS: true}},
+		{"size", &Parameters{LC: 3, LP: 0, PB: 2,
+			DictSize: 1 << 20, Size: int64(len(data)),
+			SizeInHeader: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := Transcode(&buf, tc.params,
+				bytes.NewReader(src))
+			if err != nil {
+				t.Fatalf("Transcode error %s", err)
+			}
+			if n != int64(len(data)) {
+				t.Fatalf("Transcode returned %d; want %d",
+					n, len(data))
+			}
+			t.Logf("source %d bytes; transcoded %d bytes",
+				len(src), buf.Len())
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			p := r.Parameters()
+			if tc.params != nil {
+				if !Compatible(p, tc.params) {
+					t.Fatalf("got parameters %+v; want %+v",
+						p, tc.params)
+				}
+				if tc.params.SizeInHeader &&
+					p.Size != int64(len(data)) {
+					t.Fatalf("got size %d; want %d",
+						p.Size, len(data))
+				}
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("transcoded data differs")
+			}
+		})
+	}
+
+	// The size is taken from the source header.
+	sized := compressWithConfig(t, WriterConfig{Size: int64(len(data))},
+		data)
+	var buf bytes.Buffer
+	params := &Parameters{LC: 3, PB: 2, Size: -1, SizeInHeader: true}
+	if _, err = Transcode(&buf, params, bytes.NewReader(sized)); err != nil {
+		t.Fatalf("Transcode error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p := r.Parameters(); p.Size != int64(len(data)) {
+		t.Fatalf("got size %d; want %d", p.Size, len(data))
+	}
+	if params.Size != -1 {
+		t.Fatalf("Transcode modified the parameters")
+	}
+	// The source has no size in its header.
+	_, err = Transcode(ioutil.Discard, params, bytes.NewReader(src))
+	if err == nil {
+		t.Fatalf("Transcode without source size returned no error")
+	}
+}
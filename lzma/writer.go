@@ -6,7 +6,9 @@ package lzma
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -31,15 +33,130 @@ type WriterConfig struct {
 	// Match algorithm
 	Matcher MatchAlgorithm
 	// SizeInHeader indicates that the header will contain an
-	// explicit size.
+	// explicit size. If it is false, the size is unknown, the header
+	// stores the value for an unknown size and the EOS marker will be
+	// written. Set SizeInHeader with a zero Size to describe empty
+	// data of known size.
 	SizeInHeader bool
 	// Size of the data to be encoded. A positive value will imply
-	// than an explicit size will be set in the header.
+	// than an explicit size will be set in the header. A negative
+	// value is rejected if SizeInHeader is set.
 	Size int64
 	// EOSMarker requests whether the EOSMarker needs to be written.
 	// If no explicit size is been given the EOSMarker will be
-	// set automatically.
+	// set automatically. Setting it together with SizeInHeader
+	// forces the marker after the data of known size, which is
+	// accepted by all decoders.
 	EOSMarker bool
+	// OptimalParse enables a look-ahead parsing, which checks
+	// whether a literal followed by the match at the next position
+	// is estimated to be cheaper than a short match found at the
+	// current position. It usually improves the compression ratio
+	// slightly at the cost of speed. The default is the faster
+	// greedy parsing.
+	OptimalParse bool
+	// GreedyMatch makes the HashTable4 matcher check fewer
+	// positions and accept the first long match, trading
+	// compression ratio for speed. It excludes OptimalParse.
+	GreedyMatch bool
+	// PresetDict provides data that is used to initialize the
+	// dictionary before encoding starts. Only the last DictCap
+	// bytes are used. The reader must be configured with the same
+	// preset dictionary.
+	PresetDict []byte
+	// CanonicalDictCap requests that DictCap must be one of the
+	// canonical dictionary capacities 2^n or 2^n + 2^(n-1) used by
+	// the xz format, which are supported by all decoders. Verify
+	// returns an error for other values.
+	CanonicalDictCap bool
+	// InitialReps sets the initial values of the repeated distances
+	// rep0 to rep3. As in the LZMA stream the values are distances
+	// minus one. The zero value provides the standard initialization
+	// with distance 1 for all slots. This is an advanced feature,
+	// which may improve the compression together with PresetDict.
+	// The reader must be configured with the same values, otherwise
+	// decoding diverges.
+	InitialReps [4]uint32
+	// NoHeader suppresses the header of the classic format. Such
+	// raw streams can be read using NewReaderParams with the
+	// parameters of this configuration.
+	NoHeader bool
+	// AutoDictSize selects the dictionary capacity from the size of
+	// the data if SizeInHeader is set. The smallest canonical
+	// capacity not less than the size is used, but at least
+	// MinDictCap. The DictCap value is only used if the size is
+	// unknown.
+	AutoDictSize bool
+	// RawSink receives a copy of all compressed bytes written to the
+	// underlying writer including the header. It may be nil. An
+	// error returned by RawSink is reported by the Writer method
+	// that caused it.
+	RawSink io.Writer
+	// Allocator provides the memory for the dictionary buffer. The
+	// buffer is returned to the allocator by Close. If Allocator is
+	// nil the buffer is allocated with make.
+	Allocator BufferAllocator
+	// MaxWorkPerCall limits the number of bytes a single Write or
+	// WriteString call accepts. This opt-in option bounds the
+	// compression work done per call, so a cooperative scheduler
+	// gets control back regularly. Write returns then n < len(p)
+	// WITHOUT an error and the caller must write the rest in
+	// further calls. This violates the io.Writer contract; the
+	// Writer must not be passed to io.Copy or other functions
+	// expecting an io.Writer. The zero value means no limit.
+	MaxWorkPerCall int
+	// MatchFinderDictCap limits the dictionary capacity used by the
+	// encoder and its match finder, while the header still declares
+	// DictCap. The encoder searches matches only in the last
+	// MatchFinderDictCap bytes, which reduces the memory of the
+	// encoder at the cost of the compression ratio. The stream
+	// remains decodable with DictCap. The zero value selects
+	// DictCap; larger values are an error.
+	MatchFinderDictCap int
+	// SmallInputBufferLimit enables the buffering of small inputs
+	// of unknown size. If SizeInHeader is false, the Writer holds
+	// back up to SmallInputBufferLimit bytes. If Close is called
+	// before the limit is exceeded, the stream is written with the
+	// exact size in the header, which is supported by all decoders,
+	// and the EOS marker is only written if EOSMarker is set. Larger
+	// inputs are streamed with an unknown size and the EOS marker.
+	// Nothing is written to the underlying writer before the
+	// decision has been made. The option requires the header. The
+	// zero value disables buffering.
+	SmallInputBufferLimit int
+	// MinMatch sets the minimum length of the matches the encoder
+	// uses. Shorter matches are encoded as literals, which may
+	// improve the compression ratio for data where short matches
+	// over large distances are more expensive than literals. The
+	// encoder has to search for matches at more positions, so it
+	// may become slower. Values below the LZMA minimum of 2,
+	// including the zero value, select 2. The option only affects
+	// the choices of the encoder; the stream can be decoded by
+	// every decoder.
+	MinMatch int
+	// OpTrace receives a line for every operation written by the
+	// encoder, which supports the debugging of the encoder:
+	//
+	//	LIT 61        literal as hexadecimal byte value
+	//	MATCH 5 1024  match with new distance; length, distance
+	//	REP 0 3       match with the repeated distance rep0 to
+	//	              rep3; slot, length
+	//	EOS           end-of-stream marker
+	//
+	// Distances are actual distances, not the values stored in the
+	// stream. A short rep is written as REP 0 1. An error returned
+	// by OpTrace is reported by the Writer method that caused it.
+	// If OpTrace is nil, the default, no trace is written.
+	OpTrace io.Writer
+	// MaxCompressedSize limits the number of bytes written to the
+	// underlying writer including the header. The Writer methods
+	// return ErrOutputTooLarge as soon as the compressed data would
+	// exceed the limit, so the caller can store the data otherwise
+	// without compressing all of it. The compressed data is buffered
+	// in small amounts, so the error is detected a few KiB of input
+	// late. The output written until then doesn't exceed the limit
+	// but is not a valid stream. The zero value means no limit.
+	MaxCompressedSize int64
 }
 
 // fill converts zero-value fields to their explicit default values.
@@ -47,20 +164,52 @@ func (c *WriterConfig) fill() {
 	if c.Properties == nil {
 		c.Properties = &Properties{LC: 3, LP: 0, PB: 2}
 	}
+	if c.Size > 0 {
+		c.SizeInHeader = true
+	}
+	if c.AutoDictSize && c.SizeInHeader && c.Size >= 0 {
+		c.DictCap = autoDictCap(c.Size)
+	}
 	if c.DictCap == 0 {
 		c.DictCap = 8 * 1024 * 1024
 	}
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
-	if c.Size > 0 {
-		c.SizeInHeader = true
+	if c.MatchFinderDictCap == 0 {
+		c.MatchFinderDictCap = c.DictCap
 	}
 	if !c.SizeInHeader {
 		c.EOSMarker = true
 	}
 }
 
+// maxInt is the largest value of the int type on the platform.
+const maxInt = int64(^uint(0) >> 1)
+
+// autoDictCap returns the smallest canonical dictionary capacity that
+// is not less than size. The result is in the range
+// [MinDictCap,MaxDictCap] and limited to the largest canonical
+// capacity that fits into an int on the platform.
+func autoDictCap(size int64) int {
+	if size < MinDictCap {
+		return MinDictCap
+	}
+	c := EncodeDictCap(size)
+	for {
+		n, err := DecodeDictCap(c)
+		if err != nil {
+			panic(fmt.Errorf(
+				"lzma: no dictionary capacity for size %d",
+				size))
+		}
+		if n <= maxInt {
+			return int(n)
+		}
+		c--
+	}
+}
+
 // Verify checks WriterConfig for errors. Verify will replace zero
 // values with default values.
 func (c *WriterConfig) Verify() error {
@@ -78,6 +227,22 @@ func (c *WriterConfig) Verify() error {
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
 		return errors.New("lzma: dictionary capacity is out of range")
 	}
+	if c.CanonicalDictCap {
+		if err = verifyCanonicalDictCap(c.DictCap); err != nil {
+			return err
+		}
+	}
+	if !(MinDictCap <= c.MatchFinderDictCap &&
+		c.MatchFinderDictCap <= c.DictCap) {
+		return errors.New(
+			"lzma: match finder dictionary capacity out of range")
+	}
+	for _, r := range c.InitialReps {
+		if int64(r) >= int64(c.MatchFinderDictCap) {
+			return errors.New(
+				"lzma: initial rep distance exceeds dictionary capacity")
+		}
+	}
 	if !(maxMatchLen <= c.BufSize) {
 		return errors.New("lzma: lookahead buffer size too small")
 	}
@@ -91,10 +256,57 @@ func (c *WriterConfig) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if c.MaxCompressedSize < 0 {
+		return errors.New("lzma: negative MaxCompressedSize")
+	}
+	if c.MaxWorkPerCall < 0 {
+		return errors.New("lzma: negative MaxWorkPerCall")
+	}
+	if c.GreedyMatch && c.OptimalParse {
+		return errors.New(
+			"lzma: GreedyMatch can't be combined with OptimalParse")
+	}
+	if c.MinMatch > maxMatchLen {
+		return errors.New("lzma: MinMatch exceeds maximum match length")
+	}
+	if c.SmallInputBufferLimit < 0 {
+		return errors.New("lzma: negative SmallInputBufferLimit")
+	}
+	if c.SmallInputBufferLimit > 0 && c.NoHeader {
+		return errors.New(
+			"lzma: SmallInputBufferLimit requires the header")
+	}
 
 	return nil
 }
 
+// verifyCanonicalDictCap checks whether n is a canonical dictionary
+// capacity. The error returned for other values lists the nearest
+// canonical capacities.
+func verifyCanonicalDictCap(n int) error {
+	c := EncodeDictCap(int64(n))
+	upper, err := DecodeDictCap(c)
+	if err != nil {
+		return err
+	}
+	if upper == int64(n) {
+		return nil
+	}
+	if c == 0 {
+		return fmt.Errorf(
+			"lzma: dictionary capacity %d isn't canonical;"+
+				" nearest canonical value is %d", n, upper)
+	}
+	lower, err := DecodeDictCap(c - 1)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf(
+		"lzma: dictionary capacity %d isn't canonical;"+
+			" nearest canonical values are %d and %d",
+		n, lower, upper)
+}
+
 // header returns the header structure for this configuration.
 func (c *WriterConfig) header() header {
 	h := header{
@@ -111,52 +323,143 @@ func (c *WriterConfig) header() header {
 // Writer writes an LZMA stream in the classic format.
 type Writer struct {
 	h   header
+	cw  countingWriter
 	bw  io.ByteWriter
 	buf *bufio.Writer
 	e   *encoder
+	// allocator of the dictionary buffer
+	alloc  BufferAllocator
+	closed bool
+	// maximum number of bytes accepted per call; zero means no
+	// limit
+	maxWork int
+	// eager requests compression of the data with every write, so
+	// that exceeding MaxCompressedSize is detected early
+	eager bool
+	// pending stores the original configuration while small input
+	// is buffered; the encoder is created by start
+	pending *WriterConfig
+	small   []byte
+	out     io.Writer
+	// barriers set while small input is buffered as offsets into
+	// small
+	smallBarriers []int
 }
 
 // NewWriter creates a new LZMA writer for the classic format. The
-// method will write the header to the underlying stream.
+// method will write the header to the underlying stream, unless small
+// input is buffered.
 func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
+	orig := c
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	w = &Writer{h: c.header()}
+	if c.RawSink != nil {
+		lzma = io.MultiWriter(lzma, c.RawSink)
+	}
+	if c.MaxCompressedSize > 0 {
+		lzma = &capWriter{w: lzma, n: c.MaxCompressedSize}
+	}
+	if c.SmallInputBufferLimit > 0 && !c.SizeInHeader {
+		orig.RawSink = nil
+		return &Writer{pending: &orig, out: lzma}, nil
+	}
+	w = new(Writer)
+	if err = w.init(c, lzma); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
 
-	var ok bool
-	w.bw, ok = lzma.(io.ByteWriter)
-	if !ok {
-		w.buf = bufio.NewWriter(lzma)
+// init creates the encoder for the verified configuration c and
+// writes the header to lzma.
+func (w *Writer) init(c WriterConfig, lzma io.Writer) error {
+	w.h = c.header()
+	w.cw = countingWriter{w: lzma}
+	if _, ok := lzma.(io.ByteWriter); ok {
+		w.bw = &w.cw
+	} else {
+		w.buf = bufio.NewWriter(&w.cw)
 		w.bw = w.buf
 	}
 	state := newState(w.h.properties)
-	m, err := c.Matcher.new(w.h.dictCap)
+	state.rep = c.InitialReps
+	// The header may declare a larger capacity than the encoder
+	// uses.
+	m, err := c.Matcher.new(c.MatchFinderDictCap)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	dict, err := newEncoderDict(w.h.dictCap, c.BufSize, m)
+	if c.GreedyMatch {
+		setGreedy(m)
+	}
+	dict, err := newEncoderDictAlloc(c.MatchFinderDictCap, c.BufSize, m,
+		c.Allocator)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	w.alloc = c.Allocator
+	w.maxWork = c.MaxWorkPerCall
+	w.eager = c.MaxCompressedSize > 0
+	dict.preset(c.PresetDict)
 	var flags encoderFlags
 	if c.EOSMarker {
-		flags = eosMarker
+		flags |= eosMarker
+	}
+	if c.OptimalParse {
+		flags |= optimalParse
 	}
 	if w.e, err = newEncoder(w.bw, state, dict, flags); err != nil {
-		return nil, err
+		return err
 	}
+	w.e.minMatch = c.MinMatch
+	w.e.trace = c.OpTrace
 
-	if err = w.writeHeader(); err != nil {
-		return nil, err
+	if !c.NoHeader {
+		if err = w.writeHeader(); err != nil {
+			return err
+		}
 	}
-	return w, nil
+	return nil
+}
+
+// start ends the buffering of small input. If final is set, all data
+// has been buffered and its size is written into the header;
+// otherwise the stream is written with an unknown size. The buffered
+// data is passed to the encoder.
+func (w *Writer) start(final bool) error {
+	c := *w.pending
+	if final {
+		c.SizeInHeader = true
+		c.Size = int64(len(w.small))
+	}
+	if err := c.Verify(); err != nil {
+		return err
+	}
+	if err := w.init(c, w.out); err != nil {
+		return err
+	}
+	for _, k := range w.smallBarriers {
+		w.e.addBarrier(w.e.dict.Pos() + int64(k))
+	}
+	p := w.small
+	w.pending, w.small, w.out, w.smallBarriers = nil, nil, nil, nil
+	_, err := w.e.Write(p)
+	return err
 }
 
 // NewWriter creates a new LZMA writer using the classic format. The
-// function writes the header to the underlying stream.
-func NewWriter(lzma io.Writer) (w *Writer, err error) {
-	return WriterConfig{}.NewWriter(lzma)
+// function writes the header to the underlying stream. The options
+// control the size in the header and the EOS marker; without options
+// the size is unknown and the EOS marker is written.
+func NewWriter(lzma io.Writer, opts ...WriterOption) (w *Writer, err error) {
+	var o writerOptions
+	for _, opt := range opts {
+		if err = opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	return o.c.NewWriter(lzma)
 }
 
 // writeHeader writes the LZMA header into the stream.
@@ -169,29 +472,154 @@ func (w *Writer) writeHeader() error {
 	return err
 }
 
+// remaining returns the number of bytes of n that the next call may
+// accept. The number is limited by MaxWorkPerCall and, if the size of
+// the uncompressed data has been set in the header, by the remaining
+// size. ErrNoSpace is returned if the remaining size is exceeded.
+func (w *Writer) remaining(n int) (int, error) {
+	if w.maxWork > 0 && n > w.maxWork {
+		n = w.maxWork
+	}
+	if w.h.size < 0 {
+		return n, nil
+	}
+	m := w.h.size
+	m -= w.e.Compressed() + int64(w.e.dict.Buffered())
+	if m < 0 {
+		m = 0
+	}
+	if m < int64(n) {
+		return int(m), ErrNoSpace
+	}
+	return n, nil
+}
+
 // Write puts data into the Writer.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	if w.h.size >= 0 {
-		m := w.h.size
-		m -= w.e.Compressed() + int64(w.e.dict.Buffered())
-		if m < 0 {
-			m = 0
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.pending != nil {
+		if len(w.small)+len(p) <= w.pending.SmallInputBufferLimit {
+			w.small = append(w.small, p...)
+			return len(p), nil
+		}
+		if err = w.start(false); err != nil {
+			return 0, err
+		}
+	}
+	var m int
+	m, err = w.remaining(len(p))
+	var werr error
+	if n, werr = w.e.Write(p[:m]); werr != nil {
+		err = werr
+	} else if w.eager {
+		err = w.compress(err)
+	}
+	return n, err
+}
+
+// compress encodes the buffered data except the look-ahead needed for
+// matches. The error err is returned if the compression succeeds.
+func (w *Writer) compress(err error) error {
+	if cerr := w.e.compress(0); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// WriteString puts the bytes of s into the Writer. The string is copied
+// directly into the dictionary buffer of the encoder, so no byte slice
+// needs to be allocated for it.
+func (w *Writer) WriteString(s string) (n int, err error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.pending != nil {
+		if len(w.small)+len(s) <= w.pending.SmallInputBufferLimit {
+			w.small = append(w.small, s...)
+			return len(s), nil
 		}
-		if m < int64(len(p)) {
-			p = p[:m]
-			err = ErrNoSpace
+		if err = w.start(false); err != nil {
+			return 0, err
 		}
 	}
+	var m int
+	m, err = w.remaining(len(s))
 	var werr error
-	if n, werr = w.e.Write(p); werr != nil {
+	if n, werr = w.e.WriteString(s[:m]); werr != nil {
 		err = werr
+	} else if w.eager {
+		err = w.compress(err)
 	}
 	return n, err
 }
 
+// WriteReaders writes the data of all readers in the given order into
+// the Writer as one logical stream without concatenating them in
+// memory. It returns the total number of bytes read. The combined size
+// is checked against the size declared in the header, and buffered
+// small input gets its exact size at Close, as with Write. The Writer
+// must still be closed to finish the stream.
+func (w *Writer) WriteReaders(rs ...io.Reader) (n int64, err error) {
+	p := make([]byte, 32*1024)
+	for _, r := range rs {
+		for {
+			k, rerr := r.Read(p)
+			q := p[:k]
+			for len(q) > 0 {
+				var m int
+				m, err = w.Write(q)
+				n += int64(m)
+				q = q[m:]
+				if err != nil {
+					return n, err
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return n, rerr
+			}
+		}
+	}
+	return n, nil
+}
+
+// Barrier ensures that no match of the data written after the call
+// references data written before it and that no match of earlier data
+// extends beyond it. The compression ratio degrades slightly. It works
+// like a soft dictionary reset without resetting the state of the
+// encoder: the range coder and the probabilities still continue, so a
+// decoder must still process the stream from the start, but an
+// application can rely on the data after the barrier never being
+// copied from data before it.
+func (w *Writer) Barrier() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.pending != nil {
+		w.smallBarriers = append(w.smallBarriers, len(w.small))
+		return nil
+	}
+	w.e.addBarrier(w.e.dict.Pos() + int64(w.e.dict.Buffered()))
+	return nil
+}
+
 // Close closes the writer stream. It ensures that all data from the
 // buffer will be compressed and the LZMA stream will be finished.
+// Close doesn't close the underlying writer. The dictionary buffer is
+// returned to the allocator of the configuration.
 func (w *Writer) Close() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.pending != nil {
+		if err := w.start(true); err != nil {
+			return err
+		}
+	}
 	if w.h.size >= 0 {
 		n := w.e.Compressed() + int64(w.e.dict.Buffered())
 		if n != w.h.size {
@@ -205,5 +633,59 @@ func (w *Writer) Close() error {
 			err = ferr
 		}
 	}
+	w.closed = true
+	w.e.dict.buf.free(w.alloc)
 	return err
 }
+
+// CloseContext closes the writer like Close, but returns ctx.Err() if
+// the context is done before Close finishes, for instance because
+// the underlying writer blocks. In that case Close continues in the
+// background and the Writer must not be used anymore; the LZMA
+// stream may be incomplete.
+func (w *Writer) CloseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c := make(chan error, 1)
+	go func() { c <- w.Close() }()
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OutputOffset returns the number of bytes that have been written to
+// the underlying writer so far. Bytes still held in internal buffers
+// are not included; after Close the value is the complete length of
+// the LZMA stream including the header.
+func (w *Writer) OutputOffset() int64 {
+	return w.cw.n
+}
+
+// FinalSize returns the total number of bytes the Writer emitted to the
+// underlying writer, including the header and the EOS marker. The
+// value is only available after Close has been called; it can be used
+// to index the stream in a container.
+func (w *Writer) FinalSize() (n int64, err error) {
+	if !w.closed {
+		return 0, errors.New("lzma: FinalSize requires a closed writer")
+	}
+	return w.cw.n, nil
+}
+
+// EffectiveDictSize returns the largest match distance the encoder has
+// used. A dictionary of this size would have been sufficient to decode
+// the stream, so the value helps to choose DictCap for similar inputs;
+// note that DictCap can't be smaller than MinDictCap. The EOS marker
+// is not counted and zero is returned if no match has been used. The
+// value is complete only after Close has been called.
+func (w *Writer) EffectiveDictSize() int64 {
+	if w.e == nil {
+		// small input is still buffered
+		return 0
+	}
+	return w.e.maxDistance
+}
@@ -23,6 +23,53 @@ type Writer2Config struct {
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// OptimalParse enables a look-ahead parsing, which checks
+	// whether a literal followed by the match at the next position
+	// is estimated to be cheaper than a short match found at the
+	// current position. It usually improves the compression ratio
+	// slightly at the cost of speed. The default is the faster
+	// greedy parsing.
+	OptimalParse bool
+	// GreedyMatch makes the HashTable4 matcher check fewer
+	// positions and accept the first long match, trading
+	// compression ratio for speed. It excludes OptimalParse.
+	GreedyMatch bool
+	// PresetDict provides data that is used to initialize the
+	// dictionary. Only the last DictCap bytes are used. The first
+	// chunk will then reset the state but not the dictionary. The
+	// reader must be configured with the same preset dictionary.
+	PresetDict []byte
+	// InitialDictCap enables the adaptive dictionary if it is not
+	// zero. The encoder starts with a dictionary of this capacity
+	// and doubles it, up to DictCap, at the next chunk boundary after
+	// as much data has been written as the dictionary can hold. An
+	// LZMA2 stream declares its dictionary capacity only once, so
+	// DictCap is still the capacity a reader has to provide; the
+	// option only saves memory and time for small inputs.
+	//
+	// Every increase requires a chunk resetting the dictionary and
+	// the state: the data following the reset can't refer to earlier
+	// data, the probabilities must be learned again and a new
+	// dictionary buffer and matcher must be allocated. There are at
+	// most log2(DictCap/InitialDictCap) increases.
+	InitialDictCap int
+	// ResetSchedule lists offsets of the uncompressed data in
+	// ascending order at which the writer terminates the current
+	// chunk and starts a chunk resetting the dictionary and the
+	// state. The compressed data before a reset depends only on the
+	// uncompressed data before it, so inputs with an identical prefix
+	// produce identical compressed output up to the last reset inside
+	// the prefix. This keeps compressed files checked into version
+	// control diffable. Each reset costs compression ratio, because
+	// the following data can't refer to earlier data.
+	ResetSchedule []int64
+	// StoreIncompressible checks the first bytes of every chunk with
+	// IsLikelyCompressed. If the data looks already compressed, up to
+	// 64 KiB are stored in an uncompressed chunk without trying to
+	// compress them, which saves time for inputs like archives of
+	// compressed files. Data misclassified as compressed isn't
+	// compressed at all.
+	StoreIncompressible bool
 }
 
 // fill replaces zero values with default values.
@@ -64,6 +111,33 @@ func (c *Writer2Config) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if c.GreedyMatch && c.OptimalParse {
+		return errors.New(
+			"lzma: GreedyMatch can't be combined with OptimalParse")
+	}
+	if c.InitialDictCap != 0 {
+		if !(MinDictCap <= c.InitialDictCap &&
+			c.InitialDictCap <= c.DictCap) {
+			return errors.New(
+				"lzma: initial dictionary capacity is out of range")
+		}
+		if len(c.PresetDict) > 0 {
+			return errors.New("lzma: initial dictionary capacity " +
+				"can't be combined with a preset dictionary")
+		}
+	}
+	var last int64
+	for _, off := range c.ResetSchedule {
+		if off <= last {
+			return errors.New("lzma: reset schedule offsets " +
+				"must be positive and ascending")
+		}
+		last = off
+	}
+	if len(c.ResetSchedule) > 0 && len(c.PresetDict) > 0 {
+		return errors.New("lzma: reset schedule can't be combined " +
+			"with a preset dictionary")
+	}
 	return nil
 }
 
@@ -77,7 +151,8 @@ func (c *Writer2Config) Verify() error {
 // Any change to the fields Properties, DictCap must be done before the
 // first call to Write, Flush or Close.
 type Writer2 struct {
-	w io.Writer
+	w  io.Writer
+	cw countingWriter
 
 	start   *state
 	encoder *encoder
@@ -87,6 +162,18 @@ type Writer2 struct {
 
 	buf bytes.Buffer
 	lbw LimitedByteWriter
+
+	// configuration used to create new encoders for the adaptive
+	// dictionary
+	config Writer2Config
+	// current capacity of the encoder dictionary
+	dictCap int
+	// uncompressed bytes written since the last dictionary reset
+	n int64
+	// total number of uncompressed bytes written
+	total int64
+	// offsets of the reset schedule that haven't been reached yet
+	resets []int64
 }
 
 // NewWriter2 creates an LZMA2 chunk sequence writer with the default
@@ -100,27 +187,121 @@ func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
+	cstate := startState(len(c.PresetDict) > 0)
 	w = &Writer2{
-		w:      lzma2,
+		cw:     countingWriter{w: lzma2},
 		start:  newState(*c.Properties),
-		cstate: start,
-		ctype:  start.defaultChunkType(),
+		cstate: cstate,
+		ctype:  cstate.defaultChunkType(),
 	}
+	w.w = &w.cw
 	w.buf.Grow(maxCompressed)
 	w.lbw = LimitedByteWriter{BW: &w.buf, N: maxCompressed}
-	m, err := c.Matcher.new(c.DictCap)
-	if err != nil {
+	w.config = c
+	w.resets = c.ResetSchedule
+	dictCap := c.DictCap
+	if c.InitialDictCap != 0 {
+		dictCap = c.InitialDictCap
+	}
+	if err = w.newEncoder(dictCap); err != nil {
 		return nil, err
 	}
-	d, err := newEncoderDict(c.DictCap, c.BufSize, m)
+	return w, nil
+}
+
+// newEncoder creates a new encoder with the given dictionary capacity
+// starting with the state w.start.
+func (w *Writer2) newEncoder(dictCap int) error {
+	c := &w.config
+	m, err := c.Matcher.new(dictCap)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d, 0)
+	if c.GreedyMatch {
+		setGreedy(m)
+	}
+	d, err := newEncoderDict(dictCap, c.BufSize, m)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return w, nil
+	d.preset(c.PresetDict)
+	if err = w.setEncoder(d); err != nil {
+		return err
+	}
+	w.dictCap = dictCap
+	return nil
+}
+
+// setEncoder creates the encoder for the dictionary d starting with
+// the state w.start.
+func (w *Writer2) setEncoder(d *encoderDict) error {
+	var flags encoderFlags
+	if w.config.OptimalParse {
+		flags |= optimalParse
+	}
+	if w.config.StoreIncompressible {
+		flags |= storeIncompressible
+	}
+	var err error
+	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d, flags)
+	if err != nil {
+		return err
+	}
+	w.n = 0
+	return nil
+}
+
+// growDict doubles the dictionary capacity up to DictCap if the
+// adaptive dictionary is enabled and the current dictionary has been
+// filled. The next chunk resets the dictionary and the state.
+// Buffered data that hasn't been compressed yet is moved to the new
+// dictionary.
+func (w *Writer2) growDict() (grown bool, err error) {
+	if w.config.InitialDictCap == 0 || w.dictCap >= w.config.DictCap ||
+		w.n < int64(w.dictCap) {
+		return false, nil
+	}
+	dictCap := w.config.DictCap
+	if w.dictCap <= dictCap/2 {
+		dictCap = 2 * w.dictCap
+	}
+	// data not compressed yet must be moved to the new dictionary
+	pending := make([]byte, w.encoder.dict.Buffered())
+	w.encoder.dict.buf.Peek(pending)
+	w.start = newState(w.encoder.state.Properties)
+	if err = w.newEncoder(dictCap); err != nil {
+		return false, err
+	}
+	if _, err = w.encoder.dict.Write(pending); err != nil {
+		return false, err
+	}
+	w.ctype = cLRND
+	return true, nil
+}
+
+// resetDict flushes the buffered data and lets the next chunk reset the
+// dictionary and the state. It is called at the offsets of the reset
+// schedule. The dictionary buffer and the matcher are reused.
+func (w *Writer2) resetDict() error {
+	w.resets = w.resets[1:]
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.start = newState(w.encoder.state.Properties)
+	d := w.encoder.dict
+	d.reset()
+	if err := w.setEncoder(d); err != nil {
+		return err
+	}
+	w.ctype = cLRND
+	return nil
+}
+
+// DictCap returns the capacity of the dictionary currently used by the
+// encoder. It differs from the DictCap field of the configuration only
+// if InitialDictCap has been set.
+func (w *Writer2) DictCap() int {
+	return w.dictCap
 }
 
 // written returns the number of bytes written to the current chunk
@@ -142,10 +323,18 @@ func (w *Writer2) Write(p []byte) (n int, err error) {
 		return 0, errClosed
 	}
 	for n < len(p) {
+		if len(w.resets) > 0 && w.total == w.resets[0] {
+			if err = w.resetDict(); err != nil {
+				return n, err
+			}
+		}
 		m := maxUncompressed - w.written()
 		if m <= 0 {
 			panic("lzma: maxUncompressed reached")
 		}
+		if len(w.resets) > 0 && w.resets[0]-w.total < int64(m) {
+			m = int(w.resets[0] - w.total)
+		}
 		var q []byte
 		if n+m < len(p) {
 			q = p[n : n+m]
@@ -154,6 +343,7 @@ func (w *Writer2) Write(p []byte) (n int, err error) {
 		}
 		k, err := w.encoder.Write(q)
 		n += k
+		w.total += int64(k)
 		if err != nil && err != ErrLimit {
 			return n, err
 		}
@@ -241,7 +431,7 @@ func (w *Writer2) writeCompressedChunk() error {
 func (w *Writer2) writeChunk() error {
 	u := int(uncompressedHeaderLen + w.encoder.Compressed())
 	c := headerLen(w.ctype) + w.buf.Len()
-	if u < c {
+	if w.encoder.stored || u < c {
 		return w.writeUncompressedChunk()
 	}
 	return w.writeCompressedChunk()
@@ -257,18 +447,23 @@ func (w *Writer2) flushChunk() error {
 	if err = w.encoder.Close(); err != nil {
 		return err
 	}
+	w.n += w.encoder.Compressed()
 	if err = w.writeChunk(); err != nil {
 		return err
 	}
 	w.buf.Reset()
 	w.lbw.N = maxCompressed
-	if err = w.encoder.Reopen(&w.lbw); err != nil {
-		return err
-	}
 	if err = w.cstate.next(w.ctype); err != nil {
 		return err
 	}
 	w.ctype = w.cstate.defaultChunkType()
+	grown, err := w.growDict()
+	if err != nil || grown {
+		return err
+	}
+	if err = w.encoder.Reopen(&w.lbw); err != nil {
+		return err
+	}
 	w.start = cloneState(w.encoder.state)
 	return nil
 }
@@ -287,13 +482,14 @@ func (w *Writer2) Flush() error {
 	return nil
 }
 
-// Close terminates the LZMA2 stream with an EOS chunk.
+// Close terminates the LZMA2 stream with an EOS chunk. Close doesn't
+// close the underlying writer.
 func (w *Writer2) Close() error {
 	if w.cstate == stop {
 		return errClosed
 	}
 	if err := w.Flush(); err != nil {
-		return nil
+		return err
 	}
 	// write zero byte EOS chunk
 	_, err := w.w.Write([]byte{0})
@@ -303,3 +499,36 @@ func (w *Writer2) Close() error {
 	w.cstate = stop
 	return nil
 }
+
+// Compress compresses the data buffered by the writer without
+// terminating the current chunk. Only the last bytes required for the
+// search of matches stay uncompressed. Afterwards Pending provides
+// nearly the complete compressed size of the buffered data. Note that
+// the writer compresses data otherwise only if its buffer is full.
+func (w *Writer2) Compress() error {
+	if w.cstate == stop {
+		return errClosed
+	}
+	err := w.encoder.compress(0)
+	if err == ErrLimit {
+		return w.flushChunk()
+	}
+	return err
+}
+
+// Pending returns the number of compressed bytes of the current chunk
+// that are held in the internal buffer and haven't been written to the
+// underlying writer yet. Data that hasn't been compressed yet is not
+// included; see Compress.
+func (w *Writer2) Pending() int {
+	return w.buf.Len()
+}
+
+// OutputOffset returns the number of bytes written to the underlying
+// writer. Data written to the Writer2 is buffered, so the value
+// reflects only complete chunks. Call Flush before OutputOffset to get
+// the offset of a chunk boundary, which can be used to record frame
+// boundaries.
+func (w *Writer2) OutputOffset() int64 {
+	return w.cw.n
+}
@@ -6,7 +6,9 @@ package lzma
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"strings"
 	"testing"
@@ -107,3 +109,524 @@ func TestCycle2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestWriter2OutputOffset(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: 4096}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, "The quick brown fox"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if n := w.OutputOffset(); n != 0 {
+		t.Fatalf("w.OutputOffset() before Flush is %d; want %d", n, 0)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() error %s", err)
+	}
+	off := w.OutputOffset()
+	if off != int64(buf.Len()) {
+		t.Fatalf("w.OutputOffset() after Flush is %d; want %d",
+			off, buf.Len())
+	}
+	if _, err = io.WriteString(w, " jumps over the lazy dog."); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close() error %s", err)
+	}
+	if n := w.OutputOffset(); n != int64(buf.Len()) {
+		t.Fatalf("w.OutputOffset() after Close is %d; want %d",
+			n, buf.Len())
+	}
+	if off >= int64(buf.Len()) {
+		t.Fatalf("offset %d after Flush not less than total %d",
+			off, buf.Len())
+	}
+}
+
+func TestWriter2OptimalParse(t *testing.T) {
+	// The data requires multiple chunks.
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data,
+		randtxt.NewReader(rand.NewSource(142)), 1<<20); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := Writer2Config{OptimalParse: true}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data.Bytes()) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2PresetDict(t *testing.T) {
+	var txt bytes.Buffer
+	if _, err := io.CopyN(&txt,
+		randtxt.NewReader(rand.NewSource(151)), 300000); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(151)).Read(random)
+	dict := txt.Bytes()[:100000]
+
+	tests := []struct {
+		name    string
+		dict    []byte
+		data    []byte
+		control byte
+		mask    byte
+	}{
+		// LZMA; reset state, new properties, reset dictionary
+		{"text", nil, txt.Bytes(), 0xe0, 0xe0},
+		// LZMA; reset state, new properties, no dictionary reset
+		{"textPreset", dict, txt.Bytes(), 0xc0, 0xe0},
+		// uncompressed; reset dictionary
+		{"random", nil, random, 0x01, 0xff},
+		// uncompressed; no dictionary reset
+		{"randomPreset", dict, random, 0x02, 0xff},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := Writer2Config{
+				DictCap:    1 << 20,
+				PresetDict: tc.dict,
+			}.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(tc.data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			stream := buf.Bytes()
+			if c := stream[0] & tc.mask; c != tc.control {
+				t.Fatalf("first control byte %#02x; want %#02x",
+					c, tc.control)
+			}
+			r, err := Reader2Config{
+				DictCap:    1 << 20,
+				PresetDict: tc.dict,
+			}.NewReader2(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			var out bytes.Buffer
+			if _, err = io.Copy(&out, r); err != nil {
+				t.Fatalf("io.Copy error %s", err)
+			}
+			if !bytes.Equal(out.Bytes(), tc.data) {
+				t.Fatalf("decompressed data differs")
+			}
+			if tc.dict == nil {
+				return
+			}
+			// A reader without the preset dictionary must
+			// reject the first chunk.
+			r, err = NewReader2(bytes.NewReader(stream))
+			if err == nil {
+				_, err = io.Copy(ioutil.Discard, r)
+			}
+			if err == nil {
+				t.Fatalf("reader without preset dictionary " +
+					"accepted the stream")
+			}
+		})
+	}
+}
+
+func TestWriter2Compress(t *testing.T) {
+	var txt bytes.Buffer
+	if _, err := io.CopyN(&txt,
+		randtxt.NewReader(rand.NewSource(157)), 100000); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if n := w.Pending(); n != 0 {
+		t.Fatalf("Pending returned %d before Compress; want 0", n)
+	}
+	if err = w.Compress(); err != nil {
+		t.Fatalf("Compress error %s", err)
+	}
+	pending := w.Pending()
+	t.Logf("pending %d bytes", pending)
+	if pending <= 0 || pending >= txt.Len() {
+		t.Fatalf("Pending returned %d after Compress", pending)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if n := buf.Len(); n < pending {
+		t.Fatalf("stream has %d bytes; less than pending %d", n,
+			pending)
+	}
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, txt.Bytes()) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2InitialDictCap(t *testing.T) {
+	const (
+		period = 128 << 10
+		blocks = 16
+	)
+	block := make([]byte, period)
+	rand.New(rand.NewSource(7)).Read(block)
+	var buf bytes.Buffer
+	cfg := Writer2Config{
+		DictCap:        1 << 20,
+		InitialDictCap: 64 << 10,
+	}
+	w, err := cfg.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if c := w.DictCap(); c != cfg.InitialDictCap {
+		t.Fatalf("DictCap() = %d; want %d", c, cfg.InitialDictCap)
+	}
+	var sizes []int64
+	var caps []int
+	for i := 0; i < blocks; i++ {
+		off := w.OutputOffset()
+		caps = append(caps, w.DictCap())
+		if _, err = w.Write(block); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		sizes = append(sizes, w.OutputOffset()-off)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if c := w.DictCap(); c != cfg.DictCap {
+		t.Fatalf("final DictCap() = %d; want %d", c, cfg.DictCap)
+	}
+	// The first block can't be compressed and the small
+	// dictionary can't find the repetition of the second block.
+	for i := 0; i < 2; i++ {
+		if sizes[i] < period*9/10 {
+			t.Fatalf("block %d compressed to %d bytes with"+
+				" dictionary capacity %d", i, sizes[i], caps[i])
+		}
+	}
+	// Every increase resets the dictionary, so the first block
+	// after it can't be compressed. The blocks following the last
+	// increase refer to the previous block.
+	for i := blocks/2 + 1; i < blocks; i++ {
+		if caps[i] != cfg.DictCap {
+			t.Fatalf("block %d encoded with dictionary capacity %d",
+				i, caps[i])
+		}
+		if sizes[i] > period/100 {
+			t.Fatalf("block %d compressed to %d bytes", i, sizes[i])
+		}
+	}
+	t.Logf("chunk sizes %v", sizes)
+
+	r, err := Reader2Config{DictCap: cfg.DictCap}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	want := bytes.Repeat(block, blocks)
+	if !bytes.Equal(data, want) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2InitialDictCapStream(t *testing.T) {
+	const size = 3 << 20
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(3)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	cfg := Writer2Config{DictCap: 1 << 20, InitialDictCap: 4096}
+	w, err := cfg.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if c := w.DictCap(); c != cfg.DictCap {
+		t.Fatalf("DictCap() = %d; want %d", c, cfg.DictCap)
+	}
+	r, err := Reader2Config{DictCap: cfg.DictCap}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2InitialDictCapVerify(t *testing.T) {
+	tests := []Writer2Config{
+		{DictCap: 1 << 16, InitialDictCap: 1 << 17},
+		{DictCap: 1 << 16, InitialDictCap: MinDictCap - 1},
+		{DictCap: 1 << 16, InitialDictCap: MinDictCap,
+			PresetDict: []byte("abc")},
+	}
+	for _, c := range tests {
+		if err := c.Verify(); err == nil {
+			t.Errorf("Verify(%+v) returned no error", c)
+		}
+	}
+}
+
+func TestWriter2ResetSchedule(t *testing.T) {
+	const size = 200000
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(5)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	changed := append([]byte{}, data...)
+	for i := 150000; i < 150100; i++ {
+		changed[i] = 'X'
+	}
+	cfg := Writer2Config{
+		DictCap:       1 << 16,
+		ResetSchedule: []int64{1 << 16, 1 << 17},
+	}
+	compress := func(p []byte, close bool) []byte {
+		var buf bytes.Buffer
+		w, err := cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if close {
+			err = w.Close()
+		} else {
+			err = w.Flush()
+		}
+		if err != nil {
+			t.Fatalf("close or flush error %s", err)
+		}
+		return buf.Bytes()
+	}
+	prefix := compress(data[:1<<17], false)
+	for _, p := range [][]byte{data, changed} {
+		z := compress(p, true)
+		if !bytes.HasPrefix(z, prefix) {
+			t.Fatalf("compressed output doesn't start with" +
+				" the compressed prefix")
+		}
+		r, err := Reader2Config{DictCap: cfg.DictCap}.NewReader2(
+			bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatalf("decompressed data differs")
+		}
+	}
+
+	// The data after a reset is compressed as by a new writer, while
+	// the dictionary is reused.
+	for _, m := range []MatchAlgorithm{HashTable4, BinaryTree,
+		LiteralsOnly} {
+		cfg = Writer2Config{DictCap: 1 << 16, Matcher: m}
+		want := compress(data[1<<17:], true)
+		cfg.ResetSchedule = []int64{1 << 17}
+		var buf bytes.Buffer
+		w, err := cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		d := w.encoder.dict
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if w.encoder.dict != d {
+			t.Fatalf("%s: reset allocated a new dictionary", m)
+		}
+		if !bytes.HasSuffix(buf.Bytes(), want) {
+			t.Fatalf("%s: output after the reset differs from"+
+				" new writer", m)
+		}
+	}
+
+	bad := []Writer2Config{
+		{ResetSchedule: []int64{0}},
+		{ResetSchedule: []int64{10, 10}},
+		{ResetSchedule: []int64{10}, PresetDict: []byte("abc")},
+	}
+	for _, c := range bad {
+		if err := c.Verify(); err == nil {
+			t.Errorf("Verify(%+v) returned no error", c)
+		}
+	}
+}
+
+// failingWriter returns its error for every write.
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (n int, err error) {
+	return 0, w.err
+}
+
+func TestWriter2CloseError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	w, err := NewWriter2(failingWriter{errWrite})
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	// The data stays in the buffer of the writer until Close.
+	if _, err = w.Write([]byte(testString)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != errWrite {
+		t.Fatalf("w.Close returned %v; want %v", err, errWrite)
+	}
+}
+
+// chunkHeaders returns the headers of the chunks of the LZMA2 stream.
+func chunkHeaders(t *testing.T, data []byte) []chunkHeader {
+	var headers []chunkHeader
+	r := bytes.NewReader(data)
+	for {
+		h, err := readChunkHeader(r)
+		if err != nil {
+			t.Fatalf("readChunkHeader error %s", err)
+		}
+		headers = append(headers, *h)
+		var n int64
+		switch h.ctype {
+		case cEOS:
+			return headers
+		case cU, cUD:
+			n = int64(h.uncompressed) + 1
+		default:
+			n = int64(h.compressed) + 1
+		}
+		if _, err = r.Seek(n, io.SeekCurrent); err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+	}
+}
+
+func TestWriter2StoreIncompressible(t *testing.T) {
+	const n = 100000
+	var data bytes.Buffer
+	txt := randtxt.NewReader(rand.NewSource(189))
+	if _, err := io.CopyN(&data, txt, n); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+	random := make([]byte, 3*n)
+	rand.New(rand.NewSource(189)).Read(random)
+	data.Write(random)
+	if _, err := io.CopyN(&data, txt, n); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+
+	var stored, size [2]int
+	for i, store := range []bool{false, true} {
+		var buf bytes.Buffer
+		w, err := Writer2Config{StoreIncompressible: store}.
+			NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		for _, h := range chunkHeaders(t, buf.Bytes()) {
+			if h.ctype == cU || h.ctype == cUD {
+				stored[i] += int(h.uncompressed) + 1
+			}
+		}
+		size[i] = buf.Len()
+		t.Logf("StoreIncompressible %t: %d bytes stored; %d bytes"+
+			" compressed to %d bytes", store, stored[i], data.Len(),
+			size[i])
+		r, err := NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, data.Bytes()) {
+			t.Fatalf("StoreIncompressible %t: decoded data differs",
+				store)
+		}
+	}
+	// The chunk with the first text continues into the random
+	// data, because the decision is only made at chunk starts.
+	if stored[1] <= stored[0] || stored[1] < 2*n {
+		t.Errorf("StoreIncompressible stored %d bytes; want more"+
+			" than %d and at least %d", stored[1], stored[0], 2*n)
+	}
+	if stored[1] > 3*n+storeSampleLen {
+		t.Errorf("StoreIncompressible stored %d bytes of text",
+			stored[1]-3*n)
+	}
+	if size[1] > size[0] {
+		t.Errorf("StoreIncompressible increased the size from %d"+
+			" to %d bytes", size[0], size[1])
+	}
+}
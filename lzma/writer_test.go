@@ -7,12 +7,20 @@ package lzma
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
 )
@@ -247,3 +255,1409 @@ func BenchmarkWriter(b *testing.B) {
 		}
 	}
 }
+
+func TestWriterOutputOffset(t *testing.T) {
+	orig := readOrigFile(t)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if n := w.OutputOffset(); n != HeaderLen {
+		t.Fatalf("w.OutputOffset() after NewWriter is %d; want %d",
+			n, HeaderLen)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if n := w.OutputOffset(); n != int64(buf.Len()) {
+		t.Fatalf("w.OutputOffset() is %d; want %d", n, buf.Len())
+	}
+}
+
+// periodicData generates data consisting of 4-byte records. Each byte
+// position in a record uses its own value range, so the literal
+// distribution depends on the position modulo 4.
+func periodicData(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i%4)<<6 | byte(rnd.Intn(16))
+	}
+	return p
+}
+
+func compressWithConfig(tb testing.TB, c WriterConfig, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		tb.Fatalf("WriterConfig.NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		tb.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriterLP(t *testing.T) {
+	data := periodicData(1<<16, 7)
+	c0 := compressWithConfig(t, WriterConfig{
+		Properties: &Properties{LC: 0, LP: 0, PB: 2}}, data)
+	c2 := compressWithConfig(t, WriterConfig{
+		Properties: &Properties{LC: 0, LP: 2, PB: 2}}, data)
+	t.Logf("LP=0: %d bytes; LP=2: %d bytes", len(c0), len(c2))
+	if len(c2) >= len(c0)*95/100 {
+		t.Errorf("LP=2 compressed size %d not 5%% smaller than"+
+			" LP=0 size %d", len(c2), len(c0))
+	}
+	r, err := NewReader(bytes.NewReader(c2))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs from original")
+	}
+}
+
+func TestWriterRawSink(t *testing.T) {
+	orig := readOrigFile(t)
+	var buf, sink bytes.Buffer
+	w, err := WriterConfig{RawSink: &sink}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("WriterConfig.NewWriter error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if !bytes.Equal(sink.Bytes(), buf.Bytes()) {
+		t.Fatalf("RawSink received %d bytes different from the"+
+			" %d bytes written", sink.Len(), buf.Len())
+	}
+	want := compressWithConfig(t, WriterConfig{}, orig)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("RawSink changed the compressed output")
+	}
+}
+
+// blockingWriter blocks all writes until the channel release is
+// closed.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (n int, err error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestWriterCloseContext(t *testing.T) {
+	bw := blockingWriter{release: make(chan struct{})}
+	defer close(bw.release)
+	w, err := NewWriter(bw)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte(testString)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	if err = w.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("w.CloseContext returned %v; want %v", err,
+			context.DeadlineExceeded)
+	}
+
+	var buf bytes.Buffer
+	if w, err = NewWriter(&buf); err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if err = w.CloseContext(context.Background()); err != nil {
+		t.Fatalf("w.CloseContext error %s", err)
+	}
+}
+
+// structuredData generates text records with fixed field names, so
+// that matches with repeated distances are common.
+func structuredData(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	names := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+	p := make([]byte, 0, n+32)
+	for len(p) < n {
+		p = append(p, "id="...)
+		for i := 0; i < 4; i++ {
+			p = append(p, byte('0'+rnd.Intn(10)))
+		}
+		p = append(p, ";name="...)
+		p = append(p, names[rnd.Intn(len(names))]...)
+		p = append(p, ";v="...)
+		p = append(p, byte('0'+rnd.Intn(10)), byte('0'+rnd.Intn(10)),
+			'\n')
+	}
+	return p[:n]
+}
+
+// repBlindTable hides the repetition distances from the hash table
+// matcher. It selects the longest match found in the dictionary, which
+// is the selection used before repetition distances were preferred.
+type repBlindTable struct{ *hashTable }
+
+// NextOp returns the next operation without using the repetitions.
+func (t repBlindTable) NextOp(rep [4]uint32) operation {
+	// Distances beyond the dictionary are ignored by the matcher.
+	const far = 1 << 30
+	return t.hashTable.NextOp([4]uint32{far, far, far, far})
+}
+
+// encodedSize returns the size of the raw LZMA stream for data encoded
+// with the given matcher.
+func encodedSize(tb testing.TB, m matcher, data []byte) int {
+	d, err := newEncoderDict(1<<20, 1<<16, m)
+	if err != nil {
+		tb.Fatalf("newEncoderDict error %s", err)
+	}
+	var buf bytes.Buffer
+	state := newState(Properties{LC: 3, LP: 0, PB: 2})
+	e, err := newEncoder(&buf, state, d, 0)
+	if err != nil {
+		tb.Fatalf("newEncoder error %s", err)
+	}
+	if _, err = e.Write(data); err != nil {
+		tb.Fatalf("e.Write error %s", err)
+	}
+	if err = e.Close(); err != nil {
+		tb.Fatalf("e.Close error %s", err)
+	}
+	return buf.Len()
+}
+
+func TestWriterStructured(t *testing.T) {
+	data := structuredData(100000, 1)
+	c := compressWithConfig(t, WriterConfig{}, data)
+	r, err := NewReader(bytes.NewReader(c))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs from original")
+	}
+
+	ht, err := newHashTable(1<<20, 4)
+	if err != nil {
+		t.Fatalf("newHashTable error %s", err)
+	}
+	n := encodedSize(t, ht, data)
+	ht, err = newHashTable(1<<20, 4)
+	if err != nil {
+		t.Fatalf("newHashTable error %s", err)
+	}
+	blind := encodedSize(t, repBlindTable{ht}, data)
+	t.Logf("structured data: %d bytes compressed to %d bytes;"+
+		" %d bytes without preferring repetitions",
+		len(data), n, blind)
+	if n >= blind {
+		t.Fatalf("preferring repetitions doesn't improve the"+
+			" compression: %d bytes; %d bytes without", n, blind)
+	}
+}
+
+func BenchmarkWriterStructured(b *testing.B) {
+	data := structuredData(1<<20, 1)
+	var buf bytes.Buffer
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		w, err := NewWriter(&buf)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			b.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+	}
+	b.ReportMetric(float64(len(data))/float64(buf.Len()), "ratio")
+}
+
+// closeRecorder is a writer that records calls to Close and returns
+// an error for them.
+type closeRecorder struct {
+	bytes.Buffer
+	closed int
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed++
+	return errors.New("Close must not be called")
+}
+
+func TestWriterCloseUnderlying(t *testing.T) {
+	var cr closeRecorder
+	w, err := NewWriter(&cr)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte(testString)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if cr.closed != 0 {
+		t.Fatalf("Close of underlying writer called %d times",
+			cr.closed)
+	}
+
+	var cr2 closeRecorder
+	w2, err := NewWriter2(&cr2)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w2.Write([]byte(testString)); err != nil {
+		t.Fatalf("w2.Write error %s", err)
+	}
+	if err = w2.Close(); err != nil {
+		t.Fatalf("w2.Close error %s", err)
+	}
+	if cr2.closed != 0 {
+		t.Fatalf("Close of underlying writer called %d times",
+			cr2.closed)
+	}
+}
+
+func TestWriterEOSMarkerWithSize(t *testing.T) {
+	data := []byte(testString)
+	c := WriterConfig{Size: int64(len(data))}
+	plain := compressWithConfig(t, c, data)
+	c.EOSMarker = true
+	withEOS := compressWithConfig(t, c, data)
+	if len(withEOS) <= len(plain) {
+		t.Fatalf("stream with EOS marker has %d bytes; without %d",
+			len(withEOS), len(plain))
+	}
+	r, err := NewReader(bytes.NewReader(withEOS))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p := r.Parameters(); p.Size != int64(len(data)) {
+		t.Fatalf("header size %d; want %d", p.Size, len(data))
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs")
+	}
+	if !r.EOSMarker() {
+		t.Fatalf("no EOS marker found")
+	}
+}
+
+func TestWriterSizeCases(t *testing.T) {
+	tests := []struct {
+		name string
+		c    WriterConfig
+		data []byte
+		// size field in the header
+		size uint64
+		eos  bool
+	}{
+		{"empty with size", WriterConfig{SizeInHeader: true},
+			nil, 0, false},
+		{"empty without size", WriterConfig{}, nil,
+			noHeaderSize, true},
+		{"data with size", WriterConfig{Size: 3}, []byte("abc"),
+			3, false},
+		{"data without size", WriterConfig{}, []byte("abc"),
+			noHeaderSize, true},
+	}
+	for _, tc := range tests {
+		stream := compressWithConfig(t, tc.c, tc.data)
+		if s := uint64LE(stream[5:13]); s != tc.size {
+			t.Errorf("%s: header size %#x; want %#x", tc.name, s,
+				tc.size)
+		}
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.name, err)
+		}
+		if !bytes.Equal(p, tc.data) {
+			t.Errorf("%s: decoded %q; want %q", tc.name, p, tc.data)
+		}
+		if r.EOSMarker() != tc.eos {
+			t.Errorf("%s: EOSMarker() is %t; want %t", tc.name,
+				r.EOSMarker(), tc.eos)
+		}
+	}
+	c := WriterConfig{SizeInHeader: true, Size: -1}
+	if err := c.Verify(); err == nil {
+		t.Errorf("Verify accepted negative size with SizeInHeader")
+	}
+}
+
+func TestWriterCanonicalDictCap(t *testing.T) {
+	const n = 5000000
+	c := WriterConfig{DictCap: n}
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	c = WriterConfig{DictCap: n, CanonicalDictCap: true}
+	err := c.Verify()
+	if err == nil {
+		t.Fatalf("Verify accepted non-canonical DictCap %d", n)
+	}
+	t.Logf("Verify error %s", err)
+	for _, s := range []string{"4194304", "6291456"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q doesn't contain %s", err, s)
+		}
+	}
+	canonical := []int{MinDictCap, 6 << 10, 1 << 20, 3 << 20}
+	if d := int64(MaxDictCap); d <= maxInt {
+		canonical = append(canonical, int(d))
+	}
+	for _, n := range canonical {
+		c = WriterConfig{DictCap: n, CanonicalDictCap: true}
+		if err = c.Verify(); err != nil {
+			t.Errorf("Verify error %s for DictCap %d", err, n)
+		}
+	}
+}
+
+func TestWriterWindowSliding(t *testing.T) {
+	const (
+		dictCap = 1 << 16
+		near    = dictCap - 100
+		far     = dictCap + 100
+		copyLen = 64
+		step    = 4096
+	)
+	rng := rand.New(rand.NewSource(29))
+	data := make([]byte, 10*dictCap)
+	rng.Read(data)
+	// copy segments from distances just under and just over dictCap
+	nearCopies := 0
+	for i := far; i+copyLen <= len(data); i += step {
+		dist := far
+		if (i/step)%2 == 0 {
+			dist = near
+			nearCopies++
+		}
+		copy(data[i:i+copyLen], data[i-dist:])
+	}
+
+	for _, matcher := range []MatchAlgorithm{HashTable4, BinaryTree} {
+		c := WriterConfig{DictCap: dictCap, Matcher: matcher}
+		stream := compressWithConfig(t, c, data)
+		ops, out := decodeOps(t, stream)
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%s: decoded data differs", matcher)
+		}
+		nearMatches := 0
+		for _, op := range ops {
+			m, ok := op.(match)
+			if !ok {
+				continue
+			}
+			if m.distance > dictCap {
+				t.Fatalf("%s: match %v exceeds dictionary"+
+					" capacity %d", matcher, m, dictCap)
+			}
+			// A greedy parser may cover the first bytes of
+			// a copy with another match.
+			if m.distance == near && m.n >= copyLen/2 {
+				nearMatches++
+			}
+		}
+		t.Logf("%s: %d of %d near copies found as matches", matcher,
+			nearMatches, nearCopies)
+		if nearMatches < nearCopies*9/10 {
+			t.Errorf("%s: only %d of %d near copies found as"+
+				" matches", matcher, nearMatches, nearCopies)
+		}
+	}
+}
+
+func TestWriterInitialReps(t *testing.T) {
+	rng := rand.New(rand.NewSource(133))
+	dict := make([]byte, 4096)
+	rng.Read(dict)
+	reps := [4]uint32{99, 499, 999, 1999}
+	// The data repeats the preset dictionary at the initial rep
+	// distances.
+	var text []byte
+	for _, r := range reps {
+		i := len(dict) + len(text) - int(r) - 1
+		text = append(text, dict[i:i+40]...)
+	}
+
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		PresetDict:  dict,
+		InitialReps: reps,
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(text); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	compressed := buf.Bytes()
+
+	r, err := ReaderConfig{
+		PresetDict:  dict,
+		InitialReps: reps,
+	}.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, text) {
+		t.Fatalf("decompressed data differs from the original")
+	}
+
+	// A reader using the standard initialization must not
+	// reproduce the data.
+	r, err = ReaderConfig{PresetDict: dict}.NewReader(
+		bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err == nil && bytes.Equal(got, text) {
+		t.Fatalf("reader without initial reps decoded the data")
+	}
+
+	_, err = WriterConfig{
+		DictCap:     MinDictCap,
+		InitialReps: [4]uint32{MinDictCap},
+	}.NewWriter(ioutil.Discard)
+	if err == nil {
+		t.Fatalf("NewWriter accepted rep distance beyond DictCap")
+	}
+}
+
+func TestWriterOptimalParse(t *testing.T) {
+	corpora := decodeCorpora(t)
+	for _, name := range []string{"enwik", "randtxt", "random"} {
+		data, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		data = data[:len(data)/4]
+		for _, ma := range []MatchAlgorithm{HashTable4, BinaryTree} {
+			greedy := compressWithConfig(t,
+				WriterConfig{Matcher: ma}, data)
+			stream := compressWithConfig(t, WriterConfig{
+				Matcher:      ma,
+				OptimalParse: true,
+			}, data)
+			t.Logf("%s %s: greedy %d bytes; optimal parse %d bytes",
+				name, ma, len(greedy), len(stream))
+			if name != "random" && len(stream) > len(greedy) {
+				t.Errorf("%s %s: optimal parse worse than greedy",
+					name, ma)
+			}
+			r, err := NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %s: ReadAll error %s", name, ma, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s %s: decompressed data differs",
+					name, ma)
+			}
+		}
+	}
+}
+
+func BenchmarkWriterOptimalParse(b *testing.B) {
+	corpora := decodeCorpora(b)
+	for _, name := range []string{"enwik", "randtxt", "random"} {
+		data, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		for _, optimal := range []bool{false, true} {
+			mode := "greedy"
+			if optimal {
+				mode = "optimal"
+			}
+			c := WriterConfig{OptimalParse: optimal}
+			b.Run(name+"/"+mode, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				var n int
+				for i := 0; i < b.N; i++ {
+					n = len(compressWithConfig(b, c, data))
+				}
+				b.ReportMetric(
+					float64(n)/float64(len(data)), "rate")
+			})
+		}
+	}
+}
+
+func TestWriterAutoDictSize(t *testing.T) {
+	tests := []struct {
+		size    int64
+		dictCap int64
+	}{
+		{0, MinDictCap},
+		{1, MinDictCap},
+		{4096, 4096},
+		{4097, 6 << 10},
+		{100000, 128 << 10},
+		{1 << 20, 1 << 20},
+		{1<<20 + 1, 3 << 19},
+		{3<<29 + 1, 1 << 31},
+		{1 << 40, MaxDictCap},
+	}
+	for _, tc := range tests {
+		c := WriterConfig{
+			Size:         tc.size,
+			SizeInHeader: true,
+			AutoDictSize: true,
+		}
+		if err := c.Verify(); err != nil {
+			t.Fatalf("Verify error %s", err)
+		}
+		want := tc.dictCap
+		if want > maxInt {
+			// largest canonical capacity for a 32-bit int
+			want = 3 << 29
+		}
+		if int64(c.DictCap) != want {
+			t.Errorf("size %d: got DictCap %d; want %d",
+				tc.size, c.DictCap, want)
+		}
+	}
+
+	// Without size the default is used.
+	c := WriterConfig{AutoDictSize: true}
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	if c.DictCap != 8<<20 {
+		t.Errorf("got DictCap %d without size; want %d", c.DictCap,
+			8<<20)
+	}
+
+	// round trip
+	text := []byte(testString)
+	stream := compressWithConfig(t, WriterConfig{
+		Size:         int64(len(text)),
+		AutoDictSize: true,
+	}, text)
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p := r.Parameters(); p.DictSize != MinDictCap {
+		t.Fatalf("got dictionary size %d; want %d", p.DictSize,
+			MinDictCap)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, text) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriterWriteString(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(8)), 300000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	s := string(txt)
+	c := WriterConfig{DictCap: MinDictCap}
+	want := compressWithConfig(t, c, txt)
+
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	var _ io.StringWriter = w
+	for len(s) > 0 {
+		k := 1 + rand.Intn(10000)
+		if k > len(s) {
+			k = len(s)
+		}
+		n, err := w.WriteString(s[:k])
+		if err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if n != k {
+			t.Fatalf("WriteString returned %d; want %d", n, k)
+		}
+		s = s[k:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("WriteString output differs from Write output")
+	}
+
+	c.Size = 10
+	w, err = c.NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n, err := w.WriteString("The quick brown fox")
+	if err != ErrNoSpace || n != 10 {
+		t.Fatalf("WriteString beyond Size returned %d, %v; want %d, %v",
+			n, err, 10, ErrNoSpace)
+	}
+
+	w, err = NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := w.WriteString("The quick brown fox"); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("WriteString allocated %.1f times per call", allocs)
+	}
+}
+
+func TestWriterMaxWorkPerCall(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(155)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	const maxWork = 4096
+	var buf bytes.Buffer
+	w, err := WriterConfig{MaxWorkPerCall: maxWork}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	p := txt
+	calls := 0
+	for len(p) > 0 {
+		n, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		want := len(p)
+		if want > maxWork {
+			want = maxWork
+		}
+		if n != want {
+			t.Fatalf("Write returned %d; want %d", n, want)
+		}
+		p = p[n:]
+		calls++
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if want := (len(txt) + maxWork - 1) / maxWork; calls != want {
+		t.Fatalf("got %d Write calls; want %d", calls, want)
+	}
+	want := compressWithConfig(t, WriterConfig{}, txt)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("output differs from writer without MaxWorkPerCall")
+	}
+
+	// The size limit is only reported if it is reached.
+	w, err = WriterConfig{
+		MaxWorkPerCall: 10,
+		Size:           15,
+	}.NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if n, err := w.WriteString("The quick brown fox"); n != 10 || err != nil {
+		t.Fatalf("WriteString returned %d, %v; want 10, nil", n, err)
+	}
+	if n, err := w.WriteString("The quick"); n != 5 || err != ErrNoSpace {
+		t.Fatalf("WriteString returned %d, %v; want 5, %v", n, err,
+			ErrNoSpace)
+	}
+
+	if _, err = (WriterConfig{MaxWorkPerCall: -1}).NewWriter(
+		ioutil.Discard); err == nil {
+		t.Fatalf("NewWriter accepted negative MaxWorkPerCall")
+	}
+}
+
+func TestWriterMatchFinderDictCap(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(160)), 1<<20))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	const dictCap = 8 << 20
+	// allocated returns the number of bytes allocated by NewWriter.
+	allocated := func(c WriterConfig) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		w, err := c.NewWriter(ioutil.Discard)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		runtime.ReadMemStats(&after)
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	for _, ma := range []MatchAlgorithm{HashTable4, BinaryTree} {
+		full := allocated(WriterConfig{DictCap: dictCap, Matcher: ma})
+		small := allocated(WriterConfig{
+			DictCap:            dictCap,
+			MatchFinderDictCap: 1 << 18,
+			Matcher:            ma,
+		})
+		t.Logf("%s: allocated %d bytes; with MatchFinderDictCap %d",
+			ma, full, small)
+		if small >= full/4 {
+			t.Errorf("%s: MatchFinderDictCap doesn't reduce memory",
+				ma)
+		}
+	}
+
+	stream := compressWithConfig(t, WriterConfig{
+		DictCap:            dictCap,
+		MatchFinderDictCap: 1 << 16,
+	}, txt)
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p := r.Parameters(); p.DictSize != dictCap {
+		t.Fatalf("header declares dictionary size %d; want %d",
+			p.DictSize, dictCap)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, txt) {
+		t.Fatalf("decompressed data differs")
+	}
+
+	_, err = WriterConfig{
+		DictCap:            MinDictCap,
+		MatchFinderDictCap: 2 * MinDictCap,
+	}.NewWriter(ioutil.Discard)
+	if err == nil {
+		t.Fatalf("NewWriter accepted MatchFinderDictCap > DictCap")
+	}
+}
+
+func TestWriterSmallInputBufferLimit(t *testing.T) {
+	const limit = 1000
+	tests := []struct {
+		n        int
+		buffered bool
+	}{
+		{0, true},
+		{limit - 1, true},
+		{limit, true},
+		{limit + 1, false},
+		{10 * limit, false},
+	}
+	for _, tc := range tests {
+		data := periodicData(tc.n, int64(tc.n))
+		var buf bytes.Buffer
+		c := WriterConfig{SmallInputBufferLimit: limit}
+		w, err := c.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("n=%d: NewWriter error %s", tc.n, err)
+		}
+		// write in pieces to cross the limit inside a call
+		for p := data; len(p) > 0; {
+			k := 300
+			if k > len(p) {
+				k = len(p)
+			}
+			if _, err = w.Write(p[:k]); err != nil {
+				t.Fatalf("n=%d: Write error %s", tc.n, err)
+			}
+			p = p[k:]
+		}
+		if tc.buffered && buf.Len() > 0 {
+			t.Fatalf("n=%d: %d bytes written before Close",
+				tc.n, buf.Len())
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("n=%d: Close error %s", tc.n, err)
+		}
+		r, err := NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("n=%d: NewReader error %s", tc.n, err)
+		}
+		p := r.Parameters()
+		if tc.buffered {
+			if !p.SizeInHeader || p.Size != int64(tc.n) {
+				t.Fatalf("n=%d: header size %d (%t); want %d",
+					tc.n, p.Size, p.SizeInHeader, tc.n)
+			}
+		} else if p.SizeInHeader {
+			t.Fatalf("n=%d: size %d in header; want unknown",
+				tc.n, p.Size)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("n=%d: ReadAll error %s", tc.n, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("n=%d: decoded data differs", tc.n)
+		}
+		if r.EOSMarker() == tc.buffered {
+			t.Fatalf("n=%d: EOS marker %t; want %t", tc.n,
+				r.EOSMarker(), !tc.buffered)
+		}
+	}
+
+	c := WriterConfig{SmallInputBufferLimit: limit, NoHeader: true}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted SmallInputBufferLimit with NoHeader")
+	}
+}
+
+// countingOnlyWriter counts the bytes written. It deliberately doesn't
+// support io.ByteWriter.
+type countingOnlyWriter struct{ n int64 }
+
+func (w *countingOnlyWriter) Write(p []byte) (n int, err error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestWriterFinalSize(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		c    WriterConfig
+	}{
+		{"empty", 0, WriterConfig{}},
+		{"eos", 100000, WriterConfig{}},
+		{"size", 100000, WriterConfig{Size: 100000}},
+		{"sizeAndEOS", 5000,
+			WriterConfig{Size: 5000, EOSMarker: true}},
+		{"noHeader", 7000, WriterConfig{NoHeader: true}},
+		{"small", 500, WriterConfig{SmallInputBufferLimit: 1000}},
+	}
+	for _, tc := range tests {
+		data := periodicData(tc.n, 1)
+		var cw countingOnlyWriter
+		w, err := tc.c.NewWriter(&cw)
+		if err != nil {
+			t.Fatalf("%s: NewWriter error %s", tc.name, err)
+		}
+		if _, err = w.FinalSize(); err == nil {
+			t.Fatalf("%s: FinalSize before Close: no error",
+				tc.name)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("%s: Write error %s", tc.name, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", tc.name, err)
+		}
+		n, err := w.FinalSize()
+		if err != nil {
+			t.Fatalf("%s: FinalSize error %s", tc.name, err)
+		}
+		if n != cw.n {
+			t.Fatalf("%s: FinalSize %d; underlying writer got %d",
+				tc.name, n, cw.n)
+		}
+		if m := int64(len(compressWithConfig(t, tc.c, data))); n != m {
+			t.Fatalf("%s: FinalSize %d; want %d", tc.name, n, m)
+		}
+	}
+}
+
+// smallAlphabetData returns random bytes from an alphabet of 16
+// symbols. The data contains many short matches at large distances,
+// which are more expensive than literals.
+func smallAlphabetData(n int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(rnd.Intn(16))
+	}
+	return p
+}
+
+func TestWriterMinMatch(t *testing.T) {
+	data := smallAlphabetData(1<<18, 1)
+	plain := compressWithConfig(t, WriterConfig{}, data)
+	clamped := compressWithConfig(t, WriterConfig{MinMatch: 1}, data)
+	if !bytes.Equal(clamped, plain) {
+		t.Fatalf("MinMatch 1 changes the output")
+	}
+	for _, mm := range []int{3, 6, 32} {
+		for _, optimal := range []bool{false, true} {
+			c := WriterConfig{MinMatch: mm, OptimalParse: optimal}
+			z := compressWithConfig(t, c, data)
+			r, err := NewReader(bytes.NewReader(z))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("MinMatch %d: decoded data differs", mm)
+			}
+		}
+	}
+	z := compressWithConfig(t, WriterConfig{MinMatch: 6}, data)
+	if len(z) >= len(plain) {
+		t.Fatalf("MinMatch 6: got %d bytes; want less than %d",
+			len(z), len(plain))
+	}
+	c := WriterConfig{MinMatch: maxMatchLen + 1}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted MinMatch %d", c.MinMatch)
+	}
+}
+
+func BenchmarkWriterMinMatch(b *testing.B) {
+	data := smallAlphabetData(1<<20, 1)
+	for _, mm := range []int{0, 4, 6} {
+		c := WriterConfig{MinMatch: mm}
+		b.Run(fmt.Sprintf("MinMatch%d", mm), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var n int
+			for i := 0; i < b.N; i++ {
+				n = len(compressWithConfig(b, c, data))
+			}
+			b.ReportMetric(float64(n)/float64(len(data)), "rate")
+		})
+	}
+}
+
+// checkBarriers decodes the raw operations of the LZMA stream z and
+// reports matches crossing one of the barriers.
+func checkBarriers(t *testing.T, z []byte, barriers []int64) int {
+	var h header
+	if err := h.unmarshalBinary(z[:HeaderLen]); err != nil {
+		t.Fatalf("unmarshalBinary error %s", err)
+	}
+	dict, err := newDecoderDict(h.dictCap)
+	if err != nil {
+		t.Fatalf("newDecoderDict error %s", err)
+	}
+	d, err := newDecoder(bytes.NewReader(z[HeaderLen:]),
+		newState(h.properties), dict, h.size)
+	if err != nil {
+		t.Fatalf("newDecoder error %s", err)
+	}
+	matches := 0
+	out := make([]byte, 1<<16)
+	for {
+		if d.Dict.Available() < maxMatchLen {
+			d.Dict.Read(out)
+		}
+		if h.size >= 0 && d.Decompressed() == h.size {
+			return matches
+		}
+		pos := d.Dict.pos()
+		op, err := d.readOp()
+		if err == errEOS {
+			return matches
+		}
+		if err != nil {
+			t.Fatalf("readOp error %s", err)
+		}
+		if m, ok := op.(match); ok {
+			matches++
+			for _, b := range barriers {
+				if pos < b && b < pos+int64(m.n) {
+					t.Fatalf("match %v at %d crosses "+
+						"barrier %d", m, pos, b)
+				}
+				if pos >= b && pos-m.distance < b {
+					t.Fatalf("match %v at %d references "+
+						"data before barrier %d",
+						m, pos, b)
+				}
+			}
+		}
+		if err = d.apply(op); err != nil {
+			t.Fatalf("apply error %s", err)
+		}
+	}
+}
+
+func TestWriterBarrier(t *testing.T) {
+	// repeated records ensure that matches would cross the barriers
+	var records [][]byte
+	for i := 0; i < 200; i++ {
+		records = append(records,
+			[]byte("record: The quick brown fox jumps over the "+
+				"lazy dog.\n"))
+		records = append(records, periodicData(100+i, int64(i%5)))
+	}
+	for _, c := range []WriterConfig{
+		{},
+		{OptimalParse: true},
+		{Matcher: BinaryTree},
+		{SmallInputBufferLimit: 1 << 20},
+	} {
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		var (
+			barriers []int64
+			data     []byte
+		)
+		for i, rec := range records {
+			if i%3 == 0 {
+				if err = w.Barrier(); err != nil {
+					t.Fatalf("Barrier error %s", err)
+				}
+				barriers = append(barriers, int64(len(data)))
+			}
+			if _, err = w.Write(rec); err != nil {
+				t.Fatalf("Write error %s", err)
+			}
+			data = append(data, rec...)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		z := buf.Bytes()
+		if n := checkBarriers(t, z, barriers); n == 0 {
+			t.Fatalf("no matches found")
+		}
+		r, err := NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("decoded data differs")
+		}
+		z = compressWithConfig(t, c, data)
+		if n := checkBarriers(t, z, nil); n == 0 {
+			t.Fatalf("no matches found without barriers")
+		}
+	}
+}
+
+func TestWriterWriteReaders(t *testing.T) {
+	head := "HEADER\n"
+	body, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(5)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	tail := strings.Repeat("tail ", 1000)
+	want := head + string(body) + tail
+	readers := func() []io.Reader {
+		return []io.Reader{
+			strings.NewReader(head),
+			bytes.NewReader(body),
+			iotest.OneByteReader(strings.NewReader(tail)),
+		}
+	}
+	total := int64(len(want))
+	tests := []struct {
+		c    WriterConfig
+		size int64
+	}{
+		{WriterConfig{}, -1},
+		{WriterConfig{Size: total}, total},
+		{WriterConfig{SmallInputBufferLimit: 1 << 20}, total},
+		{WriterConfig{MaxWorkPerCall: 1000}, -1},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		w, err := tc.c.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		n, err := w.WriteReaders(readers()...)
+		if err != nil {
+			t.Fatalf("WriteReaders error %s", err)
+		}
+		if n != total {
+			t.Fatalf("WriteReaders returned %d; want %d", n, total)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		z := buf.Bytes()
+		if size := int64(uint64LE(z[5:13])); size != tc.size {
+			t.Fatalf("header size %d; want %d", size, tc.size)
+		}
+		r, err := NewReader(bytes.NewReader(z))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(got) != want {
+			t.Fatalf("decoded data differs")
+		}
+	}
+
+	w, err := WriterConfig{Size: total - 1}.NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n, err := w.WriteReaders(readers()...)
+	if err != ErrNoSpace || n != total-1 {
+		t.Fatalf("WriteReaders returned %d, %v; want %d, %v",
+			n, err, total-1, ErrNoSpace)
+	}
+}
+
+func TestWriterGreedyMatch(t *testing.T) {
+	corpora := decodeCorpora(t)
+	for _, name := range []string{"enwik", "randtxt", "random"} {
+		data, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		data = data[:len(data)/4]
+		for _, ma := range []MatchAlgorithm{HashTable4, BinaryTree} {
+			stream := compressWithConfig(t, WriterConfig{
+				Matcher:     ma,
+				GreedyMatch: true,
+			}, data)
+			r, err := NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %s: ReadAll error %s", name, ma, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s %s: decompressed data differs",
+					name, ma)
+			}
+		}
+	}
+	c := WriterConfig{GreedyMatch: true, OptimalParse: true}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted GreedyMatch with OptimalParse")
+	}
+	c2 := Writer2Config{GreedyMatch: true, OptimalParse: true}
+	if err := c2.Verify(); err == nil {
+		t.Fatalf("Writer2Config.Verify accepted GreedyMatch" +
+			" with OptimalParse")
+	}
+}
+
+func BenchmarkWriterGreedyMatch(b *testing.B) {
+	corpora := decodeCorpora(b)
+	for _, name := range []string{"enwik", "randtxt", "random"} {
+		data, ok := corpora[name]
+		if !ok {
+			continue
+		}
+		for _, greedy := range []bool{false, true} {
+			mode := "default"
+			if greedy {
+				mode = "greedy"
+			}
+			c := WriterConfig{GreedyMatch: greedy}
+			b.Run(name+"/"+mode, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				var n int
+				for i := 0; i < b.N; i++ {
+					n = len(compressWithConfig(b, c, data))
+				}
+				b.ReportMetric(
+					float64(n)/float64(len(data)), "rate")
+			})
+		}
+	}
+}
+
+// TestWriterReferenceOutput compares the output of the writer with the
+// output of xz --format=lzma of XZ Utils 5.6.4 for inputs that allow no
+// choice of operations. Both tools must then produce the same bytes
+// including the final bytes of the range encoder.
+func TestWriterReferenceOutput(t *testing.T) {
+	const header = "5d00008000ffffffffffffffff"
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "0083fffbffffc0000000"},
+		{"a", "0030c1fbffffffe0000000"},
+		{"ab", "0030989cfffffffff0000000"},
+		{"abcdefgh", "00309888983ecbe26f3881b990fffd001000"},
+	}
+	for _, tc := range tests {
+		z := compressWithConfig(t, WriterConfig{DictCap: 8 << 20},
+			[]byte(tc.in))
+		got := hex.EncodeToString(z)
+		if got != header+tc.want {
+			t.Errorf("%q: got %s; want %s", tc.in, got,
+				header+tc.want)
+		}
+	}
+}
+
+func TestWriterEffectiveDictSize(t *testing.T) {
+	block := make([]byte, 5100)
+	rand.New(rand.NewSource(196)).Read(block)
+	// The repetition of the first 5000 bytes is the longest
+	// back-reference.
+	data := append(append([]byte{}, block...), block[:5000]...)
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 20}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if n := w.EffectiveDictSize(); n != int64(len(block)) {
+		t.Fatalf("EffectiveDictSize returned %d; want %d", n,
+			len(block))
+	}
+
+	// Without matches the value is zero.
+	buf.Reset()
+	w, err = WriterConfig{}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte("abcdefgh")); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if n := w.EffectiveDictSize(); n != 0 {
+		t.Fatalf("EffectiveDictSize returned %d; want 0", n)
+	}
+
+	// Small input is buffered before Close.
+	buf.Reset()
+	w, err = WriterConfig{SmallInputBufferLimit: 1 << 10}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data[:100]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if n := w.EffectiveDictSize(); n != 0 {
+		t.Fatalf("EffectiveDictSize returned %d before Close;"+
+			" want 0", n)
+	}
+}
+
+func TestWriterMaxCompressedSize(t *testing.T) {
+	data := make([]byte, 1<<16)
+	rand.New(rand.NewSource(200)).Read(data)
+	const limit = 1000
+	var buf bytes.Buffer
+	w, err := WriterConfig{MaxCompressedSize: limit}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	var consumed int
+	for consumed < len(data) {
+		if _, err = w.Write(data[consumed : consumed+1024]); err != nil {
+			break
+		}
+		consumed += 1024
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != ErrOutputTooLarge {
+		t.Fatalf("got error %v; want %v", err, ErrOutputTooLarge)
+	}
+	if consumed >= len(data)/2 {
+		t.Fatalf("error reported after %d of %d bytes", consumed,
+			len(data))
+	}
+	if buf.Len() > limit {
+		t.Fatalf("wrote %d bytes; limit %d", buf.Len(), limit)
+	}
+	if _, err = w.Write(data[:1]); err != ErrOutputTooLarge {
+		t.Fatalf("Write after limit: got error %v; want %v", err,
+			ErrOutputTooLarge)
+	}
+
+	// A sufficient limit doesn't change the output.
+	buf.Reset()
+	w, err = WriterConfig{MaxCompressedSize: 2 * int64(len(data))}.
+		NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("decompressed data differs")
+	}
+}
@@ -34,7 +34,10 @@ func (f lzmaFilter) id() uint64 { return lzmaFilterID }
 
 // MarshalBinary converts the lzmaFilter in its encoded representation.
 func (f lzmaFilter) MarshalBinary() (data []byte, err error) {
-	c := lzma.EncodeDictCap(f.dictCap)
+	c, err := lzma.EncodeDictSizeByte(f.dictCap)
+	if err != nil {
+		return nil, err
+	}
 	return []byte{lzmaFilterID, 1, c}, nil
 }
 
@@ -50,8 +53,8 @@ func (f *lzmaFilter) UnmarshalBinary(data []byte) error {
 	if data[1] != 1 {
 		return errors.New("xz: wrong LZMA2 filter size")
 	}
-	dc, err := lzma.DecodeDictCap(data[2])
-	if err != nil {
+	dc := lzma.DecodeDictSizeByte(data[2])
+	if dc < 0 {
 		return errors.New("xz: wrong LZMA2 dictionary size property")
 	}
 
@@ -89,10 +92,11 @@ func (f lzmaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
 	config := new(lzma.Writer2Config)
 	if c != nil {
 		*config = lzma.Writer2Config{
-			Properties: c.Properties,
-			DictCap:    c.DictCap,
-			BufSize:    c.BufSize,
-			Matcher:    c.Matcher,
+			Properties:          c.Properties,
+			DictCap:             c.DictCap,
+			BufSize:             c.BufSize,
+			Matcher:             c.Matcher,
+			StoreIncompressible: c.StoreIncompressible,
 		}
 	}
 
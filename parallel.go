@@ -0,0 +1,95 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/ordered"
+	"github.com/ulikunitz/xz/internal/xlog"
+)
+
+// ParallelDecompress decodes the given blocks of the xz file provided
+// by r and writes the uncompressed data to w. The blocks are usually
+// provided by ReadIndex; a subset of them decodes only the respective
+// part of the file. The blocks are decoded by the given number of go
+// routines concurrently, but are written to w strictly in the order
+// of the slice. Blocks that have been decoded out of order are
+// buffered until all preceding blocks have been written; at most twice
+// the number of workers blocks are held in memory. The function
+// returns the number of bytes written to w.
+//
+// The check of every block is verified and its sizes must match the
+// index. The configuration c may be nil, in which case default values
+// are used. The options SingleStream, MaxDecompressedSize and
+// AlignedReads are ignored.
+func ParallelDecompress(r io.ReaderAt, blocks []BlockInfo, w io.Writer,
+	workers int, c *ReaderConfig) (n int64, err error) {
+
+	if workers <= 0 {
+		return 0, errors.New("xz: number of workers must be positive")
+	}
+	var cfg ReaderConfig
+	if c != nil {
+		cfg = *c
+	}
+	if err = cfg.Verify(); err != nil {
+		return 0, err
+	}
+	for _, b := range blocks {
+		if b.Offset < 0 || b.UnpaddedSize <= 0 ||
+			b.UncompressedSize < 0 {
+			return 0, errors.New(
+				"xz: negative block offset or size")
+		}
+	}
+	return ordered.Write(w, len(blocks), workers,
+		func(i int) ([]byte, error) {
+			return cfg.decompressBlock(r, blocks[i])
+		})
+}
+
+// errBlockIndex indicates that a block doesn't match its index record.
+var errBlockIndex = errors.New("xz: block doesn't match index")
+
+// decompressBlock decodes the block b and checks it against the sizes
+// from the index.
+func (c *ReaderConfig) decompressBlock(r io.ReaderAt, b BlockInfo) (
+	p []byte, err error) {
+
+	newHash, err := newHashFunc(b.flags)
+	if err != nil {
+		return nil, err
+	}
+	sr := io.NewSectionReader(r, b.Offset, b.paddedSize())
+	bh, hlen, err := readBlockHeader(sr)
+	if err == errHeaderPadding && c.TolerantPadding {
+		xlog.Debugf("%s; ignored", err)
+		err = nil
+	}
+	if err != nil {
+		if err == errIndexIndicator {
+			err = errBlockIndex
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	br, err := c.newBlockReader(sr, bh, hlen, newHash())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(io.LimitReader(br,
+		b.UncompressedSize+1)); err != nil {
+		return nil, err
+	}
+	if br.record() != (record{b.UnpaddedSize, b.UncompressedSize}) {
+		return nil, errBlockIndex
+	}
+	return buf.Bytes(), nil
+}
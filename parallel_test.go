@@ -0,0 +1,78 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParallelDecompress(t *testing.T) {
+	txt, data := multiBlockFile(t, 200000, 8192)
+	blocks, err := ReadIndex(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("ReadIndex error %s", err)
+	}
+	if len(blocks) < 10 {
+		t.Fatalf("file has only %d blocks", len(blocks))
+	}
+
+	// serial decode for comparison
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	serial, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		var out bytes.Buffer
+		n, err := ParallelDecompress(bytes.NewReader(data), blocks,
+			&out, workers, nil)
+		if err != nil {
+			t.Fatalf("workers %d: ParallelDecompress error %s",
+				workers, err)
+		}
+		if n != int64(len(txt)) {
+			t.Fatalf("workers %d: got %d bytes; want %d",
+				workers, n, len(txt))
+		}
+		if !bytes.Equal(out.Bytes(), serial) {
+			t.Fatalf("workers %d: output differs from serial"+
+				" decode", workers)
+		}
+	}
+
+	// a subset of the blocks decodes the respective part
+	sub := blocks[3:7]
+	var out bytes.Buffer
+	if _, err = ParallelDecompress(bytes.NewReader(data), sub, &out, 2,
+		nil); err != nil {
+		t.Fatalf("ParallelDecompress error %s", err)
+	}
+	start := sub[0].UncompressedOffset
+	end := sub[3].UncompressedOffset + sub[3].UncompressedSize
+	if !bytes.Equal(out.Bytes(), txt[start:end]) {
+		t.Fatalf("output of blocks 3 to 6 differs")
+	}
+
+	// corrupt data and wrong sizes must be detected
+	corrupt := append([]byte(nil), data...)
+	b := blocks[5]
+	corrupt[b.Offset+b.UnpaddedSize/2] ^= 0x10
+	if _, err = ParallelDecompress(bytes.NewReader(corrupt), blocks,
+		ioutil.Discard, 3, nil); err == nil {
+		t.Fatalf("ParallelDecompress of corrupt block: no error")
+	}
+	bad := append([]BlockInfo(nil), blocks...)
+	bad[2].UncompressedSize--
+	if _, err = ParallelDecompress(bytes.NewReader(data), bad,
+		ioutil.Discard, 3, nil); err == nil {
+		t.Fatalf("ParallelDecompress with wrong size: no error")
+	}
+}
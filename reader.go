@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
 
 	"github.com/ulikunitz/xz/internal/xlog"
 	"github.com/ulikunitz/xz/lzma"
@@ -21,9 +22,29 @@ import (
 // ReaderConfig defines the parameters for the xz reader. The
 // SingleStream parameter requests the reader to assume that the
 // underlying stream contains only a single stream.
+// MaxDecompressedSize limits the number of bytes returned by the
+// reader; the value zero indicates no limit.
+//
+// TolerantPadding accepts non-zero bytes in the padding of block
+// headers, blocks and indexes, which are produced by some buggy tools.
+// The padding is ignored and logged as a debug message. The checksums
+// covering the padding are still verified. Stream padding must still
+// consist of zero bytes, because it couldn't be distinguished from
+// garbage otherwise. The default rejects non-zero padding.
+//
+// AlignedReads sets the size of the reads issued to the underlying
+// reader. If the value is positive, the reader requests only blocks of
+// that size at offsets that are multiples of it and buffers the data
+// internally. The value zero reads only what is required. With aligned
+// reads the underlying reader may be read beyond the end of the
+// streams, so Multistream(false) doesn't position it directly after
+// the stream.
 type ReaderConfig struct {
-	DictCap      int
-	SingleStream bool
+	DictCap             int
+	SingleStream        bool
+	MaxDecompressedSize int64
+	TolerantPadding     bool
+	AlignedReads        int
 }
 
 // Verify checks the reader parameters for Validity. Zero values will be
@@ -36,6 +57,12 @@ func (c *ReaderConfig) Verify() error {
 	if err := lc.Verify(); err != nil {
 		return err
 	}
+	if c.MaxDecompressedSize < 0 {
+		return errors.New("xz: negative MaxDecompressedSize")
+	}
+	if c.AlignedReads < 0 {
+		return errors.New("xz: negative AlignedReads")
+	}
 	return nil
 }
 
@@ -45,6 +72,12 @@ type Reader struct {
 
 	xz io.Reader
 	sr *streamReader
+	// number of bytes returned by Read
+	n int64
+	// stop after the current stream
+	noMultistream bool
+	// blocks of the streams completed
+	blocks []BlockSizes
 }
 
 // streamReader decodes a single xz stream
@@ -56,6 +89,7 @@ type streamReader struct {
 	newHash func() hash.Hash
 	h       header
 	index   []record
+	blocks  []BlockSizes
 }
 
 // NewReader creates a new xz reader using the default parameters.
@@ -72,6 +106,9 @@ func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
+	if c.AlignedReads > 0 {
+		xz = newAlignedReader(xz, c.AlignedReads)
+	}
 	r = &Reader{
 		ReaderConfig: c,
 		xz:           xz,
@@ -85,12 +122,74 @@ func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	return r, nil
 }
 
+// VerifyStream checks whether xz provides valid xz streams. All blocks
+// are decoded and their checksums verified, but the uncompressed data
+// is discarded. The function returns nil if no error has been found.
+func VerifyStream(xz io.Reader) error {
+	r, err := NewReader(xz)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
 var errUnexpectedData = errors.New("xz: unexpected data after stream")
 
+// ErrSizeLimit indicates that the decompressed data exceeds the
+// MaxDecompressedSize value of the reader configuration.
+var ErrSizeLimit = errors.New("xz: decompressed size limit exceeded")
+
 // Read reads uncompressed data from the stream.
 func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.MaxDecompressedSize <= 0 {
+		return r.read(p)
+	}
+	// We read one byte more than allowed to detect the overflow.
+	m := r.MaxDecompressedSize - r.n
+	if int64(len(p)) > m {
+		p = p[:m+1]
+	}
+	n, err = r.read(p)
+	if int64(n) > m {
+		n, err = int(m), ErrSizeLimit
+	}
+	r.n += int64(n)
+	return n, err
+}
+
+// Blocks returns the sizes of all blocks the reader has completely
+// decoded so far, in the order of the blocks in the streams.
+func (r *Reader) Blocks() []BlockSizes {
+	blocks := append([]BlockSizes(nil), r.blocks...)
+	if r.sr != nil {
+		blocks = append(blocks, r.sr.blocks...)
+	}
+	return blocks
+}
+
+// Multistream controls whether the reader supports multistream files.
+// It works like the method of the same name of compress/gzip's Reader.
+//
+// If enabled (the default), the reader decodes all concatenated streams
+// and stream padding as a single sequence of data. If disabled, Read
+// returns io.EOF at the end of the current stream without reading any
+// further bytes from the underlying reader. The underlying reader is
+// then positioned directly after the stream, so data of another
+// format following the stream can be read from it. Unlike
+// SingleStream, no error is reported for data after the stream.
+func (r *Reader) Multistream(ok bool) {
+	r.noMultistream = !ok
+}
+
+// read reads uncompressed data from the streams without checking the
+// size limit.
+func (r *Reader) read(p []byte) (n int, err error) {
 	for n < len(p) {
 		if r.sr == nil {
+			if r.noMultistream {
+				return n, io.EOF
+			}
 			if r.SingleStream {
 				data := make([]byte, 1)
 				_, err = io.ReadFull(r.xz, data)
@@ -113,6 +212,7 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		n += k
 		if err != nil {
 			if err == io.EOF {
+				r.blocks = append(r.blocks, r.sr.blocks...)
 				r.sr = nil
 				continue
 			}
@@ -122,6 +222,52 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// RecoverNextStream supports the recovery of data from files
+// consisting of multiple streams after Read returned an error. It
+// skips the rest of the current stream and searches for the header of
+// the next stream. If a valid header is found, the following Read
+// calls return the data of the new stream. If no further stream is
+// found io.EOF is returned.
+//
+// The recovery is best-effort and lossy: the remaining data of the
+// corrupt stream is lost and the search can be fooled by data looking
+// like a stream header. The reader must not have been configured with
+// SingleStream or Multistream(false).
+func (r *Reader) RecoverNextStream() error {
+	if r.SingleStream || r.noMultistream {
+		return errors.New(
+			"xz: RecoverNextStream requires multiple streams")
+	}
+	if r.sr != nil {
+		r.blocks = append(r.blocks, r.sr.blocks...)
+	}
+	r.sr = nil
+	br := lzma.ByteReader(r.xz)
+	window := make([]byte, 0, HeaderLen)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if len(window) == HeaderLen {
+			copy(window, window[1:])
+			window = window[:HeaderLen-1]
+		}
+		window = append(window, c)
+		if len(window) < HeaderLen ||
+			!bytes.HasPrefix(window, headerMagic) {
+			continue
+		}
+		sr, err := r.ReaderConfig.streamReaderWithHeader(r.xz, window)
+		if err != nil {
+			continue
+		}
+		xlog.Debugf("xz header %s recovered", sr.h)
+		r.sr = sr
+		return nil
+	}
+}
+
 var errPadding = errors.New("xz: padding (4 zero bytes) encountered")
 
 // newStreamReader creates a new xz stream reader using the given configuration
@@ -143,6 +289,13 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 		}
 		return nil, err
 	}
+	return c.streamReaderWithHeader(xz, data)
+}
+
+// streamReaderWithHeader creates a stream reader for a stream whose
+// header data has already been read from xz.
+func (c ReaderConfig) streamReaderWithHeader(xz io.Reader, data []byte) (
+	r *streamReader, err error) {
 	r = &streamReader{
 		ReaderConfig: c,
 		xz:           xz,
@@ -161,6 +314,10 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 // readTail reads the index body and the xz footer.
 func (r *streamReader) readTail() error {
 	index, n, err := readIndexBody(r.xz, len(r.index))
+	if err == errIndexPadding && r.TolerantPadding {
+		xlog.Debugf("%s; ignored", err)
+		err = nil
+	}
 	if err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
@@ -201,6 +358,10 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 	for n < len(p) {
 		if r.br == nil {
 			bh, hlen, err := readBlockHeader(r.xz)
+			if err == errHeaderPadding && r.TolerantPadding {
+				xlog.Debugf("%s; ignored", err)
+				err = nil
+			}
 			if err != nil {
 				if err == errIndexIndicator {
 					if err = r.readTail(); err != nil {
@@ -222,6 +383,7 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 		if err != nil {
 			if err == io.EOF {
 				r.index = append(r.index, r.br.record())
+				r.blocks = append(r.blocks, r.br.sizes())
 				r.br = nil
 			} else {
 				return n, err
@@ -252,6 +414,8 @@ type blockReader struct {
 	n         int64
 	hash      hash.Hash
 	r         io.Reader
+	// ignore non-zero block padding
+	tolerantPadding bool
 }
 
 // newBlockReader creates a new block reader.
@@ -259,10 +423,11 @@ func (c *ReaderConfig) newBlockReader(xz io.Reader, h *blockHeader,
 	hlen int, hash hash.Hash) (br *blockReader, err error) {
 
 	br = &blockReader{
-		lxz:       countingReader{r: xz},
-		header:    h,
-		headerLen: hlen,
-		hash:      hash,
+		lxz:             countingReader{r: xz},
+		header:          h,
+		headerLen:       hlen,
+		hash:            hash,
+		tolerantPadding: c.TolerantPadding,
 	}
 
 	fr, err := c.newFilterReader(&br.lxz, h.filters)
@@ -301,24 +466,62 @@ func (br *blockReader) record() record {
 	return record{br.unpaddedSize(), br.uncompressedSize()}
 }
 
-// Read reads data from the block.
+// BlockSizes provides the sizes of a block. The compressed and
+// uncompressed size fields of the block header are optional; their
+// values are -1 if the header doesn't contain them. If present they
+// have been checked against the actual sizes. The compressed size
+// doesn't include the block header, the block padding and the check.
+type BlockSizes struct {
+	HeaderCompressedSize   int64
+	HeaderUncompressedSize int64
+	CompressedSize         int64
+	UncompressedSize       int64
+}
+
+// sizes returns the sizes of the block.
+func (br *blockReader) sizes() BlockSizes {
+	return BlockSizes{
+		HeaderCompressedSize:   br.header.compressedSize,
+		HeaderUncompressedSize: br.header.uncompressedSize,
+		CompressedSize:         br.compressedSize(),
+		UncompressedSize:       br.uncompressedSize(),
+	}
+}
+
+// Errors returned if the sizes given in the block header don't match
+// the actual sizes of the block.
+var (
+	errUncompressedSize = errors.New(
+		"xz: wrong uncompressed size for block")
+	errCompressedSize = errors.New("xz: wrong compressed size for block")
+)
+
+// errBlockPadding indicates non-zero bytes in the block padding.
+var errBlockPadding = errors.New("xz: non-zero block padding")
+
+// Read reads data from the block. If the block header contains the
+// compressed or uncompressed size, they are checked against the
+// actual sizes of the block.
 func (br *blockReader) Read(p []byte) (n int, err error) {
 	n, err = br.r.Read(p)
 	br.n += int64(n)
 
 	u := br.header.uncompressedSize
 	if u >= 0 && br.uncompressedSize() > u {
-		return n, errors.New("xz: wrong uncompressed size for block")
+		return n, errUncompressedSize
 	}
 	c := br.header.compressedSize
 	if c >= 0 && br.compressedSize() > c {
-		return n, errors.New("xz: wrong compressed size for block")
+		return n, errCompressedSize
 	}
 	if err != io.EOF {
 		return n, err
 	}
-	if br.uncompressedSize() < u || br.compressedSize() < c {
-		return n, io.ErrUnexpectedEOF
+	if br.uncompressedSize() < u {
+		return n, errUncompressedSize
+	}
+	if br.compressedSize() < c {
+		return n, errCompressedSize
 	}
 
 	s := br.hash.Size()
@@ -331,7 +534,10 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 		return n, err
 	}
 	if !allZeros(q[:k]) {
-		return n, errors.New("xz: non-zero block padding")
+		if !br.tolerantPadding {
+			return n, errBlockPadding
+		}
+		xlog.Debugf("%s; ignored", errBlockPadding)
 	}
 	checkSum := q[k:]
 	computedSum := br.hash.Sum(checkSum[s:])
@@ -6,10 +6,16 @@ package xz
 
 import (
 	"bytes"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
+
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestReaderSimple(t *testing.T) {
@@ -135,3 +141,514 @@ func BenchmarkReader(b *testing.B) {
 		}
 	}
 }
+
+func TestVerifyStream(t *testing.T) {
+	data, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	if err = VerifyStream(bytes.NewReader(data)); err != nil {
+		t.Fatalf("VerifyStream error %s", err)
+	}
+	corrupt := append([]byte(nil), data...)
+	// last byte of the CRC-64 checksum of the block; the index has 8 bytes
+	corrupt[len(corrupt)-footerLen-8-1] ^= 0xff
+	if err = VerifyStream(bytes.NewReader(corrupt)); err == nil {
+		t.Fatalf("VerifyStream of corrupted stream returned nil")
+	}
+	t.Logf("VerifyStream of corrupted stream: %s", err)
+}
+
+func TestReaderMaxDecompressedSize(t *testing.T) {
+	const size = 1 << 20
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(make([]byte, size)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	const limit = 4097
+	r, err := ReaderConfig{MaxDecompressedSize: limit}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != ErrSizeLimit {
+		t.Fatalf("io.Copy returned error %v; want %v", err,
+			ErrSizeLimit)
+	}
+	if n != limit {
+		t.Fatalf("io.Copy returned %d bytes; want %d", n, limit)
+	}
+
+	r, err = ReaderConfig{MaxDecompressedSize: size}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if n, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if n != size {
+		t.Fatalf("io.Copy returned %d bytes; want %d", n, size)
+	}
+}
+
+// buildStream creates an xz stream with a single block for txt. The
+// function sizes receives the actual compressed and uncompressed size
+// of the block and returns the sizes to put into the block header;
+// negative values mean that the size is not stored.
+func buildStream(t *testing.T, txt []byte,
+	sizes func(c, u int64) (hc, hu int64)) []byte {
+
+	var cbuf bytes.Buffer
+	w2, err := lzma.Writer2Config{DictCap: lzma.MinDictCap}.NewWriter2(
+		&cbuf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w2.Write(txt); err != nil {
+		t.Fatalf("w2.Write error %s", err)
+	}
+	if err = w2.Close(); err != nil {
+		t.Fatalf("w2.Close error %s", err)
+	}
+	c, u := int64(cbuf.Len()), int64(len(txt))
+
+	var buf bytes.Buffer
+	h := header{flags: CRC32}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("h.MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	bh := blockHeader{filters: []filter{&lzmaFilter{lzma.MinDictCap}}}
+	bh.compressedSize, bh.uncompressedSize = sizes(c, u)
+	data, err = bh.MarshalBinary()
+	if err != nil {
+		t.Fatalf("bh.MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	buf.Write(cbuf.Bytes())
+	buf.Write(make([]byte, padLen(c)))
+	crc := newCRC32()
+	crc.Write(txt)
+	buf.Write(crc.Sum(nil))
+	rec := record{int64(len(data)) + c + 4, u}
+	f := footer{flags: CRC32}
+	if f.indexSize, err = writeIndex(&buf, []record{rec}); err != nil {
+		t.Fatalf("writeIndex error %s", err)
+	}
+	if data, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("f.MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestReaderBlockHeaderSizes(t *testing.T) {
+	txt := []byte("The quick brown fox jumps over the lazy dog.")
+	tests := []struct {
+		name  string
+		sizes func(c, u int64) (int64, int64)
+		err   error
+	}{
+		{"none", func(c, u int64) (int64, int64) { return -1, -1 }, nil},
+		{"both", func(c, u int64) (int64, int64) { return c, u }, nil},
+		{"compressed", func(c, u int64) (int64, int64) {
+			return c, -1
+		}, nil},
+		{"uncompressed", func(c, u int64) (int64, int64) {
+			return -1, u
+		}, nil},
+		{"uncompressed too small", func(c, u int64) (int64, int64) {
+			return c, u - 1
+		}, errUncompressedSize},
+		{"uncompressed too large", func(c, u int64) (int64, int64) {
+			return c, u + 1
+		}, errUncompressedSize},
+		{"compressed too small", func(c, u int64) (int64, int64) {
+			return c - 1, u
+		}, errCompressedSize},
+		{"compressed too large", func(c, u int64) (int64, int64) {
+			return c + 1, u
+		}, errCompressedSize},
+	}
+	for _, tc := range tests {
+		data := buildStream(t, txt, tc.sizes)
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != tc.err {
+			t.Errorf("%s: ReadAll returned error %v; want %v",
+				tc.name, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(p, txt) {
+			t.Errorf("%s: got %q; want %q", tc.name, p, txt)
+		}
+		blocks := r.Blocks()
+		if len(blocks) != 1 {
+			t.Fatalf("%s: Blocks returned %d blocks; want 1",
+				tc.name, len(blocks))
+		}
+		c, u := blocks[0].CompressedSize, blocks[0].UncompressedSize
+		if u != int64(len(txt)) {
+			t.Errorf("%s: uncompressed size %d; want %d",
+				tc.name, u, len(txt))
+		}
+		hc, hu := tc.sizes(c, u)
+		if blocks[0].HeaderCompressedSize != hc ||
+			blocks[0].HeaderUncompressedSize != hu {
+			t.Errorf("%s: header sizes %d, %d; want %d, %d",
+				tc.name, blocks[0].HeaderCompressedSize,
+				blocks[0].HeaderUncompressedSize, hc, hu)
+		}
+	}
+
+	// The blocks of all streams are reported.
+	none := func(c, u int64) (int64, int64) { return -1, -1 }
+	data := append(buildStream(t, txt, none), buildStream(t, txt, none)...)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if n := len(r.Blocks()); n != 2 {
+		t.Fatalf("Blocks returned %d blocks for two streams; want 2",
+			n)
+	}
+}
+
+func TestReaderRecoverNextStream(t *testing.T) {
+	texts := []string{
+		"The quick brown fox jumps over the lazy dog.",
+		strings.Repeat("corrupt stream ", 100),
+		"Lorem ipsum dolor sit amet, consectetur adipiscing elit.",
+	}
+	var streams [][]byte
+	for _, text := range texts {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		streams = append(streams, buf.Bytes())
+	}
+	// corrupt the compressed data of the middle stream
+	streams[1][HeaderLen+20] ^= 0x55
+	var m []byte
+	m = append(m, streams[0]...)
+	m = append(m, streams[1]...)
+	m = append(m, 0, 0, 0, 0)
+	m = append(m, streams[2]...)
+
+	r, err := NewReader(bytes.NewReader(m))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatalf("ReadAll didn't detect the corrupt stream")
+	}
+	t.Logf("ReadAll error %s", err)
+	if !strings.HasPrefix(string(got), texts[0]) {
+		t.Fatalf("data of the first stream is missing")
+	}
+	if err = r.RecoverNextStream(); err != nil {
+		t.Fatalf("RecoverNextStream error %s", err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll after recovery error %s", err)
+	}
+	if string(got) != texts[2] {
+		t.Fatalf("ReadAll after recovery returned %q; want %q",
+			got, texts[2])
+	}
+	if err = r.RecoverNextStream(); err != io.EOF {
+		t.Fatalf("RecoverNextStream at end returned %v; want %v",
+			err, io.EOF)
+	}
+}
+
+// plainReader hides all methods of the underlying reader except Read.
+type plainReader struct {
+	r io.Reader
+}
+
+func (r plainReader) Read(p []byte) (n int, err error) {
+	return r.r.Read(p)
+}
+
+func TestReaderMultistream(t *testing.T) {
+	fox, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	const want = "The quick brown fox jumps over the lazy dog.\n"
+	const trailer = "trailing data of another format"
+	data := append(append([]byte{}, fox...), fox...)
+	data = append(data, trailer...)
+
+	// multistream mode decodes both streams but fails at the trailer
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatalf("ReadAll of multistream with trailer returned " +
+			"no error")
+	}
+
+	for _, name := range []string{"bytesReader", "plainReader"} {
+		var ur io.Reader = bytes.NewReader(data)
+		if name == "plainReader" {
+			ur = plainReader{ur}
+		}
+		r, err := NewReader(ur)
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", name, err)
+		}
+		r.Multistream(false)
+		for i := 0; i < 2; i++ {
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: ReadAll error %s", name, err)
+			}
+			if string(got) != want {
+				t.Fatalf("%s: stream %d decoded %q; want %q",
+					name, i, got, want)
+			}
+			if i == 0 {
+				if r, err = NewReader(ur); err != nil {
+					t.Fatalf("%s: NewReader error %s",
+						name, err)
+				}
+				r.Multistream(false)
+			}
+		}
+		rest, err := ioutil.ReadAll(ur)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", name, err)
+		}
+		if string(rest) != trailer {
+			t.Fatalf("%s: data after stream is %q; want %q",
+				name, rest, trailer)
+		}
+	}
+}
+
+// dirtyPaddingStreams returns xz streams of txt with a non-zero byte in
+// the block header padding, the block padding and the index padding.
+// It returns false if the block has no padding.
+func dirtyPaddingStreams(t *testing.T, txt []byte) (
+	streams map[string][]byte, ok bool) {
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: CRC32}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	z := buf.Bytes()
+	headerLen := (int(z[HeaderLen]) + 1) * 4
+	compressed := int(w.index[0].unpaddedSize) - headerLen - 4
+	if padLen(int64(compressed)) == 0 {
+		return nil, false
+	}
+	streams = make(map[string][]byte)
+	dirty := func(name string, i int, crcStart, crcEnd int) {
+		p := append([]byte(nil), z...)
+		if p[i] != 0 {
+			t.Fatalf("%s: byte %d is not padding", name, i)
+		}
+		p[i] = 0xa5
+		if crcEnd > 0 {
+			putUint32LE(p[crcEnd:],
+				crc32.ChecksumIEEE(p[crcStart:crcEnd]))
+		}
+		streams[name] = p
+	}
+	// The last byte before the CRC32 of the block header is padding.
+	dirty("header", HeaderLen+headerLen-5, HeaderLen,
+		HeaderLen+headerLen-4)
+	dirty("block", HeaderLen+headerLen+compressed, 0, 0)
+	// The backward size in the footer provides the index size.
+	indexEnd := len(z) - footerLen
+	indexSize := (int(uint32LE(z[indexEnd+4:])) + 1) * 4
+	dirty("index", indexEnd-5, indexEnd-indexSize, indexEnd-4)
+	return streams, true
+}
+
+func TestReaderTolerantPadding(t *testing.T) {
+	// With more than 127 bytes the index has padding.
+	txt := []byte(strings.Repeat(
+		"The quick brown fox jumps over the lazy dog. ", 3))
+	streams, ok := dirtyPaddingStreams(t, txt)
+	for !ok {
+		txt = append(txt, '!')
+		streams, ok = dirtyPaddingStreams(t, txt)
+	}
+	strictErrs := map[string]error{
+		"header": errHeaderPadding,
+		"block":  errBlockPadding,
+		"index":  errIndexPadding,
+	}
+	for name, z := range streams {
+		_, err := ioutil.ReadAll(mustReader(t, ReaderConfig{}, z))
+		if err != strictErrs[name] {
+			t.Fatalf("%s: ReadAll returned %v; want %v", name,
+				err, strictErrs[name])
+		}
+		c := ReaderConfig{TolerantPadding: true}
+		p, err := ioutil.ReadAll(mustReader(t, c, z))
+		if err != nil {
+			t.Fatalf("%s: TolerantPadding: ReadAll error %s",
+				name, err)
+		}
+		if !bytes.Equal(p, txt) {
+			t.Fatalf("%s: TolerantPadding: decoded %q", name, p)
+		}
+	}
+}
+
+// mustReader creates a reader for the xz stream z.
+func mustReader(t *testing.T, c ReaderConfig, z []byte) *Reader {
+	r, err := c.NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	return r
+}
+
+// recordingReader records the offsets and sizes of the read requests.
+type recordingReader struct {
+	r   io.Reader
+	off int64
+	// requests as offset and size pairs
+	reqs [][2]int64
+}
+
+func (r *recordingReader) Read(p []byte) (n int, err error) {
+	r.reqs = append(r.reqs, [2]int64{r.off, int64(len(p))})
+	n, err = r.r.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+// checkAligned checks that every request ends at a multiple of size.
+// Requests that don't start at a multiple complete a short read.
+func checkAligned(reqs [][2]int64, size int64) error {
+	for _, q := range reqs {
+		if (q[0]+q[1])%size != 0 {
+			return fmt.Errorf("request at %d of size %d not aligned to %d",
+				q[0], q[1], size)
+		}
+	}
+	return nil
+}
+
+func TestReaderAlignedReads(t *testing.T) {
+	data, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	const want = "The quick brown fox jumps over the lazy dog.\n"
+	for _, size := range []int{1, 7, 512, 4096} {
+		for _, half := range []bool{false, true} {
+			var xz io.Reader = bytes.NewReader(data)
+			if half {
+				xz = iotest.HalfReader(xz)
+			}
+			rr := &recordingReader{r: xz}
+			c := ReaderConfig{AlignedReads: size}
+			r, err := c.NewReader(rr)
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("size %d: ReadAll error %s", size, err)
+			}
+			if string(p) != want {
+				t.Fatalf("size %d: got %q; want %q", size, p, want)
+			}
+			if err = checkAligned(rr.reqs, int64(size)); err != nil {
+				t.Fatalf("size %d, half %t: %s", size, half, err)
+			}
+		}
+	}
+	c := ReaderConfig{AlignedReads: -1}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted negative AlignedReads")
+	}
+}
+
+func BenchmarkReaderAlignedReads(b *testing.B) {
+	const testFile = "testdata/enwik7"
+	const size = 4096
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", testFile, err)
+	}
+	uncompressedLen := int64(len(data))
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf)
+	if err != nil {
+		b.Fatalf("NewWriter(buf) error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		b.Fatalf("w.Write(data) error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		b.Fatalf("w.Close() error %s", err)
+	}
+	data = buf.Bytes()
+	b.SetBytes(uncompressedLen)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := &recordingReader{r: bytes.NewReader(data)}
+		c := ReaderConfig{AlignedReads: size}
+		r, err := c.NewReader(rr)
+		if err != nil {
+			b.Fatalf("NewReader error %s", err)
+		}
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			b.Fatalf("io.Copy error %s", err)
+		}
+		if n != uncompressedLen {
+			b.Fatalf("io.Copy got %d; want %d", n, uncompressedLen)
+		}
+		if err = checkAligned(rr.reqs, size); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
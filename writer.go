@@ -5,6 +5,8 @@
 package xz
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"hash"
@@ -17,14 +19,29 @@ import (
 type WriterConfig struct {
 	Properties *lzma.Properties
 	DictCap    int
-	BufSize    int
-	BlockSize  int64
+	// BufSize is the size of the lookahead buffer of the encoder and
+	// of the buffer collecting the output before it is written to
+	// the underlying writer (default: 4096)
+	BufSize   int
+	BlockSize int64
 	// checksum method: CRC32, CRC64 or SHA256 (default: CRC64)
 	CheckSum byte
 	// Forces NoChecksum (default: false)
 	NoCheckSum bool
 	// match algorithm
 	Matcher lzma.MatchAlgorithm
+	// TargetCompressedBlockSize closes a block once its compressed
+	// size reaches the target, which gives blocks of similar
+	// compressed size, e.g. for serving range requests. The size is
+	// approximate: the compressed size of the data in the buffers of
+	// the encoder is not known, so blocks usually exceed the target
+	// by a few KiB. BlockSize still limits the uncompressed size of
+	// a block. The zero value disables the option.
+	TargetCompressedBlockSize int64
+	// StoreIncompressible stores data classified as already
+	// compressed by lzma.IsLikelyCompressed without compressing it.
+	// See the field of the same name of lzma.Writer2Config.
+	StoreIncompressible bool
 }
 
 // fill replaces zero values with default values.
@@ -68,6 +85,9 @@ func (c *WriterConfig) Verify() error {
 	if c.BlockSize <= 0 {
 		return errors.New("xz: block size out of range")
 	}
+	if c.TargetCompressedBlockSize < 0 {
+		return errors.New("xz: target compressed block size negative")
+	}
 	if err := verifyFlags(c.CheckSum); err != nil {
 		return err
 	}
@@ -139,7 +159,9 @@ func nopWriteCloser(w io.Writer) io.WriteCloser {
 type Writer struct {
 	WriterConfig
 
-	xz      io.Writer
+	xz io.Writer
+	// buf collects the output for the underlying writer
+	buf     *bufio.Writer
 	bw      *blockWriter
 	newHash func() hash.Hash
 	h       header
@@ -168,6 +190,7 @@ func (w *Writer) closeBlockWriter() error {
 		return err
 	}
 	w.index = append(w.index, w.bw.record())
+	w.bw = nil
 	return nil
 }
 
@@ -183,10 +206,11 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	}
 	w = &Writer{
 		WriterConfig: c,
-		xz:           xz,
+		buf:          bufio.NewWriterSize(xz, c.BufSize),
 		h:            header{c.CheckSum},
 		index:        make([]record, 0, 4),
 	}
+	w.xz = w.buf
 	if w.newHash, err = newHashFunc(c.CheckSum); err != nil {
 		return nil, err
 	}
@@ -194,10 +218,7 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("w.h.MarshalBinary(): error %w", err)
 	}
-	if _, err = xz.Write(data); err != nil {
-		return nil, err
-	}
-	if err = w.newBlockWriter(); err != nil {
+	if _, err = w.xz.Write(data); err != nil {
 		return nil, err
 	}
 	return w, nil
@@ -210,6 +231,16 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		return 0, errClosed
 	}
 	for {
+		// Blocks are only started if there is data for them, so
+		// no empty blocks are written.
+		if w.bw == nil {
+			if n >= len(p) {
+				return n, nil
+			}
+			if err = w.newBlockWriter(); err != nil {
+				return n, err
+			}
+		}
 		k, err := w.bw.Write(p[n:])
 		n += k
 		if err != errNoSpace {
@@ -218,22 +249,40 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		if err = w.closeBlockWriter(); err != nil {
 			return n, err
 		}
-		if err = w.newBlockWriter(); err != nil {
-			return n, err
+	}
+}
+
+// Flush ends the current block and writes all buffered output to the
+// underlying writer. A reader can then decode all data written so far,
+// because the block is complete including its check. The next Write
+// starts a new block. Every Flush costs the block header, the padding
+// and check of the block and an index record, and it resets the
+// dictionary, so the compression ratio suffers if it is called often.
+func (w *Writer) Flush() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.bw != nil {
+		if err := w.closeBlockWriter(); err != nil {
+			return err
 		}
 	}
+	return w.buf.Flush()
 }
 
-// Close closes the writer and adds the footer to the Writer. Close
-// doesn't close the underlying writer.
+// Close closes the writer, adds the footer and writes all buffered
+// output to the underlying writer. Close doesn't close the underlying
+// writer.
 func (w *Writer) Close() error {
 	if w.closed {
 		return errClosed
 	}
 	w.closed = true
 	var err error
-	if err = w.closeBlockWriter(); err != nil {
-		return err
+	if w.bw != nil {
+		if err = w.closeBlockWriter(); err != nil {
+			return err
+		}
 	}
 
 	f := footer{flags: w.h.flags}
@@ -247,7 +296,26 @@ func (w *Writer) Close() error {
 	if _, err = w.xz.Write(data); err != nil {
 		return err
 	}
-	return nil
+	return w.buf.Flush()
+}
+
+// CloseContext closes the writer like Close, but returns ctx.Err() if
+// the context is done before Close finishes, for instance because
+// the underlying writer blocks. In that case Close continues in the
+// background and the Writer must not be used anymore; the xz stream
+// may be incomplete.
+func (w *Writer) CloseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c := make(chan error, 1)
+	go func() { c <- w.Close() }()
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // countingWriter is a writer that counts all data written to it.
@@ -274,8 +342,10 @@ type blockWriter struct {
 	w         io.WriteCloser
 	n         int64
 	blockSize int64
-	closed    bool
-	headerLen int
+	// target for the compressed size; zero means no target
+	targetSize int64
+	closed     bool
+	headerLen  int
 
 	filters []filter
 	hash    hash.Hash
@@ -284,10 +354,11 @@ type blockWriter struct {
 // newBlockWriter creates a new block writer.
 func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash) (bw *blockWriter, err error) {
 	bw = &blockWriter{
-		cxz:       countingWriter{w: xz},
-		blockSize: c.BlockSize,
-		filters:   c.filters(),
-		hash:      hash,
+		cxz:        countingWriter{w: xz},
+		blockSize:  c.BlockSize,
+		targetSize: c.TargetCompressedBlockSize,
+		filters:    c.filters(),
+		hash:       hash,
 	}
 	bw.w, err = c.newFilterWriteCloser(&bw.cxz, bw.filters)
 	if err != nil {
@@ -358,7 +429,32 @@ var errClosed = errors.New("xz: writer already closed")
 
 var errNoSpace = errors.New("xz: no space")
 
-// Write writes uncompressed data to the block writer.
+// targetStep is the number of bytes written to the block between checks
+// of the compressed size if a target for it is set.
+const targetStep = 4096
+
+// pendingCompressor is implemented by lzma.Writer2. It supports the
+// estimation of the compressed size of the data written to it.
+type pendingCompressor interface {
+	Compress() error
+	Pending() int
+}
+
+// estimatedSize returns the compressed size of the block written so far
+// including the compressed data still buffered by the LZMA2 writer.
+func (bw *blockWriter) estimatedSize() (int64, error) {
+	n := bw.cxz.n
+	if pc, ok := bw.w.(pendingCompressor); ok {
+		if err := pc.Compress(); err != nil {
+			return n, err
+		}
+		n += int64(pc.Pending())
+	}
+	return n, nil
+}
+
+// Write writes uncompressed data to the block writer. If the block is
+// full errNoSpace is returned.
 func (bw *blockWriter) Write(p []byte) (n int, err error) {
 	if bw.closed {
 		return 0, errClosed
@@ -370,11 +466,27 @@ func (bw *blockWriter) Write(p []byte) (n int, err error) {
 		p = p[:t]
 	}
 
-	var werr error
-	n, werr = bw.mw.Write(p)
-	bw.n += int64(n)
-	if werr != nil {
-		return n, werr
+	for len(p) > 0 {
+		q := p
+		if bw.targetSize > 0 {
+			size, err := bw.estimatedSize()
+			if err != nil {
+				return n, err
+			}
+			if size >= bw.targetSize {
+				return n, errNoSpace
+			}
+			if len(q) > targetStep {
+				q = q[:targetStep]
+			}
+		}
+		k, werr := bw.mw.Write(q)
+		n += k
+		bw.n += int64(k)
+		if werr != nil {
+			return n, werr
+		}
+		p = p[k:]
 	}
 	return n, err
 }
@@ -6,11 +6,15 @@ package xz
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
 )
@@ -201,3 +205,307 @@ func BenchmarkWriter(b *testing.B) {
 	}
 	b.ReportMetric(float64(buf.Len())/float64(len(data)), "rate")
 }
+
+// blockingWriter blocks writes after the block channel has been set
+// until it is closed.
+type blockingWriter struct {
+	block chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (n int, err error) {
+	if w.block != nil {
+		<-w.block
+	}
+	return len(p), nil
+}
+
+func TestWriterCloseContext(t *testing.T) {
+	bw := new(blockingWriter)
+	w, err := NewWriter(bw)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, "The quick brown fox"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	bw.block = make(chan struct{})
+	defer close(bw.block)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	if err = w.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("w.CloseContext returned %v; want %v", err,
+			context.DeadlineExceeded)
+	}
+}
+
+// closeRecorder is a writer that records calls to Close and returns
+// an error for them.
+type closeRecorder struct {
+	bytes.Buffer
+	closed int
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed++
+	return errors.New("Close must not be called")
+}
+
+func TestWriterCloseUnderlying(t *testing.T) {
+	var cr closeRecorder
+	w, err := NewWriter(&cr)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, "The quick brown fox"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if cr.closed != 0 {
+		t.Fatalf("Close of underlying writer called %d times",
+			cr.closed)
+	}
+}
+
+// TestWriterGolden compares the output of the writer with files
+// created by the reference implementation using xz -6 -T1.
+func TestWriterGolden(t *testing.T) {
+	tests := []string{
+		"testdata/golden/random.bin",
+		"testdata/golden/ab.txt",
+		"testdata/golden/empty",
+	}
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("ReadFile(%q) error %s", name, err)
+			}
+			want, err := os.ReadFile(name + ".xz")
+			if err != nil {
+				t.Fatalf("ReadFile(%q) error %s", name+".xz", err)
+			}
+			var buf bytes.Buffer
+			w, err := WriterConfig{DictCap: 8 << 20}.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("output differs from %s\ngot  % x\nwant % x",
+					name+".xz", buf.Bytes(), want)
+			}
+
+			r, err := NewReader(bytes.NewReader(want))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decompressed data differs from %s", name)
+			}
+		})
+	}
+}
+
+// writeRecorder records the length of all writes.
+type writeRecorder struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (w *writeRecorder) Write(p []byte) (n int, err error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestWriterBuffersOutput(t *testing.T) {
+	const bufSize = 4096
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"small", []byte("The quick brown fox jumps over the lazy dog.")},
+		{"large", make([]byte, 200000)},
+	}
+	rand.New(rand.NewSource(137)).Read(tests[1].data)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var wr writeRecorder
+			w, err := WriterConfig{BufSize: bufSize}.NewWriter(&wr)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			for p := tc.data; len(p) > 0; {
+				k := 100
+				if k > len(p) {
+					k = len(p)
+				}
+				if _, err = w.Write(p[:k]); err != nil {
+					t.Fatalf("w.Write error %s", err)
+				}
+				p = p[k:]
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			t.Logf("%d bytes written in %d writes", wr.Len(),
+				len(wr.writes))
+			if wr.Len() <= bufSize && len(wr.writes) != 1 {
+				t.Fatalf("%d writes for %d bytes; want 1",
+					len(wr.writes), wr.Len())
+			}
+			for i, n := range wr.writes[:len(wr.writes)-1] {
+				if n < bufSize {
+					t.Fatalf("write %d has only %d bytes", i, n)
+				}
+			}
+
+			r, err := NewReader(&wr.Buffer)
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Fatalf("decompressed data differs")
+			}
+		})
+	}
+}
+
+func TestWriterTargetCompressedBlockSize(t *testing.T) {
+	const target = 64 * 1024
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(157)), 2<<20))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		TargetCompressedBlockSize: target,
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	// large writes must be split as well
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if len(w.index) < 4 {
+		t.Fatalf("got %d blocks; want at least 4", len(w.index))
+	}
+	for i, rec := range w.index {
+		t.Logf("block %d: unpadded size %d", i, rec.unpaddedSize)
+		if i == len(w.index)-1 {
+			if rec.unpaddedSize > target+16*1024 {
+				t.Errorf("last block has size %d",
+					rec.unpaddedSize)
+			}
+			break
+		}
+		if !(target <= rec.unpaddedSize &&
+			rec.unpaddedSize <= target+16*1024) {
+			t.Errorf("block %d has size %d; want about %d",
+				i, rec.unpaddedSize, target)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, txt) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	parts := [][]byte{
+		[]byte("The quick brown fox jumps over the lazy dog.\n"),
+		bytes.Repeat([]byte("Lorem ipsum dolor sit amet. "), 1000),
+		[]byte("tail"),
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	var written []byte
+	for _, part := range parts {
+		if _, err = w.Write(part); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		written = append(written, part...)
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		n := buf.Len()
+		if err = w.Flush(); err != nil {
+			t.Fatalf("second w.Flush error %s", err)
+		}
+		if buf.Len() != n {
+			t.Fatalf("Flush without data wrote %d bytes",
+				buf.Len()-n)
+		}
+
+		// The prefix is a stream without index and footer. All
+		// data written must be decodable from it.
+		prefix := append([]byte(nil), buf.Bytes()...)
+		r, err := NewReader(bytes.NewReader(prefix))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		p := make([]byte, len(written))
+		if _, err = io.ReadFull(r, p); err != nil {
+			t.Fatalf("ReadFull of prefix error %s", err)
+		}
+		if !bytes.Equal(p, written) {
+			t.Fatalf("decoded prefix differs")
+		}
+		if _, err = r.Read(p[:1]); err == nil {
+			t.Fatalf("Read beyond prefix returned no error")
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if len(w.index) != len(parts) {
+		t.Fatalf("stream has %d blocks; want %d", len(w.index),
+			len(parts))
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, written) {
+		t.Fatalf("decoded data differs")
+	}
+	if err = w.Flush(); err != errClosed {
+		t.Fatalf("Flush after Close returned %v; want %v", err,
+			errClosed)
+	}
+}